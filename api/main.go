@@ -0,0 +1,308 @@
+// Namespace: api/main.go
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../database" // DB Handler Package
+	"../logger"   // Leveled Logger
+)
+
+// Global Variable(s)
+var mutex sync.Mutex
+var currentStage = "idle"
+var stageStartedAt = time.Now()
+var transfers = map[*Transfer]bool{}     // Active transfer(s)
+var finished = map[string]*StageTotals{} // Unregistered transfer(s) per stage
+
+// Transfer - Single file (or container, for sync) being processed by a worker
+type Transfer struct {
+	Stage     string
+	Container string
+	Blob      string
+	StartedAt time.Time
+	bytes     int64 // Accessed atomically
+	total     int64 // Accessed atomically
+}
+
+// Context key for the transfer of a request
+type transferKey struct{}
+
+// StageTotals - Transfer(s) of a stage since the start of the run
+type StageTotals struct {
+	Files int64 // Finished (completed or failed) transfer(s)
+	Bytes int64 // Bytes of finished and active transfer(s)
+}
+
+// TransferStatus - Snapshot of an active transfer
+type TransferStatus struct {
+	Stage     string    `json:"stage"`
+	Container string    `json:"container"`
+	Blob      string    `json:"blob,omitempty"`
+	Bytes     int64     `json:"bytes"`
+	Total     int64     `json:"total,omitempty"`
+	Percent   *float64  `json:"percent,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// SetStage - Record the command currently running
+func SetStage(stage string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	currentStage, stageStartedAt = stage, time.Now()
+}
+
+// StartTransfer - Register transfer shown by /status until Done is called
+func StartTransfer(stage string, container string, blob string) *Transfer {
+	transfer := &Transfer{Stage: stage, Container: container, Blob: blob, StartedAt: time.Now()}
+
+	mutex.Lock()
+	transfers[transfer] = true
+	mutex.Unlock()
+
+	return transfer
+}
+
+// Done - Unregister transfer, its bytes are added to the totals of its stage
+func (t *Transfer) Done() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !transfers[t] {
+		return
+	}
+	delete(transfers, t)
+
+	if finished[t.Stage] == nil {
+		finished[t.Stage] = &StageTotals{}
+	}
+	finished[t.Stage].Files++
+	finished[t.Stage].Bytes += atomic.LoadInt64(&t.bytes)
+}
+
+// SetTotal - Set expected size in bytes
+func (t *Transfer) SetTotal(total int64) {
+	atomic.StoreInt64(&t.total, total)
+}
+
+// Add - Add transferred bytes
+func (t *Transfer) Add(bytes int64) {
+	atomic.AddInt64(&t.bytes, bytes)
+}
+
+// Reader - Wrap reader so that every read is added to the transfer
+func (t *Transfer) Reader(reader io.Reader) io.Reader {
+	return &transferReader{reader: reader, transfer: t}
+}
+
+// Transfer counting io.Reader
+type transferReader struct {
+	reader   io.Reader
+	transfer *Transfer
+}
+
+// Read - Read and count the bytes read
+func (r *transferReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.transfer.Add(int64(n))
+
+	return n, err
+}
+
+// WithTransfer - Attach transfer to context, so that Transport can account for its requests
+func WithTransfer(ctx context.Context, t *Transfer) context.Context {
+	return context.WithValue(ctx, transferKey{}, t)
+}
+
+// Transport - Wrap HTTP transport to add successful PUT body size to the transfer of the request context
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &transferTransport{base: base}
+}
+
+// Transfer accounting http.RoundTripper
+type transferTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip - Send request and account for uploaded bytes
+func (t *transferTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+
+	transfer, ok := req.Context().Value(transferKey{}).(*Transfer)
+	if ok && err == nil && req.Method == http.MethodPut && resp.StatusCode/100 == 2 && req.ContentLength > 0 {
+		transfer.Add(req.ContentLength)
+	}
+
+	return resp, err
+}
+
+// Serve - Expose read-only JSON API (/status, /containers, /items) on addr in the background
+func Serve(addr string, log *logger.Logger) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", readOnly(handleStatus))
+	mux.HandleFunc("/containers", readOnly(handleContainers))
+	mux.HandleFunc("/items", readOnly(handleItems))
+
+	go func() {
+		log.Info("Serving Status API", logger.Fields{"addr": addr})
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Error("Status API Failed", logger.Fields{"addr": addr, "error": err})
+		}
+	}()
+}
+
+// ActiveTransfers - Transfer(s) of the stage (empty: every stage) in progress, oldest first
+func ActiveTransfers(stage string) []TransferStatus {
+	mutex.Lock()
+	current := make([]TransferStatus, 0, len(transfers))
+	for transfer := range transfers {
+		if len(stage) != 0 && transfer.Stage != stage {
+			continue
+		}
+
+		snapshot := TransferStatus{
+			Stage:     transfer.Stage,
+			Container: transfer.Container,
+			Blob:      transfer.Blob,
+			Bytes:     atomic.LoadInt64(&transfer.bytes),
+			Total:     atomic.LoadInt64(&transfer.total),
+			StartedAt: transfer.StartedAt,
+		}
+		if snapshot.Total > 0 {
+			percent := float64(snapshot.Bytes) * 100 / float64(snapshot.Total)
+			if percent > 100 {
+				percent = 100
+			}
+			snapshot.Percent = &percent
+		}
+
+		current = append(current, snapshot)
+	}
+	mutex.Unlock()
+
+	sort.Slice(current, func(i, j int) bool { return current[i].StartedAt.Before(current[j].StartedAt) })
+
+	return current
+}
+
+// Totals - Finished transfer(s) and bytes (finished and active transfer(s)) of the stage
+func Totals(stage string) StageTotals {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var totals StageTotals
+	if stageTotals := finished[stage]; stageTotals != nil {
+		totals = *stageTotals
+	}
+	for transfer := range transfers {
+		if transfer.Stage == stage {
+			totals.Bytes += atomic.LoadInt64(&transfer.bytes)
+		}
+	}
+
+	return totals
+}
+
+// GET /status
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	mutex.Lock()
+	stage, startedAt := currentStage, stageStartedAt
+	mutex.Unlock()
+
+	current := ActiveTransfers("")
+	workers := map[string]int{}
+	for _, transfer := range current {
+		workers[transfer.Stage]++
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"stage":      stage,
+		"started_at": startedAt,
+		"workers":    workers,
+		"transfers":  current,
+	})
+}
+
+// GET /containers
+func handleContainers(w http.ResponseWriter, r *http.Request) {
+	statusCounts, err := database.GetContainerStatusCounts()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	counts, total := map[string]int64{}, int64(0)
+	for containerStatus, count := range statusCounts {
+		counts[strconv.Itoa(containerStatus)] = count
+		total += count
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"total": total, "counts": counts})
+}
+
+// GET /items?status=failed&stage=azure&page=1&per_page=50
+func handleItems(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	perPage, _ := strconv.Atoi(query.Get("per_page"))
+	if perPage < 1 || perPage > 500 {
+		perPage = 50
+	}
+
+	if err := database.ValidateSyncFilter(query.Get("status"), query.Get("stage")); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	items, total, err := database.GetSyncItems(query.Get("status"), query.Get("stage"), (page-1)*perPage, perPage)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"page":     page,
+		"per_page": perPage,
+		"total":    total,
+		"items":    items,
+	})
+}
+
+// Reject method(s) other than GET / HEAD
+func readOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method " + r.Method + " not allowed"})
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// Write JSON response
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
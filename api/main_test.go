@@ -0,0 +1,49 @@
+// Namespace: api/main_test.go
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"../database"    // DB Handler Package
+	"../fakestorage" // Fake Blob / S3 Service(s) (test DB)
+)
+
+func TestHandlerStatusCodes(t *testing.T) {
+	fakestorage.OpenDB(t)
+
+	cases := []struct {
+		method, target string
+		handler        http.HandlerFunc
+		want           int
+	}{
+		{http.MethodGet, "/items?status=failed&stage=s3", handleItems, http.StatusOK},
+		{http.MethodHead, "/containers", handleContainers, http.StatusOK},
+		{http.MethodGet, "/items?status=broken", handleItems, http.StatusBadRequest},
+		{http.MethodGet, "/items?status=failed&stage=gcs", handleItems, http.StatusBadRequest},
+		{http.MethodPost, "/items", handleItems, http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/status", handleStatus, http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range cases {
+		recorder := httptest.NewRecorder()
+		readOnly(tc.handler)(recorder, httptest.NewRequest(tc.method, tc.target, nil))
+		if recorder.Code != tc.want {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.target, recorder.Code, tc.want)
+		}
+	}
+
+	// Server side failure: tables dropped under the API
+	if !database.CleanUp() {
+		t.Fatal("drop tables failed")
+	}
+	for _, handler := range []http.HandlerFunc{handleItems, handleContainers} {
+		recorder := httptest.NewRecorder()
+		readOnly(handler)(recorder, httptest.NewRequest(http.MethodGet, "/items", nil))
+		if recorder.Code != http.StatusInternalServerError {
+			t.Errorf("DB error = %d, want %d", recorder.Code, http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Namespace: database/lease.go
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"../logger" // Leveled Logger
+)
+
+// Global Constant(s)
+const (
+	statusInterrupted = 3 // Picked up again by the next claim
+	statusInProgress  = 4 // Leased by a worker (lease_owner) until lease_expires
+)
+
+// Global Variable(s)
+var workerID = defaultWorkerID()
+var leaseDuration = 5 * time.Minute
+
+// ErrLeaseLost - Row was recovered (lease expired) and may be claimed by another worker
+var ErrLeaseLost = errors.New("lease lost, row is no longer owned by this worker")
+
+// SetLease - Worker ID recorded in claimed row(s) and how long a claim lasts without renewal
+func SetLease(id string, duration time.Duration) {
+	if len(id) != 0 {
+		workerID = id
+	}
+	if duration > 0 {
+		leaseDuration = duration
+	}
+}
+
+// WorkerID - ID of this process in lease_owner
+func WorkerID() string {
+	return workerID
+}
+
+// Default worker ID: hostname-pid
+func defaultWorkerID() string {
+	hostname, _ := os.Hostname()
+	if len(hostname) == 0 {
+		hostname = "worker"
+	}
+
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// ClaimAzureContent - Lease up to limit container:blob:snapshot mapping(s) with pending (or interrupted) download
+// (skip: id(s) of row(s) left out, e.g. which hit a full disk in this run)
+func ClaimAzureContent(ctx context.Context, limit int, skip ...int64) (map[int]map[string]string, error) {
+	pending, args := "azure_status IN (0, 3)", []interface{}{}
+	if len(skip) != 0 {
+		pending += " AND id NOT IN (?" + strings.Repeat(",?", len(skip)-1) + ")"
+		for _, id := range skip {
+			args = append(args, id)
+		}
+	}
+
+	return claimContent(ctx, "azure", pending, args, "id", []string{"container", "blob", "snapshot", "size"}, limit)
+}
+
+// Condition of an older snapshot row (alias older) of the sync row which will still be uploaded by this stage:
+// download / upload pending, interrupted or in progress, and not shipped on a Snowball device
+const olderSnapshotCondition = `older.container = sync.container AND older.blob = sync.blob
+	AND older.snapshot <> '' AND (sync.snapshot = '' OR older.snapshot < sync.snapshot)`
+const olderPendingCondition = `older.azure_status IN (0, 1, 3, 4) AND older.s3_status IN (0, 3, 4) AND older.snowball_job IS NULL`
+
+// ClaimS3Content - Lease up to limit container:blob:snapshot mapping(s) with pending (or interrupted) upload
+// (row(s) shipped on a Snowball device are left to the Snowball import)
+// (inOrder: a snapshot / base blob row waits until every older snapshot of its blob which is still to be
+// uploaded is, so that S3 object version(s) of one key are created oldest first, the base blob being the latest
+// version; a failed or shipped older snapshot doesn't hold it back, see SkippedOlderSnapshot)
+func ClaimS3Content(ctx context.Context, limit int, inOrder bool) (map[int]map[string]string, error) {
+	pending := "azure_status = 1 AND s3_status IN (0, 3) AND snowball_job IS NULL"
+	if inOrder {
+		pending += " AND NOT EXISTS (SELECT 1 FROM sync older WHERE " + olderSnapshotCondition + " AND " + olderPendingCondition + ")"
+	}
+
+	return claimContent(ctx, "s3", pending, nil, "id desc",
+		[]string{"container", "blob", "snapshot", "content_type", "cache_control", "content_disposition", "content_encoding", "metadata"}, limit)
+}
+
+// SkippedOlderSnapshot - Newest older snapshot of a row which won't be uploaded before it: download / upload
+// failed, or shipped on a Snowball device ("": none, the object version(s) of the row's key stay in order)
+func SkippedOlderSnapshot(containerName string, blobName string, snapshot string) (string, error) {
+	var skipped string
+	err := dbConnection.QueryRow(`
+		SELECT COALESCE(MAX(older.snapshot), '') FROM sync older, sync
+		WHERE sync.container = ? AND sync.blob = ? AND sync.snapshot = ? AND `+olderSnapshotCondition+`
+			AND (older.azure_status = 2 OR older.s3_status = 2 OR older.snowball_job IS NOT NULL)`,
+		containerName, blobName, snapshot).Scan(&skipped)
+	if err != nil {
+		return "", fmt.Errorf("select skipped older snapshot: %v", err)
+	}
+
+	return skipped, nil
+}
+
+// Claim row(s) in one transaction, so that concurrent process(es) never get the same row
+// (SQLite: the transaction holds the write lock; PostgreSQL: selected row(s) are locked and skipped by other claim(s))
+//
+// @param ctx Context, stage string (azure / s3), pending condition and its argument(s), order, columns slice, limit integer
+// @return Maps (column(s) and "id"), error
+func claimContent(ctx context.Context, stage string, pending string, pendingArgs []interface{}, order string, columns []string, limit int) (map[int]map[string]string, error) {
+	var syncList = map[int]map[string]string{}
+	statusColumn, errorColumn := stage+"_status", stage+"_error"
+	label := map[string]string{"azure": "Azure", "s3": "S3"}[stage]
+
+	tx, txErr := dbConnection.BeginTx(ctx, nil)
+	if txErr != nil && ctx.Err() != nil {
+		return syncList, nil // Interrupted
+	} else if txErr != nil {
+		return nil, fmt.Errorf("[%s] begin claim: %v", label, txErr)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	// Recovering Expired Lease(s) of crashed / stopped worker(s)
+	recoverResult, recoverErr := tx.Exec(`
+		UPDATE sync SET `+statusColumn+` = ?, `+errorColumn+` = ?, lease_owner = NULL, lease_expires = NULL
+		WHERE `+statusColumn+` = ? AND lease_expires < ?`, statusInterrupted, "lease expired", statusInProgress, now.Unix())
+	if recoverErr != nil {
+		return nil, fmt.Errorf("[%s] recover expired leases: %v", label, recoverErr)
+	}
+	if recovered, _ := recoverResult.RowsAffected(); recovered != 0 {
+		dbLog.Warn(fmt.Sprintf("[%s] Recovered %d expired lease(s)", label, recovered))
+	}
+
+	// Selecting Eligible Row(s) (as text, numeric column(s) included)
+	var selectColumns []string
+	for _, column := range columns {
+		selectColumns = append(selectColumns, "COALESCE(CAST("+column+" AS TEXT), '')")
+	}
+	syncRows, syncErr := tx.QueryContext(ctx, "SELECT id, "+strings.Join(selectColumns, ", ")+" FROM sync WHERE "+pending+" ORDER BY "+order+" LIMIT ?"+store.ClaimLock(), append(pendingArgs, limit)...)
+	if syncErr != nil && ctx.Err() != nil {
+		return syncList, nil // Interrupted
+	} else if syncErr != nil {
+		return nil, fmt.Errorf("[%s] select pending blobs: %v", label, syncErr)
+	}
+
+	var ids []interface{}
+	for syncRows.Next() {
+		var id int64
+		values := make([]string, len(columns))
+		pointers := []interface{}{&id}
+		for idx := range values {
+			pointers = append(pointers, &values[idx])
+		}
+
+		if scanErr := syncRows.Scan(pointers...); scanErr != nil {
+			syncRows.Close()
+			return nil, fmt.Errorf("[%s] scan pending blob: %v", label, scanErr)
+		}
+
+		syncList[len(ids)] = map[string]string{"id": strconv.FormatInt(id, 10)}
+		for idx, column := range columns {
+			syncList[len(ids)][column] = values[idx]
+		}
+		ids = append(ids, id)
+	}
+	syncRows.Close()
+
+	if loopErr := syncRows.Err(); loopErr != nil && ctx.Err() != nil {
+		return map[int]map[string]string{}, nil // Interrupted
+	} else if loopErr != nil {
+		return nil, fmt.Errorf("[%s] iterate pending blobs: %v", label, loopErr)
+	}
+
+	if len(ids) == 0 { // Nothing to Claim, recovered lease(s) kept
+		if commitErr := tx.Commit(); commitErr != nil && ctx.Err() == nil {
+			return nil, fmt.Errorf("[%s] commit recovered leases: %v", label, commitErr)
+		}
+		return syncList, nil
+	}
+
+	// Leasing Selected Row(s)
+	args := append([]interface{}{statusInProgress, workerID, now.Add(leaseDuration).Unix()}, ids...)
+	_, claimErr := tx.Exec(`
+		UPDATE sync SET `+statusColumn+` = ?, lease_owner = ?, lease_expires = ?
+		WHERE id IN (?`+strings.Repeat(",?", len(ids)-1)+`)`, args...)
+	if claimErr != nil {
+		return nil, fmt.Errorf("[%s] claim pending blobs: %v", label, claimErr)
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil && ctx.Err() != nil {
+		return map[int]map[string]string{}, nil // Interrupted
+	} else if commitErr != nil {
+		return nil, fmt.Errorf("[%s] commit claim: %v", label, commitErr)
+	}
+
+	dbLog.Debug(fmt.Sprintf("[%s] Claimed %d row(s)", label, len(ids)), logger.Fields{"worker": workerID})
+	return syncList, nil
+}
+
+// KeepLease - Renew the lease of a claimed row until stop is called
+// (the returned context is cancelled when the lease is lost, e.g. after the DB was unreachable for too long)
+func KeepLease(ctx context.Context, container string, blob string, snapshot string) (context.Context, func()) {
+	leaseCtx, cancel := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(leaseDuration / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				renewed, renewErr := dbWriter.Exec("UPDATE sync SET lease_expires = ? WHERE container = ? AND blob = ? AND snapshot = ? AND lease_owner = ?",
+					time.Now().Add(leaseDuration).Unix(), container, blob, snapshot, workerID)
+				if renewErr != nil {
+					dbLog.Warn("Lease Renewal Failed", logger.Fields{"container": container, "blob": blob, "snapshot": snapshot, "error": renewErr})
+				} else if renewed == 0 {
+					dbLog.Error("Lease Lost", logger.Fields{"container": container, "blob": blob, "snapshot": snapshot, "worker": workerID})
+					cancel()
+					return
+				}
+			case <-stopped:
+				return
+			case <-leaseCtx.Done():
+				return
+			}
+		}
+	}()
+
+	return leaseCtx, func() {
+		close(stopped)
+		cancel()
+	}
+}
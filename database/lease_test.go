@@ -0,0 +1,172 @@
+// Namespace: database/lease_test.go
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Lease as worker id (duration: renewal every third of it), restored with the test
+func leaseAs(t *testing.T, id string, duration time.Duration) {
+	t.Helper()
+
+	previousID, previousDuration := workerID, leaseDuration
+	t.Cleanup(func() { workerID, leaseDuration = previousID, previousDuration })
+	workerID, leaseDuration = id, duration
+}
+
+// Lease column(s) of a sync row
+func leaseOf(t *testing.T, blob string) (int, string, string, int64) {
+	t.Helper()
+
+	var status int
+	var azureError, owner string
+	var expires int64
+	err := dbConnection.QueryRow(`SELECT azure_status, COALESCE(azure_error, ''), COALESCE(lease_owner, ''), COALESCE(lease_expires, 0)
+		FROM sync WHERE container = ? AND blob = ?`, "media", blob).Scan(&status, &azureError, &owner, &expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return status, azureError, owner, expires
+}
+
+// Pending sync row(s) media/0.mp4 ... media/<count-1>.mp4
+func insertPending(t *testing.T, count int) {
+	t.Helper()
+
+	var rows []SyncRow
+	for idx := 0; idx < count; idx++ {
+		rows = append(rows, SyncRow{Container: "media", Blob: fmt.Sprintf("%d.mp4", idx)})
+	}
+	for _, result := range InsertBlobs(rows) {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+	}
+}
+
+func TestClaimDisjointRows(t *testing.T) {
+	openTestDB(t)
+	insertPending(t, 12)
+
+	claimed := map[string]string{}
+	for _, worker := range []string{"worker-1", "worker-2", "worker-1", "worker-2"} {
+		leaseAs(t, worker, time.Minute)
+		rows, err := ClaimAzureContent(context.Background(), 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, row := range rows {
+			if owner, taken := claimed[row["blob"]]; taken {
+				t.Errorf("%s claimed by %s and %s", row["blob"], owner, worker)
+			}
+			claimed[row["blob"]] = worker
+			if status, _, owner, _ := leaseOf(t, row["blob"]); status != statusInProgress || owner != worker {
+				t.Errorf("%s: status %d, owner %q; want %d, %s", row["blob"], status, owner, statusInProgress, worker)
+			}
+		}
+	}
+
+	if len(claimed) != 12 {
+		t.Errorf("%d row(s) claimed, want 12", len(claimed))
+	}
+}
+
+func TestClaimRecoversExpiredLease(t *testing.T) {
+	openTestDB(t)
+	insertPending(t, 2)
+
+	leaseAs(t, "crashed", time.Minute)
+	if rows, err := ClaimAzureContent(context.Background(), 1); err != nil || len(rows) != 1 || rows[0]["blob"] != "0.mp4" {
+		t.Fatalf("claim = %v, %v", rows, err)
+	}
+	if _, err := dbConnection.Exec("UPDATE sync SET lease_expires = ? WHERE blob = ?", time.Now().Add(-time.Second).Unix(), "0.mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Any claim (even of nothing) recovers the row of the crashed worker as interrupted
+	leaseAs(t, "worker-2", time.Minute)
+	if _, err := ClaimAzureContent(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if status, azureError, owner, expires := leaseOf(t, "0.mp4"); status != statusInterrupted || azureError != "lease expired" || owner != "" || expires != 0 {
+		t.Errorf("recovered row: status %d, error %q, owner %q, expires %d", status, azureError, owner, expires)
+	}
+	if status, _, _, _ := leaseOf(t, "1.mp4"); status != 0 {
+		t.Errorf("unclaimed row: status %d, want 0", status)
+	}
+}
+
+func TestSetFlagAfterLeaseLost(t *testing.T) {
+	openTestDB(t)
+	insertPending(t, 1)
+
+	// worker-1's lease expires, worker-2 recovers and claims the row
+	leaseAs(t, "worker-1", time.Minute)
+	if rows, err := ClaimAzureContent(context.Background(), 1); err != nil || len(rows) != 1 {
+		t.Fatalf("claim = %v, %v", rows, err)
+	}
+	dbConnection.Exec("UPDATE sync SET lease_expires = ? WHERE blob = ?", time.Now().Add(-time.Second).Unix(), "0.mp4")
+	leaseAs(t, "worker-2", time.Minute)
+	if rows, err := ClaimAzureContent(context.Background(), 1); err != nil || len(rows) != 1 {
+		t.Fatalf("reclaim = %v, %v", rows, err)
+	}
+
+	leaseAs(t, "worker-1", time.Minute)
+	if err := SetAzureFlag("media", "0.mp4", "", 1, ""); err != ErrLeaseLost {
+		t.Errorf("late download flag = %v, want %v", err, ErrLeaseLost)
+	}
+
+	leaseAs(t, "worker-2", time.Minute)
+	if err := SetAzureFlag("media", "0.mp4", "", 1, ""); err != nil {
+		t.Fatalf("owner's download flag = %v", err)
+	}
+
+	// Same for the upload stage
+	if rows, err := ClaimS3Content(context.Background(), 1, false); err != nil || len(rows) != 1 {
+		t.Fatalf("upload claim = %v, %v", rows, err)
+	}
+	leaseAs(t, "worker-1", time.Minute)
+	if err := SetS3Flag("media", "0.mp4", "", 2, "failed"); err != ErrLeaseLost {
+		t.Errorf("late upload flag = %v, want %v", err, ErrLeaseLost)
+	}
+}
+
+func TestKeepLease(t *testing.T) {
+	openTestDB(t)
+	insertPending(t, 1)
+
+	leaseAs(t, "worker-1", 30*time.Millisecond) // Renewed every 10ms
+	if rows, err := ClaimAzureContent(context.Background(), 1); err != nil || len(rows) != 1 {
+		t.Fatalf("claim = %v, %v", rows, err)
+	}
+	if _, err := dbConnection.Exec("UPDATE sync SET lease_expires = ? WHERE blob = ?", 1, "0.mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, stop := KeepLease(context.Background(), "media", "0.mp4", "")
+	defer stop()
+
+	deadline := time.Now().Add(time.Second)
+	for _, _, _, expires := leaseOf(t, "0.mp4"); expires < time.Now().Unix()-1; _, _, _, expires = leaseOf(t, "0.mp4") {
+		if time.Now().After(deadline) {
+			t.Fatalf("lease_expires not renewed: %d", expires)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Row taken over by another worker: the transfer context is cancelled
+	if _, err := dbConnection.Exec("UPDATE sync SET lease_owner = ? WHERE blob = ?", "worker-2", "0.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("lost lease did not cancel the context")
+	}
+}
@@ -3,54 +3,147 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3" // SQLite3 Connection
+	"../helpers" // Helper Package
+	"../logger"  // Leveled Logger
 )
 
+// Global Constant(s)
+const writeBatchSize = 1000 // Max. write(s) per transaction
+const syncIndex = "sync_container_blob_snapshot"
+const legacySyncIndex = "sync_container_blob" // UNIQUE(container, blob), before snapshot row(s)
+
 // Global Variable(s)
-var dbConnection *sql.DB
-var liveContainerList = []string{
-	"employees-data", "reports"} // Containers which are live and gets updated frequently
+var dbConnection *storeDB // Shared connection pool (Open)
+var dryRun bool           // Report DB mutation(s) instead of executing them
+var dbLog = logger.Default()
+
+// BlobProperties - Azure blob HTTP headers and user metadata (x-ms-meta-*)
+type BlobProperties struct {
+	ContentType, CacheControl, ContentDisposition, ContentEncoding string
+	Metadata                                                       map[string]string
+	Size                                                           int64
+}
 
-// Handling Error
-func handleDBErrors(err error, reason string) {
-	if err != nil {
-		log.Println("DB Issue: ", reason)
-		log.Fatal(err)
-	}
+// SyncRow - Blob listed from Azure, to be inserted into the sync table
+type SyncRow struct {
+	Container, Blob string
+	Snapshot        string // Snapshot time of a snapshot row, empty for the base blob
+	Properties      BlobProperties
+}
+
+// SyncItem - Sync table row (status API / report)
+type SyncItem struct {
+	ID          int64  `json:"id"`
+	Container   string `json:"container"`
+	Blob        string `json:"blob"`
+	Snapshot    string `json:"snapshot,omitempty"` // Snapshot of the blob (empty: base blob)
+	AzureStatus int    `json:"azure_status"`
+	AzureError  string `json:"azure_error,omitempty"`
+	S3Status    int    `json:"s3_status"`
+	S3Error     string `json:"s3_error,omitempty"`
+	UpdatedAt   string `json:"updated_at,omitempty"`
+	LeaseOwner  string `json:"lease_owner,omitempty"` // Worker holding the row (status 4)
+}
+
+// UploadedRow - Sync table row uploaded to S3, with the local copy it may have left in the media folder (prune)
+type UploadedRow struct {
+	ID              int64
+	Container, Blob string
+	Snapshot        string
+	Size            int64
+	UploadedAt      string // Empty when unknown (e.g. imported row)
+	Live            bool   // Container is live, its blob(s) get updated frequently
+}
+
+// ContainerTotal - Sync table totals of a container (report)
+type ContainerTotal struct {
+	Container       string `json:"container"`
+	Status          int    `json:"status"` // containers.status (-1: not in containers table)
+	Files           int64  `json:"files"`
+	Bytes           int64  `json:"bytes"`
+	Downloaded      int64  `json:"downloaded"`
+	DownloadedBytes int64  `json:"downloaded_bytes"`
+	Uploaded        int64  `json:"uploaded"`
+	UploadedBytes   int64  `json:"uploaded_bytes"`
+	Failed          int64  `json:"failed"`
+}
+
+// ErrorCount - Number of rows failing with the same error message (report)
+type ErrorCount struct {
+	Stage   string `json:"stage"`
+	Message string `json:"message"`
+	Count   int64  `json:"count"`
+}
+
+// Throughput - Files and bytes completed in a period (report)
+type Throughput struct {
+	Period string `json:"period"`
+	Stage  string `json:"stage"`
+	Files  int64  `json:"files"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// SetDryRun - Report DB mutation(s) instead of executing them
+func SetDryRun(enabled bool) {
+	dryRun = enabled
+}
+
+// SetLogger - Logger used by every DB helper
+func SetLogger(l *logger.Logger) {
+	dbLog = l
 }
 
-// InitConnection - Initialize DB Connection
-func InitConnection() *sql.DB {
-	// Initializing DB Connection
-	fmt.Println("Initializing DB Connection...")
+// Open - Open the state store shared by every package: driver "sqlite" (source: DB file) or "postgres" (source: URL)
+func Open(driver string, source string, busyTimeout time.Duration) (*sql.DB, error) {
+	backend, ok := stores[driver]
+	if !ok {
+		return nil, fmt.Errorf("invalid DB driver %q (%s)", driver, strings.Join(StoreNames(), ", "))
+	}
+
+	dbLog.Debug("Initializing DB Connection...", logger.Fields{"driver": driver})
+
+	connection, openErr := backend.Open(source, busyTimeout)
+	if openErr != nil {
+		return nil, openErr
+	}
 
-	// Establishing SQLite Connection
-	dbConnection, _ = sql.Open("sqlite3", "./storage.sqlite?cache=shared&mode=rwc")
+	// Connecting (sql.Open is lazy), so that a bad path / URL fails here
+	if pingErr := connection.Ping(); pingErr != nil {
+		connection.Close()
+		return nil, pingErr
+	}
 
-	// Returns DB Connection
-	return dbConnection
+	store = backend
+	dbConnection = &storeDB{connection}
+	dbWriter = NewWriter(connection, writeBatchSize)
+	return connection, nil
 }
 
-// CloseConnection - Close DB Connection
-func CloseConnection() {
-	// Closing DB Connection
-	fmt.Println("Closing DB Connection...")
-	dbConnection.Close()
+// Close - Commit queued write(s) and close the shared DB connection pool
+func Close() error {
+	if dbConnection == nil {
+		return nil
+	}
+
+	dbLog.Debug("Closing DB Connection...")
+	dbWriter.Close()
+	return dbConnection.Close()
 }
 
 // CleanUp - Drops/Delete the "older" table and creates afresh.
 func CleanUp() bool {
-	dbConnection := InitConnection() // Create DB Conection
-	defer CloseConnection()          // Close DB Connection
+	dbLog.Info("DB: CleanUP!")
 
-	fmt.Println("-------------")
-	fmt.Println("DB: CleanUP!")
-	fmt.Println("-------------")
+	if dryRun {
+		dbLog.Info("[Dry Run] would drop tables containers, sync")
+		return true
+	}
 
 	_, containerErr := dbConnection.Exec("DROP TABLE IF EXISTS containers") // Drop "container" Table
 	_, syncErr := dbConnection.Exec("DROP TABLE IF EXISTS sync")            // Drop "sync" Table
@@ -59,39 +152,40 @@ func CleanUp() bool {
 		return false
 	}
 
-	fmt.Println("Deleted Table(s): containers, sync.")
+	dbLog.Info("Deleted Table(s): containers, sync.")
 	return true // Success
 }
 
 // BuildTable - Create sync table
 func BuildTable() bool {
-	dbConnection := InitConnection() // Create DB Conection
-	defer CloseConnection()          // Close DB Connection
+	if dryRun {
+		return reportBuildTable(dbConnection)
+	}
 
 	// Checking Container Table
-	fmt.Println("Checking Container Table...")
+	dbLog.Debug("Checking Container Table...")
 
 	// Creating Container Table (If Not Exists)
-	createContainer, _ := dbConnection.Prepare(`
+	_, containerErr := dbConnection.Exec(`
 		CREATE TABLE IF NOT EXISTS containers (
 			name TEXT UNIQUE,
 			status INTEGER DEFAULT 0,
 			created_at TEXT
 	)`)
-	_, containerErr := createContainer.Exec()
 
 	// Checking if Error occurred
 	if containerErr != nil {
+		dbLog.Error("Unable to create table", logger.Fields{"table": "containers", "error": containerErr})
 		return false
 	}
 
 	// Checking Sync Table
-	fmt.Println("Checking Sync Table...")
+	dbLog.Debug("Checking Sync Table...")
 
 	// Creating Sync Table (If Not Exists)
-	createSync, _ := dbConnection.Prepare(`
+	_, syncErr := dbConnection.Exec(`
 		CREATE TABLE IF NOT EXISTS sync (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			` + store.IDColumn() + `,
 			container TEXT NOT NULL,
 			blob TEXT,
 			azure_status INTEGER DEFAULT 0,
@@ -101,41 +195,260 @@ func BuildTable() bool {
 			created_at TEXT,
 			updated_at TEXT
 	)`)
-	_, syncErr := createSync.Exec()
 
 	// Checking if Error occurred
 	if syncErr != nil {
+		dbLog.Error("Unable to create table", logger.Fields{"table": "sync", "error": syncErr})
+		return false
+	}
+
+	// Adding Column(s) to older Container / Sync Table
+	if !migrateTable(dbConnection, "containers", containerColumns) || !migrateTable(dbConnection, "sync", syncColumns) {
+		return false
+	}
+
+	// One Row per Container:Blob:Snapshot (used by upsert(s))
+	if !createSyncIndex(dbConnection) {
+		return false
+	}
+
+	// Live / Excluded Container List(s)
+	if !registryBuildTable(dbConnection) {
 		return false
 	}
 
 	return true // Success
 }
 
-// InsertInContainer - Insert new entry into the containers table
-func InsertInContainer(container string) bool {
-	dbConnection := InitConnection() // Create DB Conection
-	defer CloseConnection()          // Close DB Connection
+// Create UNIQUE(container, blob, snapshot) index, removing duplicate row(s) of older sync table first
+// (the older UNIQUE(container, blob) index is dropped, it would reject the snapshot row(s) of a blob)
+//
+// @param dbConnection pointer
+// @return boolean
+func createSyncIndex(dbConnection *storeDB) bool {
+	if store.IndexExists(dbConnection.DB, syncIndex) {
+		return true // Already Created
+	}
 
-	// Preparing Statement
-	insertStatement, statementError := dbConnection.Prepare("INSERT or IGNORE INTO containers(name, created_at) values(?,?)")
-	handleDBErrors(statementError, "Insert Container Prepare Failed")
+	dbLog.Info("Creating Sync Index", logger.Fields{"table": "sync", "index": syncIndex})
 
-	// Executing Statement
-	insertResponse, insertError := insertStatement.Exec(container, time.Now().Local())
-	handleDBErrors(insertError, "Insert Container  Execute Failed")
+	// Keeping the oldest row of a container:blob:snapshot
+	deleteResult, deleteErr := dbConnection.Exec("DELETE FROM sync WHERE id NOT IN (SELECT MIN(id) FROM sync GROUP BY container, blob, snapshot)")
+	if deleteErr != nil {
+		dbLog.Error("Unable to delete duplicate sync rows", logger.Fields{"table": "sync", "error": deleteErr})
+		return false
+	}
+	if deleted, _ := deleteResult.RowsAffected(); deleted != 0 {
+		dbLog.Warn(fmt.Sprintf("Deleted %d duplicate sync row(s)", deleted), logger.Fields{"table": "sync"})
+	}
 
-	// Printing Last Inserted ID
-	lastID, _ := insertResponse.LastInsertId()
-	fmt.Println("DB ID:", lastID)
+	if _, indexErr := dbConnection.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + syncIndex + " ON sync(container, blob, snapshot)"); indexErr != nil {
+		dbLog.Error("Unable to create index", logger.Fields{"table": "sync", "index": syncIndex, "error": indexErr})
+		return false
+	}
+
+	if _, dropErr := dbConnection.Exec("DROP INDEX IF EXISTS " + legacySyncIndex); dropErr != nil {
+		dbLog.Error("Unable to drop index", logger.Fields{"table": "sync", "index": legacySyncIndex, "error": dropErr})
+		return false
+	}
+
+	return true
+}
+
+// Column(s) introduced after the initial containers table layout
+var containerColumns = [][2]string{
+	{"error", "TEXT"},
+}
+
+// Column(s) introduced after the initial sync table layout
+var syncColumns = [][2]string{
+	{"content_type", "TEXT"},
+	{"cache_control", "TEXT"},
+	{"content_disposition", "TEXT"},
+	{"content_encoding", "TEXT"},
+	{"metadata", "TEXT"},
+	{"size", "BIGINT"},
+	{"downloaded_at", "TEXT"},
+	{"uploaded_at", "TEXT"},
+	{"lease_owner", "TEXT"},                  // Worker which claimed the row (status 4)
+	{"lease_expires", "BIGINT"},              // Unix time after which the claim is recovered
+	{"snapshot", "TEXT NOT NULL DEFAULT ''"}, // Snapshot time (UTC, sortable) of a snapshot row, '' for the base blob it's linked to
+	{"snowball_job", "TEXT"},                 // Snowball job whose device carries the downloaded file (skipped by upload)
+	{"shipped_at", "TEXT"},
+}
+
+// Report table(s) and column(s) which BuildTable would create
+//
+// @param dbConnection pointer
+// @return boolean
+func reportBuildTable(dbConnection *storeDB) bool {
+	for _, table := range []string{"containers", "sync", registryTable} {
+		if !store.TableExists(dbConnection.DB, table) {
+			dbLog.Info("[Dry Run] would create table "+table, logger.Fields{"table": table})
+		}
+	}
+
+	var duplicates int
+	if !store.IndexExists(dbConnection.DB, syncIndex) {
+		dbConnection.QueryRow("SELECT count(*) - count(DISTINCT container || '/' || blob) FROM sync").Scan(&duplicates) // Older table: no snapshot row(s) yet
+		dbLog.Info(fmt.Sprintf("[Dry Run] would delete %d duplicate sync row(s) and create index %s", duplicates, syncIndex), logger.Fields{"table": "sync"})
+	}
+
+	for table, columns := range map[string][][2]string{"containers": containerColumns, "sync": syncColumns} {
+		existingColumns := store.TableColumns(dbConnection.DB, table)
+		if len(existingColumns) == 0 {
+			continue
+		}
+
+		for _, column := range columns {
+			if !existingColumns[column[0]] {
+				dbLog.Info("[Dry Run] would add column "+table+"."+column[0], logger.Fields{"table": table, "column": column[0]})
+			}
+		}
+	}
 
 	return true // Success
 }
 
-// GetPendingAzureContent - Get container:blob mapping with pending download from Microsoft Azure
-func GetPendingAzureContent() map[int]map[string]string {
-	dbConnection := InitConnection() // Create DB Conection
-	defer CloseConnection()          // Close DB Connection
+// Add column(s) introduced after the initial table layout
+//
+// @param dbConnection pointer, table string, columns slice (name, type)
+// @return boolean
+func migrateTable(dbConnection *storeDB, table string, columns [][2]string) bool {
+	// Fetching Existing Column(s)
+	existingColumns := store.TableColumns(dbConnection.DB, table)
+	if len(existingColumns) == 0 {
+		dbLog.Error("Unable to read table columns", logger.Fields{"table": table})
+		return false
+	}
+
+	// Altering Table
+	for _, column := range columns {
+		if existingColumns[column[0]] {
+			continue
+		}
+
+		dbLog.Info("Adding Column", logger.Fields{"table": table, "column": column[0]})
+		if _, alterErr := dbConnection.Exec("ALTER TABLE " + table + " ADD COLUMN " + column[0] + " " + column[1]); alterErr != nil {
+			dbLog.Error("Unable to add column", logger.Fields{"table": table, "column": column[0], "error": alterErr})
+			return false
+		}
+	}
+
+	return true // Success
+}
+
+// InsertInContainer - Insert new entry into the containers table
+func InsertInContainer(container string) error {
+	if dryRun {
+		dbLog.Info("[Dry Run] would insert container", logger.Fields{"container": container})
+		return nil
+	}
+
+	// Executing Statement
+	inserted, insertError := dbWriter.Exec("INSERT INTO containers(name, created_at) values(?,?) ON CONFLICT(name) DO NOTHING", container, time.Now().Local())
+	if insertError != nil {
+		return fmt.Errorf("insert container: %v", insertError)
+	}
+
+	dbLog.Debug("Container Inserted", logger.Fields{"container": container, "inserted": inserted})
+
+	return nil // Success
+}
+
+// ResetContainerErrors - Clear error(s) of pending container(s), so that this run traverses them again
+func ResetContainerErrors() error {
+	if dryRun {
+		var count int
+		dbConnection.QueryRow("SELECT count(*) FROM containers WHERE status = 0 AND error IS NOT NULL").Scan(&count)
+		dbLog.Info(fmt.Sprintf("[Dry Run] would retry %d failed container(s)", count))
+		return nil
+	}
+
+	_, resetErr := dbConnection.Exec("UPDATE containers SET error = NULL WHERE status = 0 AND error IS NOT NULL")
+
+	return resetErr
+}
+
+// SetContainerError - Record the error which stopped traversing a container (status is left for the next run)
+func SetContainerError(container string, errorMessage string) error {
+	if dryRun {
+		dbLog.Info("[Dry Run] would set container error", logger.Fields{"container": container, "error": errorMessage})
+		return nil
+	}
+
+	_, updateErr := dbWriter.Exec("UPDATE containers SET error = ? WHERE name = ?", errorMessage, container)
+
+	return updateErr
+}
+
+// SetContainerStatus - Set status of a traversed container (and clear its error)
+func SetContainerStatus(container string, status int) error {
+	if dryRun {
+		dbLog.Info(fmt.Sprintf("[Dry Run] would set container status %d", status), logger.Fields{"container": container})
+		return nil
+	}
+
+	_, updateErr := dbWriter.Exec("UPDATE containers SET status = ?, error = NULL WHERE name = ?", status, container)
+
+	return updateErr
+}
+
+// InsertBlobs - Insert blob(s) listed from Azure into the sync table, in the writer's batched transaction(s)
+// (existing container:blob:snapshot rows are left untouched, their WriteResult.Affected is 0)
+func InsertBlobs(rows []SyncRow) []WriteResult {
+	queued := make([]<-chan WriteResult, len(rows))
+	createdAt := time.Now().Local()
+	for idx, row := range rows {
+		queued[idx] = dbWriter.Queue(`
+			INSERT INTO sync(container, blob, snapshot, content_type, cache_control, content_disposition, content_encoding, metadata, size, created_at)
+			values(?,?,?,?,?,?,?,?,?,?) ON CONFLICT(container, blob, snapshot) DO NOTHING`,
+			row.Container, row.Blob, row.Snapshot, row.Properties.ContentType, row.Properties.CacheControl, row.Properties.ContentDisposition,
+			row.Properties.ContentEncoding, helpers.EncodeMetadata(row.Properties.Metadata), row.Properties.Size, createdAt)
+	}
+
+	results := make([]WriteResult, len(rows))
+	for idx := range queued {
+		results[idx] = <-queued[idx]
+	}
+
+	return results
+}
+
+// CountExistingBlobs - Number of blob (snapshot) row(s) of a container already in the sync table (dry run)
+func CountExistingBlobs(container string, rows []SyncRow) (int, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	// Matching "blob@snapshot" ("blob@" for the base blob), or the blob of a table without snapshot column (not migrated yet)
+	key := "blob || '@' || snapshot"
+	if !store.TableColumns(dbConnection.DB, "sync")["snapshot"] {
+		key = "blob || '@'"
+	}
+
+	args := []interface{}{container}
+	for _, row := range rows {
+		args = append(args, row.Blob+"@"+row.Snapshot)
+	}
+
+	var count int
+	countErr := dbConnection.QueryRow("SELECT count(*) FROM sync WHERE container = ? AND "+key+" IN (?"+strings.Repeat(",?", len(rows)-1)+")", args...).Scan(&count)
+
+	return count, countErr
+}
+
+// ContainerExists - Check if container is already present in the containers table
+func ContainerExists(container string) bool {
+	var count int
+	dbConnection.QueryRow("SELECT count(*) FROM containers WHERE name = ?", container).Scan(&count)
 
+	return count != 0
+}
+
+// GetPendingAzureContent - Get container:blob:snapshot mapping with pending (or interrupted) download from Microsoft Azure
+// (dry run only, offset skips rows which it has already reported; a real run claims rows with ClaimAzureContent)
+func GetPendingAzureContent(ctx context.Context, offset int) (map[int]map[string]string, error) {
 	var syncList = map[int]map[string]string{}
 
 	// Testing:
@@ -144,79 +457,112 @@ func GetPendingAzureContent() map[int]map[string]string {
 	// syncRows, syncErr := dbConnection.Query("SELECT container, blob FROM sync WHERE azure_status = ? AND id = ?", 0, 1001)
 
 	// Fetching 10 Eligible Entries
-	syncRows, syncErr := dbConnection.Query("SELECT container, blob FROM sync WHERE azure_status = ? LIMIT 10", 0)
-	handleDBErrors(syncErr, "[Azure] Select Container:Blobs Failed")
+	syncRows, syncErr := dbConnection.QueryContext(ctx, "SELECT container, blob, snapshot FROM sync WHERE azure_status IN (?, ?) LIMIT 10 OFFSET ?", 0, 3, offset)
+	if syncErr != nil && ctx.Err() != nil {
+		return syncList, nil // Interrupted
+	} else if syncErr != nil {
+		return nil, fmt.Errorf("[Azure] select pending blobs: %v", syncErr)
+	}
 
 	defer syncRows.Close() // Closing Row Pointer
 
 	idx := 0
 	for syncRows.Next() {
-		var container, blob string
-		syncLoopErr := syncRows.Scan(&container, &blob)
+		var container, blob, snapshot string
+		syncLoopErr := syncRows.Scan(&container, &blob, &snapshot)
 
 		if syncLoopErr != nil {
-			handleDBErrors(syncLoopErr, "[Azure] Select Container:Blob Mapping Failed")
+			return nil, fmt.Errorf("[Azure] scan pending blob: %v", syncLoopErr)
 		}
 
 		syncList[idx] = map[string]string{}
 		syncList[idx]["container"] = container
 		syncList[idx]["blob"] = blob
+		syncList[idx]["snapshot"] = snapshot
 		idx++
 	}
 
 	// Any error encountered during iteration
 	loopError := syncRows.Err()
-	handleDBErrors(loopError, "[Azure] Container:Blob Iteration Failed")
+	if loopError != nil && ctx.Err() != nil {
+		return map[int]map[string]string{}, nil // Interrupted
+	} else if loopError != nil {
+		return nil, fmt.Errorf("[Azure] iterate pending blobs: %v", loopError)
+	}
 
-	return syncList
+	return syncList, nil
 }
 
-// GetPendingS3Content - Get container:blob mapping with pending upload to Amazon S3
-func GetPendingS3Content() map[int]map[string]string {
-	dbConnection := InitConnection() // Create DB Conection
-	defer CloseConnection()          // Close DB Connection
-
+// GetPendingS3Content - Get container:blob:snapshot mapping with pending (or interrupted) upload to Amazon S3
+// (dry run only, offset skips rows which it has already reported; a real run claims rows with ClaimS3Content)
+func GetPendingS3Content(ctx context.Context, offset int) (map[int]map[string]string, error) {
 	var syncList = map[int]map[string]string{}
 
 	// Fetching 10 Eligible Entries
-	syncRows, syncErr := dbConnection.Query("SELECT container, blob FROM sync WHERE azure_status = ? AND s3_status = ? order by id desc LIMIT 10", 1, 0)
-	handleDBErrors(syncErr, "[S3] Select Container:Blobs Failed")
+	syncRows, syncErr := dbConnection.QueryContext(ctx, `
+		SELECT container, blob, snapshot, COALESCE(content_type, ''), COALESCE(cache_control, ''),
+			COALESCE(content_disposition, ''), COALESCE(content_encoding, ''), COALESCE(metadata, '')
+		FROM sync WHERE azure_status = ? AND s3_status IN (?, ?) AND snowball_job IS NULL order by id desc LIMIT 10 OFFSET ?`, 1, 0, 3, offset)
+	if syncErr != nil && ctx.Err() != nil {
+		return syncList, nil // Interrupted
+	} else if syncErr != nil {
+		return nil, fmt.Errorf("[S3] select pending blobs: %v", syncErr)
+	}
 
 	defer syncRows.Close() // Closing Row Pointer
 
 	idx := 0
 	for syncRows.Next() {
-		var container, blob string
-		syncLoopErr := syncRows.Scan(&container, &blob)
+		var container, blob, snapshot string
+		var contentType, cacheControl, contentDisposition, contentEncoding, metadata string
+		syncLoopErr := syncRows.Scan(&container, &blob, &snapshot, &contentType, &cacheControl, &contentDisposition, &contentEncoding, &metadata)
 
 		if syncLoopErr != nil {
-			handleDBErrors(syncLoopErr, "[S3] Select Container:Blob Mapping Failed")
+			return nil, fmt.Errorf("[S3] scan pending blob: %v", syncLoopErr)
 		}
 
 		syncList[idx] = map[string]string{}
 		syncList[idx]["container"] = container
 		syncList[idx]["blob"] = blob
+		syncList[idx]["snapshot"] = snapshot
+		syncList[idx]["content_type"] = contentType
+		syncList[idx]["cache_control"] = cacheControl
+		syncList[idx]["content_disposition"] = contentDisposition
+		syncList[idx]["content_encoding"] = contentEncoding
+		syncList[idx]["metadata"] = metadata
 		idx++
 	}
 
 	// Any error encountered during iteration
 	loopError := syncRows.Err()
-	handleDBErrors(loopError, "[S3] Container:Blob Iteration Failed")
+	if loopError != nil && ctx.Err() != nil {
+		return map[int]map[string]string{}, nil // Interrupted
+	} else if loopError != nil {
+		return nil, fmt.Errorf("[S3] iterate pending blobs: %v", loopError)
+	}
 
-	return syncList
+	return syncList, nil
 }
 
-// GetPendingContainer - Get container with pending download
-func GetPendingContainer() []string {
-	dbConnection := InitConnection() // Create DB Conection
-	defer CloseConnection()          // Close DB Connection
-
+// GetPendingContainer - Get container with pending download, in name order
+// (offset skips container(s) which this run has already traversed and left pending: dry run, -prefix)
+func GetPendingContainer(ctx context.Context, offset int) ([]string, error) {
 	// Initializing Container
 	var containerList []string
 
 	// Fetching 100 Eligible Entries
-	containerRows, containerErr := dbConnection.Query("SELECT name FROM containers WHERE status = ? LIMIT 100", 0)
-	handleDBErrors(containerErr, "Select Containers Failed")
+	// (container(s) failing in this run are skipped until ResetContainerErrors; a dry run records no error
+	// and may run before the error column is added)
+	containerQuery := "SELECT name FROM containers WHERE status = ? AND error IS NULL ORDER BY name LIMIT 100 OFFSET ?"
+	if dryRun {
+		containerQuery = "SELECT name FROM containers WHERE status = ? ORDER BY name LIMIT 100 OFFSET ?"
+	}
+	containerRows, containerErr := dbConnection.QueryContext(ctx, containerQuery, 0, offset)
+	if containerErr != nil && ctx.Err() != nil {
+		return containerList, nil // Interrupted
+	} else if containerErr != nil {
+		return nil, fmt.Errorf("select pending containers: %v", containerErr)
+	}
 
 	defer containerRows.Close() // Closing Row Pointer
 
@@ -225,48 +571,372 @@ func GetPendingContainer() []string {
 		var name string
 		containerLoopErr := containerRows.Scan(&name)
 		if containerLoopErr != nil {
-			handleDBErrors(containerLoopErr, "Select Containers Failed")
+			return nil, fmt.Errorf("scan pending container: %v", containerLoopErr)
 		}
 		containerList = append(containerList, name)
 	}
 
 	// Any error encountered during iteration
 	loopError := containerRows.Err()
-	handleDBErrors(loopError, "Container Iteration Failed")
+	if loopError != nil && ctx.Err() != nil {
+		return nil, nil // Interrupted
+	} else if loopError != nil {
+		return nil, fmt.Errorf("iterate pending containers: %v", loopError)
+	}
 
-	return containerList
+	return containerList, nil
 }
 
 // ResetLiveContainer - Reset Container to 0 with live status
-func ResetLiveContainer() {
-	dbConnection := InitConnection() // Create DB Conection
-	defer CloseConnection()          // Close DB Connection
+func ResetLiveContainer() error {
+	liveContainerList, listErr := RegistryList(RegistryLive)
+	if listErr != nil {
+		return listErr
+	}
+
+	if dryRun {
+		var resetList []string
+		for _, liveContainer := range liveContainerList {
+			var status int
+			if dbConnection.QueryRow("SELECT status FROM containers WHERE name = ?", liveContainer).Scan(&status) == nil && status != 0 {
+				resetList = append(resetList, liveContainer)
+			}
+		}
+
+		dbLog.Info(fmt.Sprintf("[Dry Run] would reset %d live container(s) to status 0", len(resetList)), logger.Fields{"containers": strings.Join(resetList, ",")})
+		return nil
+	}
 
 	for _, liveContainer := range liveContainerList {
-		fmt.Println(liveContainer)
-		updateContainerQuery, _ := dbConnection.Prepare("UPDATE containers SET status = ? WHERE name = ?")
-		updateContainerQuery.Exec(0, liveContainer)
+		if _, updateErr := dbConnection.Exec("UPDATE containers SET status = ? WHERE name = ?", 0, liveContainer); updateErr != nil {
+			return fmt.Errorf("reset container %s: %v", liveContainer, updateErr)
+		}
+
+		dbLog.Info("Reset Completed!", logger.Fields{"container": liveContainer})
+	}
+
+	return nil
+}
 
-		fmt.Println("[", liveContainer, "] Reset Completed!")
+// IsLiveContainer - Check if container is live: in the live list of the registry
+func IsLiveContainer(containerName string) (bool, error) {
+	liveContainers, listErr := RegistrySet(RegistryLive)
+	if listErr != nil {
+		return false, listErr
 	}
+
+	return liveContainers[containerName], nil
+}
+
+// GetUploadedContent - Get up to limit rows uploaded to S3 with id above afterID, in id order
+func GetUploadedContent(ctx context.Context, afterID int64, limit int) ([]UploadedRow, error) {
+	liveContainers, listErr := RegistrySet(RegistryLive)
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	uploadedRows, selectErr := dbConnection.QueryContext(ctx, `
+		SELECT id, container, COALESCE(blob, ''), snapshot, COALESCE(size, 0), COALESCE(uploaded_at, '')
+		FROM sync WHERE s3_status = ? AND id > ? ORDER BY id LIMIT ?`, 1, afterID, limit)
+	if selectErr != nil {
+		return nil, fmt.Errorf("select uploaded blobs: %v", selectErr)
+	}
+	defer uploadedRows.Close()
+
+	rows := []UploadedRow{}
+	for uploadedRows.Next() {
+		var row UploadedRow
+		if scanErr := uploadedRows.Scan(&row.ID, &row.Container, &row.Blob, &row.Snapshot, &row.Size, &row.UploadedAt); scanErr != nil {
+			return nil, fmt.Errorf("scan uploaded blob: %v", scanErr)
+		}
+
+		row.Live = liveContainers[row.Container] // Registry: the one list of live container(s)
+		rows = append(rows, row)
+	}
+
+	if loopErr := uploadedRows.Err(); loopErr != nil {
+		return nil, fmt.Errorf("iterate uploaded blobs: %v", loopErr)
+	}
+
+	return rows, nil
 }
 
 // SetAzureFlag - Set Flag in Sync Table w.r.t. Azure
-func SetAzureFlag(containerName string, blobName string, statusCode int, errorMessage string) {
-	dbConnection := InitConnection() // Create DB Conection
-	defer CloseConnection()          // Close DB Connection
+func SetAzureFlag(containerName string, blobName string, snapshot string, statusCode int, errorMessage string) error {
+	if dryRun {
+		dbLog.Info(fmt.Sprintf("[Dry Run] would set azure_status = %d", statusCode), logger.Fields{"container": containerName, "blob": blobName, "snapshot": snapshot})
+		return nil
+	}
 
-	updateSyncQuery, _ := dbConnection.Prepare("UPDATE sync SET azure_status = ?, azure_error = ?, updated_at = ? WHERE container = ? AND blob = ?")
-	updateSyncQuery.Exec(statusCode, errorMessage, time.Now().Local(), containerName, blobName)
-	fmt.Println("[Table: sync] Assigned Status Flag.")
+	updatedAt := time.Now().Local()
+	updated, updateErr := dbWriter.Exec(`
+		UPDATE sync SET azure_status = ?, azure_error = ?, updated_at = ?,
+			downloaded_at = CASE WHEN ? = 1 THEN ? ELSE downloaded_at END, lease_owner = NULL, lease_expires = NULL
+		WHERE container = ? AND blob = ? AND snapshot = ? AND (lease_owner IS NULL OR lease_owner = ?)`,
+		statusCode, errorMessage, updatedAt, statusCode, updatedAt, containerName, blobName, snapshot, workerID)
+	if updateErr != nil {
+		return fmt.Errorf("set azure_status: %v", updateErr)
+	} else if updated == 0 {
+		return ErrLeaseLost
+	}
+
+	dbLog.Debug("[Table: sync] Assigned Status Flag.", logger.Fields{"container": containerName, "blob": blobName, "snapshot": snapshot, "azure_status": statusCode})
+	return nil
 }
 
 // SetS3Flag - Set Flag in Sync Table w.r.t. S3
-func SetS3Flag(containerName string, blobName string, statusCode int, errorMessage string) {
-	dbConnection := InitConnection() // Create DB Conection
-	defer CloseConnection()          // Close DB Connection
+func SetS3Flag(containerName string, blobName string, snapshot string, statusCode int, errorMessage string) error {
+	if dryRun {
+		dbLog.Info(fmt.Sprintf("[Dry Run] would set s3_status = %d", statusCode), logger.Fields{"container": containerName, "blob": blobName, "snapshot": snapshot})
+		return nil
+	}
+
+	updatedAt := time.Now().Local()
+	updated, updateErr := dbWriter.Exec(`
+		UPDATE sync SET s3_status = ?, s3_error = ?, updated_at = ?,
+			uploaded_at = CASE WHEN ? = 1 THEN ? ELSE uploaded_at END, lease_owner = NULL, lease_expires = NULL
+		WHERE container = ? AND blob = ? AND snapshot = ? AND (lease_owner IS NULL OR lease_owner = ?)`,
+		statusCode, errorMessage, updatedAt, statusCode, updatedAt, containerName, blobName, snapshot, workerID)
+	if updateErr != nil {
+		return fmt.Errorf("set s3_status: %v", updateErr)
+	} else if updated == 0 {
+		return ErrLeaseLost
+	}
+
+	dbLog.Debug("[Table: sync] S3: Assigned Status Flag.", logger.Fields{"container": containerName, "blob": blobName, "snapshot": snapshot, "s3_status": statusCode})
+	return nil
+}
+
+// SetBlobProperties - Store Azure blob HTTP headers and metadata in Sync Table
+func SetBlobProperties(containerName string, blobName string, snapshot string, properties BlobProperties) error {
+	if dryRun {
+		dbLog.Info("[Dry Run] would store blob properties", logger.Fields{"container": containerName, "blob": blobName, "snapshot": snapshot})
+		return nil
+	}
+
+	_, updateErr := dbWriter.Exec(`
+		UPDATE sync SET content_type = ?, cache_control = ?, content_disposition = ?, content_encoding = ?, metadata = ?, size = ?
+		WHERE container = ? AND blob = ? AND snapshot = ?`, properties.ContentType, properties.CacheControl, properties.ContentDisposition,
+		properties.ContentEncoding, helpers.EncodeMetadata(properties.Metadata), properties.Size, containerName, blobName, snapshot)
+	if updateErr != nil {
+		return fmt.Errorf("store blob properties: %v", updateErr)
+	}
+
+	dbLog.Debug("[Table: sync] Stored Blob Properties.", logger.Fields{"container": containerName, "blob": blobName, "snapshot": snapshot})
+	return nil
+}
+
+// GetStatusCounts - Sync table row count(s) by stage (azure / s3) and status
+func GetStatusCounts() (map[string]map[int]int64, error) {
+	statusCounts := map[string]map[int]int64{}
+	for stage, column := range map[string]string{"azure": "azure_status", "s3": "s3_status"} {
+		statusRows, statusErr := dbConnection.Query("SELECT " + column + ", count(*) FROM sync GROUP BY " + column)
+		if statusErr != nil {
+			return nil, statusErr
+		}
+
+		statusCounts[stage] = map[int]int64{}
+		for statusRows.Next() {
+			var status int
+			var count int64
+			if scanErr := statusRows.Scan(&status, &count); scanErr != nil {
+				statusRows.Close()
+				return nil, scanErr
+			}
+			statusCounts[stage][status] = count
+		}
+		statusRows.Close()
+	}
+
+	return statusCounts, nil
+}
+
+// GetQueueTotals - Rows and bytes (size captured by sync / download) pending for the stage: "download" or "upload"
+func GetQueueTotals(stage string) (int64, int64, error) {
+	pending := map[string]string{
+		"download": "azure_status IN (0, 3)",
+		"upload":   "azure_status = 1 AND s3_status IN (0, 3) AND snowball_job IS NULL",
+	}
+	where, ok := pending[stage]
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid queue stage %q (download, upload)", stage)
+	}
+
+	var files, bytes int64
+	queueErr := dbConnection.QueryRow("SELECT count(*), COALESCE(SUM(size), 0) FROM sync WHERE "+where).Scan(&files, &bytes)
+
+	return files, bytes, queueErr
+}
+
+// GetContainerStatusCounts - Containers table row count(s) by status
+func GetContainerStatusCounts() (map[int]int64, error) {
+	statusRows, statusErr := dbConnection.Query("SELECT status, count(*) FROM containers GROUP BY status")
+	if statusErr != nil {
+		return nil, statusErr
+	}
+	defer statusRows.Close()
+
+	statusCounts := map[int]int64{}
+	for statusRows.Next() {
+		var status int
+		var count int64
+		if scanErr := statusRows.Scan(&status, &count); scanErr != nil {
+			return nil, scanErr
+		}
+		statusCounts[status] = count
+	}
+
+	return statusCounts, statusRows.Err()
+}
+
+// Status code(s) of the status filter of GetSyncItems
+var syncStatusCodes = map[string]int{"pending": 0, "completed": 1, "failed": 2, "interrupted": 3, "in-progress": 4}
+
+// ValidateSyncFilter - Check the status / stage filter of GetSyncItems (empty: any)
+func ValidateSyncFilter(status string, stage string) error {
+	if _, validStatus := syncStatusCodes[status]; len(status) != 0 && !validStatus {
+		return fmt.Errorf("invalid status %q (pending, completed, failed, interrupted, in-progress)", status)
+	}
+
+	if len(stage) != 0 && stage != "azure" && stage != "s3" {
+		return fmt.Errorf("invalid stage %q (azure, s3)", stage)
+	}
+
+	return nil
+}
+
+// GetSyncItems - Page of sync table rows filtered by status (pending / completed / failed / interrupted / in-progress) and stage (azure / s3)
+func GetSyncItems(status string, stage string, offset int, limit int) ([]SyncItem, int64, error) {
+	// Building Filter
+	if filterErr := ValidateSyncFilter(status, stage); filterErr != nil {
+		return nil, 0, filterErr
+	}
+	statusCode := syncStatusCodes[status]
+
+	where, args := "1 = 1", []interface{}{}
+	switch {
+	case len(status) == 0:
+	case stage == "azure":
+		where, args = "azure_status = ?", []interface{}{statusCode}
+	case stage == "s3":
+		where, args = "s3_status = ?", []interface{}{statusCode}
+	case status == "failed":
+		where = "(azure_status = 2 OR s3_status = 2)"
+	case status == "completed":
+		where = "s3_status = 1"
+	case status == "interrupted":
+		where = "(azure_status = 3 OR s3_status = 3)"
+	case status == "in-progress":
+		where = "(azure_status = 4 OR s3_status = 4)"
+	default:
+		where = "(azure_status = 0 OR (azure_status = 1 AND s3_status = 0))"
+	}
+
+	var total int64
+	if countErr := dbConnection.QueryRow("SELECT count(*) FROM sync WHERE "+where, args...).Scan(&total); countErr != nil {
+		return nil, 0, countErr
+	}
+
+	itemRows, itemErr := dbConnection.Query(`
+		SELECT id, container, COALESCE(blob, ''), snapshot, azure_status, COALESCE(azure_error, ''), s3_status, COALESCE(s3_error, ''), COALESCE(updated_at, ''), COALESCE(lease_owner, '')
+		FROM sync WHERE `+where+` ORDER BY id LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if itemErr != nil {
+		return nil, 0, itemErr
+	}
+	defer itemRows.Close()
+
+	items := []SyncItem{}
+	for itemRows.Next() {
+		var item SyncItem
+		if scanErr := itemRows.Scan(&item.ID, &item.Container, &item.Blob, &item.Snapshot, &item.AzureStatus, &item.AzureError, &item.S3Status, &item.S3Error, &item.UpdatedAt, &item.LeaseOwner); scanErr != nil {
+			return nil, 0, scanErr
+		}
+		items = append(items, item)
+	}
+
+	return items, total, itemRows.Err()
+}
+
+// GetContainerTotals - Files and bytes per container, with download / upload progress
+func GetContainerTotals() ([]ContainerTotal, error) {
+	totalRows, totalErr := dbConnection.Query(`
+		SELECT s.container, COALESCE(MAX(c.status), -1), count(*), COALESCE(SUM(s.size), 0),
+			SUM(CASE WHEN s.azure_status = 1 THEN 1 ELSE 0 END), COALESCE(SUM(CASE WHEN s.azure_status = 1 THEN s.size END), 0),
+			SUM(CASE WHEN s.s3_status = 1 THEN 1 ELSE 0 END), COALESCE(SUM(CASE WHEN s.s3_status = 1 THEN s.size END), 0),
+			SUM(CASE WHEN s.azure_status = 2 OR s.s3_status = 2 THEN 1 ELSE 0 END)
+		FROM sync s LEFT JOIN containers c ON c.name = s.container
+		GROUP BY s.container ORDER BY s.container`)
+	if totalErr != nil {
+		return nil, totalErr
+	}
+	defer totalRows.Close()
+
+	totals := []ContainerTotal{}
+	for totalRows.Next() {
+		var total ContainerTotal
+		scanErr := totalRows.Scan(&total.Container, &total.Status, &total.Files, &total.Bytes, &total.Downloaded,
+			&total.DownloadedBytes, &total.Uploaded, &total.UploadedBytes, &total.Failed)
+		if scanErr != nil {
+			return nil, scanErr
+		}
+		totals = append(totals, total)
+	}
+
+	return totals, totalRows.Err()
+}
+
+// GetErrorCounts - Failed row count(s) per stage (azure / s3) and error message
+func GetErrorCounts() ([]ErrorCount, error) {
+	errorCounts := []ErrorCount{}
+	for _, stage := range []string{"azure", "s3"} {
+		errorRows, errorErr := dbConnection.Query(`
+			SELECT COALESCE(` + stage + `_error, ''), count(*) FROM sync
+			WHERE ` + stage + `_status = 2 GROUP BY ` + stage + `_error`)
+		if errorErr != nil {
+			return nil, errorErr
+		}
+
+		for errorRows.Next() {
+			errorCount := ErrorCount{Stage: stage}
+			if scanErr := errorRows.Scan(&errorCount.Message, &errorCount.Count); scanErr != nil {
+				errorRows.Close()
+				return nil, scanErr
+			}
+			errorCounts = append(errorCounts, errorCount)
+		}
+		errorRows.Close()
+	}
+
+	return errorCounts, nil
+}
+
+// GetThroughput - Files and bytes downloaded / uploaded per day ("day") or hour ("hour")
+func GetThroughput(period string) ([]Throughput, error) {
+	// Timestamps are stored as "2006-01-02 15:04:05..."
+	periodLength := 10
+	if period == "hour" {
+		periodLength = 13
+	}
+
+	throughput := []Throughput{}
+	for stage, column := range map[string]string{"azure": "downloaded_at", "s3": "uploaded_at"} {
+		periodRows, periodErr := dbConnection.Query(`
+			SELECT substr(`+column+`, 1, ?) AS period, count(*), COALESCE(SUM(size), 0) FROM sync
+			WHERE `+column+` IS NOT NULL GROUP BY period`, periodLength)
+		if periodErr != nil {
+			return nil, periodErr
+		}
+
+		for periodRows.Next() {
+			row := Throughput{Stage: stage}
+			if scanErr := periodRows.Scan(&row.Period, &row.Files, &row.Bytes); scanErr != nil {
+				periodRows.Close()
+				return nil, scanErr
+			}
+			throughput = append(throughput, row)
+		}
+		periodRows.Close()
+	}
 
-	updateSyncQuery, _ := dbConnection.Prepare("UPDATE sync SET s3_status = ?, s3_error = ?, updated_at = ? WHERE container = ? AND blob = ?")
-	updateSyncQuery.Exec(statusCode, errorMessage, time.Now().Local(), containerName, blobName)
-	fmt.Println("[Table: sync] S3: Assigned Status Flag.")
+	return throughput, nil
 }
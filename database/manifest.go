@@ -0,0 +1,292 @@
+// Namespace: database/manifest.go
+
+package database
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"../logger" // Leveled Logger
+)
+
+// Upsert key(s) of the table(s) which can be exported / imported
+var manifestKeys = map[string][]string{
+	"containers": {"name"},
+	"sync":       {"container", "blob", "snapshot"},
+}
+
+// Value of a key column which older manifest(s) lack, or export as an empty CSV cell
+var manifestDefaults = map[string]map[string]interface{}{
+	"sync": {"snapshot": ""}, // Base blob
+}
+
+// Value of a column whose CSV cell is empty / JSON value is null: status column(s) are never NULL, claim
+// and report queries match their code(s) (other column(s) are stored as NULL, e.g. lease_owner, snowball_job)
+var manifestColumnDefaults = map[string]map[string]interface{}{
+	"containers": {"status": 0},
+	"sync":       {"azure_status": 0, "s3_status": 0},
+}
+
+// Export order of the table(s)
+var manifestOrder = map[string]string{
+	"containers": "name",
+	"sync":       "id",
+}
+
+// ExportTable - Write every row of "containers" or "sync" as "csv" or "jsonl"
+func ExportTable(w io.Writer, table string, format string) (int64, error) {
+	if _, ok := manifestKeys[table]; !ok {
+		return 0, fmt.Errorf("invalid table %q (containers, sync)", table)
+	}
+
+	exportRows, exportErr := dbConnection.Query("SELECT * FROM " + table + " ORDER BY " + manifestOrder[table])
+	if exportErr != nil {
+		return 0, exportErr
+	}
+	defer exportRows.Close()
+
+	columns, columnErr := exportRows.Columns()
+	if columnErr != nil {
+		return 0, columnErr
+	}
+
+	var csvWriter *csv.Writer
+	var jsonEncoder *json.Encoder
+	switch format {
+	case "csv":
+		csvWriter = csv.NewWriter(w)
+		csvWriter.Write(columns)
+	case "jsonl":
+		jsonEncoder = json.NewEncoder(w)
+	default:
+		return 0, fmt.Errorf("invalid manifest format %q (csv, jsonl)", format)
+	}
+
+	var count int64
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for idx := range values {
+		pointers[idx] = &values[idx]
+	}
+
+	for exportRows.Next() {
+		if scanErr := exportRows.Scan(pointers...); scanErr != nil {
+			return count, scanErr
+		}
+
+		if csvWriter != nil {
+			record := make([]string, len(columns))
+			for idx, value := range values {
+				record[idx] = manifestString(value)
+			}
+			csvWriter.Write(record)
+		} else {
+			record := map[string]interface{}{}
+			for idx, value := range values {
+				if bytes, ok := value.([]byte); ok {
+					value = string(bytes)
+				}
+				record[columns[idx]] = value
+			}
+			if encodeErr := jsonEncoder.Encode(record); encodeErr != nil {
+				return count, encodeErr
+			}
+		}
+		count++
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+		if flushErr := csvWriter.Error(); flushErr != nil {
+			return count, flushErr
+		}
+	}
+
+	return count, exportRows.Err()
+}
+
+// ImportTable - Upsert rows of a "csv" or "jsonl" manifest into "containers" (by name) or "sync" (by container, blob, snapshot)
+func ImportTable(r io.Reader, table string, format string) (inserted int64, updated int64, err error) {
+	keys, ok := manifestKeys[table]
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid table %q (containers, sync)", table)
+	}
+
+	existingColumns := store.TableColumns(dbConnection.DB, table)
+	if len(existingColumns) == 0 {
+		return 0, 0, fmt.Errorf("table %s is missing, run -sync first", table)
+	}
+
+	records, readErr := readManifest(r, format)
+	if readErr != nil {
+		return 0, 0, readErr
+	}
+
+	// Every Row is Imported, or None
+	tx, txErr := dbConnection.Begin()
+	if txErr != nil {
+		return 0, 0, txErr
+	}
+	defer tx.Rollback()
+
+	for line, record := range records {
+		for column, value := range manifestDefaults[table] {
+			if record[column] == nil {
+				record[column] = value
+			}
+		}
+		for column, value := range manifestColumnDefaults[table] {
+			if current, present := record[column]; present && current == nil {
+				record[column] = value
+			}
+		}
+
+		rowInserted, rowErr := upsertRecord(tx, table, keys, existingColumns, record)
+		if rowErr != nil {
+			return 0, 0, fmt.Errorf("record %d: %v", line+1, rowErr)
+		}
+
+		if rowInserted {
+			inserted++
+		} else {
+			updated++
+		}
+	}
+
+	if dryRun {
+		dbLog.Info(fmt.Sprintf("[Dry Run] would insert %d and update %d %s rows", inserted, updated, table), logger.Fields{"table": table})
+		return inserted, updated, nil // Rolled back
+	}
+
+	return inserted, updated, tx.Commit()
+}
+
+// Update row matching the key column(s), or insert it
+func upsertRecord(tx *storeTx, table string, keys []string, existingColumns map[string]bool, record map[string]interface{}) (bool, error) {
+	var columns []string
+	for column := range record {
+		if column == "id" || column == "rowid" {
+			continue // Assigned by this DB
+		}
+		if !existingColumns[column] {
+			return false, fmt.Errorf("unknown column %q", column)
+		}
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	var keyClauses []string
+	var keyValues []interface{}
+	for _, key := range keys {
+		value, ok := record[key]
+		if !ok || value == nil {
+			return false, fmt.Errorf("missing key column %q", key)
+		}
+		keyClauses = append(keyClauses, key+" = ?")
+		keyValues = append(keyValues, value)
+	}
+
+	// Update Existing Row
+	var setClauses []string
+	var values []interface{}
+	for _, column := range columns {
+		setClauses = append(setClauses, column+" = ?")
+		values = append(values, record[column])
+	}
+
+	updateResult, updateErr := tx.Exec("UPDATE "+table+" SET "+strings.Join(setClauses, ", ")+" WHERE "+strings.Join(keyClauses, " AND "), append(values, keyValues...)...)
+	if updateErr != nil {
+		return false, updateErr
+	}
+
+	if affected, _ := updateResult.RowsAffected(); affected != 0 {
+		return false, nil
+	}
+
+	// Insert New Row
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",")
+	_, insertErr := tx.Exec("INSERT INTO "+table+"("+strings.Join(columns, ", ")+") values("+placeholders+")", values...)
+
+	return true, insertErr
+}
+
+// Read manifest record(s); empty CSV cells and JSON null are stored as NULL (see manifestColumnDefaults)
+func readManifest(r io.Reader, format string) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+
+	switch format {
+	case "csv":
+		csvReader := csv.NewReader(r)
+		header, headerErr := csvReader.Read()
+		if headerErr != nil {
+			return nil, headerErr
+		}
+
+		for {
+			row, rowErr := csvReader.Read()
+			if rowErr == io.EOF {
+				break
+			}
+			if rowErr != nil {
+				return nil, rowErr
+			}
+
+			record := map[string]interface{}{}
+			for idx, column := range header {
+				if len(row[idx]) == 0 {
+					record[column] = nil
+				} else {
+					record[column] = row[idx]
+				}
+			}
+			records = append(records, record)
+		}
+	case "jsonl":
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 {
+				continue
+			}
+
+			decoder := json.NewDecoder(strings.NewReader(line))
+			decoder.UseNumber()
+
+			record := map[string]interface{}{}
+			if decodeErr := decoder.Decode(&record); decodeErr != nil {
+				return nil, decodeErr
+			}
+			for column, value := range record {
+				if number, ok := value.(json.Number); ok {
+					record[column] = number.String()
+				}
+			}
+			records = append(records, record)
+		}
+		if scanErr := scanner.Err(); scanErr != nil {
+			return nil, scanErr
+		}
+	default:
+		return nil, fmt.Errorf("invalid manifest format %q (csv, jsonl)", format)
+	}
+
+	return records, nil
+}
+
+// CSV cell of a DB value
+func manifestString(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(typed)
+	}
+
+	return fmt.Sprint(value)
+}
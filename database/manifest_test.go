@@ -0,0 +1,74 @@
+// Namespace: database/manifest_test.go
+
+package database
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Fresh SQLite state store in a temporary directory, closed with the test
+func openTestDB(t *testing.T) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "storage.sqlite")
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open("sqlite", path, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Close() })
+
+	if !BuildTable() {
+		t.Fatal("build DB tables failed")
+	}
+}
+
+func TestImportBlankStatus(t *testing.T) {
+	openTestDB(t)
+
+	csvManifest := "container,blob,snapshot,azure_status,s3_status,lease_owner,snowball_job\n" +
+		"media,a.mp4,,,,,\n" +
+		"media,b.mp4,,1,,,\n"
+	if inserted, _, err := ImportTable(strings.NewReader(csvManifest), "sync", "csv"); err != nil || inserted != 2 {
+		t.Fatalf("CSV import = %d, %v", inserted, err)
+	}
+
+	jsonManifest := `{"container":"media","blob":"c.mp4","azure_status":1,"s3_status":null,"lease_owner":null}` + "\n"
+	if inserted, _, err := ImportTable(strings.NewReader(jsonManifest), "sync", "jsonl"); err != nil || inserted != 1 {
+		t.Fatalf("JSONL import = %d, %v", inserted, err)
+	}
+
+	containerManifest := "name,status\nmedia,\n"
+	if inserted, _, err := ImportTable(strings.NewReader(containerManifest), "containers", "csv"); err != nil || inserted != 1 {
+		t.Fatalf("containers import = %d, %v", inserted, err)
+	}
+
+	// Blank status cell(s) are pending: claimed by download / upload
+	downloads, err := ClaimAzureContent(context.Background(), 10)
+	if err != nil || len(downloads) != 1 {
+		t.Errorf("claimed downloads = %v, %v; want a.mp4", downloads, err)
+	}
+	uploads, err := ClaimS3Content(context.Background(), 10, false)
+	if err != nil || len(uploads) != 2 {
+		t.Errorf("claimed uploads = %v, %v; want b.mp4, c.mp4", uploads, err)
+	}
+	containers, err := GetPendingContainer(context.Background(), 0)
+	if err != nil || len(containers) != 1 {
+		t.Errorf("pending containers = %v, %v; want media", containers, err)
+	}
+
+	// A manifest without status column leaves the status of existing row(s) as is
+	if _, updated, err := ImportTable(strings.NewReader("container,blob\nmedia,b.mp4\n"), "sync", "csv"); err != nil || updated != 1 {
+		t.Fatalf("update import = %d, %v", updated, err)
+	}
+	counts, err := GetStatusCounts()
+	if err != nil || counts["azure"][1] != 2 {
+		t.Errorf("azure status counts = %v, %v", counts["azure"], err)
+	}
+}
@@ -0,0 +1,194 @@
+// Namespace: database/registry.go
+
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"../logger" // Leveled Logger
+)
+
+// Registry List(s) of the container_registry table
+const (
+	RegistryLive    = "live"    // Live container(s): updated frequently, re-synced after -reset-live (containers.status 100)
+	RegistryExclude = "exclude" // Container(s) which sync skips
+)
+
+// Global Constant(s)
+const registryTable = "container_registry"
+
+// List(s) of a new registry: the live / excluded container(s) of older version(s)
+var registryDefaults = map[string][]string{
+	RegistryLive:    {"employees-data", "employees-indentity", "videos", "images", "reports"},
+	RegistryExclude: {"test", "dummy", "others"},
+}
+
+// Create registry table, filled with the default list(s) when it's new (table and list(s) in one transaction:
+// a failed seed leaves no empty registry behind, the next run creates it again)
+//
+// @param dbConnection pointer
+// @return boolean
+func registryBuildTable(dbConnection *storeDB) bool {
+	if store.TableExists(dbConnection.DB, registryTable) {
+		return true
+	}
+
+	tx, txErr := dbConnection.Begin()
+	if txErr != nil {
+		dbLog.Error("Unable to create table: "+registryTable, logger.Fields{"error": txErr})
+		return false
+	}
+	defer tx.Rollback()
+
+	_, createErr := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + registryTable + ` (
+			name TEXT NOT NULL,
+			list TEXT NOT NULL,
+			created_at TEXT,
+			UNIQUE(name, list)
+	)`)
+	if createErr != nil {
+		dbLog.Error("Unable to create table: "+registryTable, logger.Fields{"error": createErr})
+		return false
+	}
+
+	createdAt := time.Now().Local()
+	for list, names := range registryDefaults {
+		for _, name := range names {
+			if _, insertErr := tx.Exec("INSERT INTO "+registryTable+"(name, list, created_at) VALUES(?, ?, ?) ON CONFLICT(name, list) DO NOTHING",
+				name, list, createdAt); insertErr != nil {
+				dbLog.Error("Unable to seed table: "+registryTable, logger.Fields{"container": name, "list": list, "error": insertErr})
+				return false
+			}
+		}
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		dbLog.Error("Unable to create table: "+registryTable, logger.Fields{"error": commitErr})
+		return false
+	}
+
+	dbLog.Info("Created Table: "+registryTable, logger.Fields{"live": len(registryDefaults[RegistryLive]), "exclude": len(registryDefaults[RegistryExclude])})
+	return true
+}
+
+// RegistryList - Container(s) of a registry list, sorted by name
+func RegistryList(list string) ([]string, error) {
+	if err := checkRegistryList(list); err != nil {
+		return nil, err
+	}
+
+	if !registryExists() { // Dry run: table which BuildTable would create
+		names := append([]string{}, registryDefaults[list]...)
+		sort.Strings(names)
+		return names, nil
+	}
+
+	nameRows, selectErr := dbConnection.Query("SELECT name FROM "+registryTable+" WHERE list = ? ORDER BY name", list)
+	if selectErr != nil {
+		return nil, fmt.Errorf("select %s containers: %v", list, selectErr)
+	}
+	defer nameRows.Close()
+
+	names := []string{}
+	for nameRows.Next() {
+		var name string
+		if scanErr := nameRows.Scan(&name); scanErr != nil {
+			return nil, fmt.Errorf("scan %s container: %v", list, scanErr)
+		}
+		names = append(names, name)
+	}
+
+	return names, nameRows.Err()
+}
+
+// RegistrySet - Container(s) of a registry list, as a set
+func RegistrySet(list string) (map[string]bool, error) {
+	names, err := RegistryList(list)
+	if err != nil {
+		return nil, err
+	}
+
+	set := map[string]bool{}
+	for _, name := range names {
+		set[name] = true
+	}
+
+	return set, nil
+}
+
+// RegistryAdd - Add container to a registry list (false: already in it); a synced container added to the live
+// list is marked live
+func RegistryAdd(list string, name string) (bool, error) {
+	if err := checkRegistryList(list); err != nil {
+		return false, err
+	}
+
+	if dryRun {
+		dbLog.Info(fmt.Sprintf("[Dry Run] would add container to %s list", list), logger.Fields{"container": name})
+		return true, nil
+	}
+
+	added, insertErr := dbWriter.Exec("INSERT INTO "+registryTable+"(name, list, created_at) VALUES(?, ?, ?) ON CONFLICT(name, list) DO NOTHING",
+		name, list, time.Now().Local())
+	if insertErr != nil {
+		return false, fmt.Errorf("add %s container: %v", list, insertErr)
+	}
+
+	if list == RegistryLive {
+		if _, updateErr := dbWriter.Exec("UPDATE containers SET status = ? WHERE name = ? AND status = ?", 100, name, 200); updateErr != nil {
+			return false, fmt.Errorf("mark container live: %v", updateErr)
+		}
+	}
+
+	dbLog.Debug(fmt.Sprintf("[Table: %s] Container Added.", registryTable), logger.Fields{"container": name, "list": list, "added": added})
+	return added != 0, nil
+}
+
+// RegistryRemove - Remove container from a registry list (false: not in it); a synced container removed from the
+// live list is marked traversed
+func RegistryRemove(list string, name string) (bool, error) {
+	if err := checkRegistryList(list); err != nil {
+		return false, err
+	}
+
+	if dryRun {
+		dbLog.Info(fmt.Sprintf("[Dry Run] would remove container from %s list", list), logger.Fields{"container": name})
+		return true, nil
+	}
+
+	removed, deleteErr := dbWriter.Exec("DELETE FROM "+registryTable+" WHERE name = ? AND list = ?", name, list)
+	if deleteErr != nil {
+		return false, fmt.Errorf("remove %s container: %v", list, deleteErr)
+	}
+
+	if list == RegistryLive {
+		if _, updateErr := dbWriter.Exec("UPDATE containers SET status = ? WHERE name = ? AND status = ?", 200, name, 100); updateErr != nil {
+			return false, fmt.Errorf("mark container traversed: %v", updateErr)
+		}
+	}
+
+	dbLog.Debug(fmt.Sprintf("[Table: %s] Container Removed.", registryTable), logger.Fields{"container": name, "list": list, "removed": removed})
+	return removed != 0, nil
+}
+
+// Check registry list name
+//
+// @param list string
+// @return error
+func checkRegistryList(list string) error {
+	if _, ok := registryDefaults[list]; !ok {
+		return fmt.Errorf("invalid container list %q (%s, %s)", list, RegistryLive, RegistryExclude)
+	}
+
+	return nil
+}
+
+// Check if the registry table exists (only a dry run may run before BuildTable created it)
+//
+// @return boolean
+func registryExists() bool {
+	return !dryRun || store.TableExists(dbConnection.DB, registryTable)
+}
@@ -0,0 +1,72 @@
+// Namespace: database/registry_test.go
+
+package database
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRegistryIsTheLiveList(t *testing.T) {
+	openTestDB(t)
+
+	// Seeded once: building the table(s) again adds no duplicate
+	if !BuildTable() {
+		t.Fatal("second build failed")
+	}
+	live, err := RegistryList(RegistryLive)
+	if err != nil || len(live) != len(registryDefaults[RegistryLive]) || !sort.StringsAreSorted(live) {
+		t.Fatalf("live list = %v, %v", live, err)
+	}
+
+	// Dry run without table: the default list, sorted the same way, default(s) left as they are
+	if _, err := dbConnection.Exec("DROP TABLE " + registryTable); err != nil {
+		t.Fatal(err)
+	}
+	SetDryRun(true)
+	defaults := append([]string{}, registryDefaults[RegistryLive]...)
+	dryLive, err := RegistryList(RegistryLive)
+	SetDryRun(false)
+	if err != nil || !reflect.DeepEqual(dryLive, live) || !reflect.DeepEqual(registryDefaults[RegistryLive], defaults) {
+		t.Errorf("dry run live list = %v, %v; want %v (defaults %v)", dryLive, err, live, registryDefaults[RegistryLive])
+	}
+	if !BuildTable() {
+		t.Fatal("rebuild failed")
+	}
+
+	// Status 100 left on a container removed from the list (e.g. edited table) doesn't make it live
+	if _, err := dbConnection.Exec("INSERT INTO containers(name, status) VALUES(?, ?), (?, ?)", "videos", 100, "archive", 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbConnection.Exec("INSERT INTO sync(container, blob, snapshot, azure_status, s3_status) VALUES(?, ?, '', 1, 1), (?, ?, '', 1, 1)",
+		"videos", "a.mp4", "archive", "b.mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{"videos": true, "archive": false} {
+		if live, err := IsLiveContainer(name); err != nil || live != want {
+			t.Errorf("IsLiveContainer(%s) = %v, %v; want %v", name, live, err, want)
+		}
+	}
+
+	rows, err := GetUploadedContent(context.Background(), 0, 10)
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("uploaded rows = %+v, %v", rows, err)
+	}
+	for _, row := range rows {
+		if row.Live != (row.Container == "videos") {
+			t.Errorf("%s/%s: live = %v", row.Container, row.Blob, row.Live)
+		}
+	}
+
+	// Reset: only container(s) of the list
+	if err := ResetLiveContainer(); err != nil {
+		t.Fatal(err)
+	}
+	var status int
+	if err := dbConnection.QueryRow("SELECT status FROM containers WHERE name = ?", "archive").Scan(&status); err != nil || status != 100 {
+		t.Errorf("archive status after reset = %d, %v; want 100 (not in the live list)", status, err)
+	}
+}
@@ -0,0 +1,117 @@
+// Namespace: database/snowball.go
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"../logger" // Leveled Logger
+)
+
+// ShipRow - Downloaded sync table row on its way to S3: carried by a Snowball job, or matched by the S3 reconcile
+type ShipRow struct {
+	ID              int64
+	Container, Blob string
+	Snapshot        string
+	Size            int64
+}
+
+// GetShippableContent - Get up to limit downloaded row(s) with pending upload and id above afterID, in id order,
+// which are not on a Snowball device yet or already on the one of job (container: only this container, empty: any)
+func GetShippableContent(ctx context.Context, job string, container string, afterID int64, limit int) ([]ShipRow, error) {
+	where, args := "azure_status = ? AND s3_status IN (?, ?) AND (snowball_job IS NULL OR snowball_job = ?) AND id > ?", []interface{}{1, 0, 3, job, afterID}
+	if len(container) != 0 {
+		where, args = where+" AND container = ?", append(args, container)
+	}
+
+	return selectShipRows(ctx, where, append(args, limit))
+}
+
+// GetShippedContent - Get up to limit row(s) of a Snowball job with id above afterID, in id order,
+// which aren't confirmed in S3 yet
+func GetShippedContent(ctx context.Context, job string, afterID int64, limit int) ([]ShipRow, error) {
+	return selectShipRows(ctx, "snowball_job = ? AND s3_status <> ? AND id > ?", []interface{}{job, 1, afterID, limit})
+}
+
+// GetUnconfirmedContent - Get up to limit downloaded row(s) with id above afterID, in id order, which aren't
+// confirmed in S3: pending, interrupted or failed upload, shipped on a Snowball device or not (leased row(s) are skipped)
+func GetUnconfirmedContent(ctx context.Context, afterID int64, limit int) ([]ShipRow, error) {
+	return selectShipRows(ctx, "azure_status = ? AND s3_status IN (?, ?, ?) AND id > ?", []interface{}{1, 0, 2, 3, afterID, limit})
+}
+
+// Select ship row(s) matching the condition
+//
+// @param ctx Context, where string (condition), args slice (condition argument(s), limit)
+// @return ShipRow slice, error
+func selectShipRows(ctx context.Context, where string, args []interface{}) ([]ShipRow, error) {
+	shipRows, selectErr := dbConnection.QueryContext(ctx, `
+		SELECT id, container, COALESCE(blob, ''), snapshot, COALESCE(size, 0)
+		FROM sync WHERE `+where+` ORDER BY id LIMIT ?`, args...)
+	if selectErr != nil {
+		return nil, fmt.Errorf("select downloaded blobs: %v", selectErr)
+	}
+	defer shipRows.Close()
+
+	rows := []ShipRow{}
+	for shipRows.Next() {
+		var row ShipRow
+		if scanErr := shipRows.Scan(&row.ID, &row.Container, &row.Blob, &row.Snapshot, &row.Size); scanErr != nil {
+			return nil, fmt.Errorf("scan downloaded blob: %v", scanErr)
+		}
+		rows = append(rows, row)
+	}
+
+	if loopErr := shipRows.Err(); loopErr != nil {
+		return nil, fmt.Errorf("iterate downloaded blobs: %v", loopErr)
+	}
+
+	return rows, nil
+}
+
+// MarkShipped - Mark row(s) as carried by a Snowball job, so that upload skips them
+// (row(s) claimed or uploaded in the meantime are left unmarked; returns the marked row count)
+func MarkShipped(job string, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if dryRun {
+		dbLog.Info(fmt.Sprintf("[Dry Run] would mark %d row(s) as shipped", len(ids)), logger.Fields{"job": job})
+		return int64(len(ids)), nil
+	}
+
+	args := []interface{}{job, time.Now().Local(), 0, 3}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	marked, markErr := dbWriter.Exec(`
+		UPDATE sync SET snowball_job = ?, shipped_at = ?
+		WHERE s3_status IN (?, ?) AND id IN (?`+strings.Repeat(",?", len(ids)-1)+`)`, args...)
+	if markErr != nil {
+		return 0, fmt.Errorf("[Snowball] mark shipped: %v", markErr)
+	}
+
+	dbLog.Debug(fmt.Sprintf("[Table: sync] Marked %d row(s) as shipped.", marked), logger.Fields{"job": job})
+	return marked, nil
+}
+
+// Unship - Clear the Snowball job of a row, so that upload picks it up again (object missing after the import)
+func Unship(containerName string, blobName string, snapshot string) error {
+	if dryRun {
+		dbLog.Info("[Dry Run] would return row to the upload queue", logger.Fields{"container": containerName, "blob": blobName, "snapshot": snapshot})
+		return nil
+	}
+
+	_, updateErr := dbWriter.Exec(`
+		UPDATE sync SET snowball_job = NULL, shipped_at = NULL, updated_at = ?
+		WHERE container = ? AND blob = ? AND snapshot = ?`, time.Now().Local(), containerName, blobName, snapshot)
+	if updateErr != nil {
+		return fmt.Errorf("[Snowball] unship: %v", updateErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+// Namespace: database/sqlite.go
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // SQLite3 Connection
+)
+
+// SQLite file (default store): one host, write(s) serialized by the file lock
+type sqliteStore struct{}
+
+// Open the DB file in WAL journal mode with a busy timeout, so that concurrent
+// worker(s) wait for the write lock instead of failing with "database is locked"
+// (transaction(s) take the write lock on BEGIN, which makes a claim atomic)
+func (sqliteStore) Open(path string, busyTimeout time.Duration) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?mode=rw&_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate", path, busyTimeout/time.Millisecond)
+
+	return sql.Open("sqlite3", dsn)
+}
+
+func (sqliteStore) Rebind(query string) string {
+	return query // Native "?" placeholders
+}
+
+func (sqliteStore) IDColumn() string {
+	return "id INTEGER PRIMARY KEY AUTOINCREMENT"
+}
+
+func (sqliteStore) TableExists(db *sql.DB, table string) bool {
+	var count int
+	db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count)
+
+	return count != 0
+}
+
+func (sqliteStore) IndexExists(db *sql.DB, index string) bool {
+	var count int
+	db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name = ?", index).Scan(&count)
+
+	return count != 0
+}
+
+func (sqliteStore) TableColumns(db *sql.DB, table string) map[string]bool {
+	existingColumns := map[string]bool{}
+
+	columnRows, columnErr := db.Query("PRAGMA table_info(" + table + ")")
+	if columnErr != nil {
+		return existingColumns
+	}
+	defer columnRows.Close()
+
+	for columnRows.Next() {
+		var cid, notNull, primaryKey int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if columnRows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey) == nil {
+			existingColumns[name] = true
+		}
+	}
+
+	return existingColumns
+}
+
+func (sqliteStore) ClaimLock() string {
+	return "" // BEGIN IMMEDIATE already holds the (only) write lock
+}
+
+func (sqliteStore) Savepoints() bool {
+	return false // A failing statement leaves the transaction usable
+}
@@ -0,0 +1,125 @@
+// Namespace: database/store.go
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Global Variable(s)
+var store Store = sqliteStore{} // Backend of the shared connection pool (Open)
+
+// Store - State store backend (SQLite file or PostgreSQL server)
+//
+// Every query of the package is written with "?" placeholders and portable SQL;
+// a Store only covers what differs between the backend(s).
+type Store interface {
+	Open(source string, busyTimeout time.Duration) (*sql.DB, error) // Connection pool (source: file / URL)
+	Rebind(query string) string                                     // "?" placeholders to the backend's own
+	IDColumn() string                                               // Auto-increment primary key definition
+	TableExists(db *sql.DB, table string) bool
+	IndexExists(db *sql.DB, index string) bool
+	TableColumns(db *sql.DB, table string) map[string]bool
+	ClaimLock() string // Appended to the claim SELECT, so concurrent claim(s) skip each other's row(s)
+	Savepoints() bool  // A failing statement aborts the transaction, so each queued write needs a savepoint
+}
+
+// Registered Store(s) (.env: DB_DRIVER)
+var stores = map[string]Store{
+	"sqlite":   sqliteStore{},
+	"postgres": postgresStore{},
+}
+
+// StoreNames - Name(s) accepted by Open
+func StoreNames() []string {
+	var names []string
+	for name := range stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Connection pool rebinding the query of every call to the backend's placeholders
+type storeDB struct {
+	*sql.DB
+}
+
+func (db *storeDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(store.Rebind(query), args...)
+}
+
+func (db *storeDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(store.Rebind(query), args...)
+}
+
+func (db *storeDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, store.Rebind(query), args...)
+}
+
+func (db *storeDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(store.Rebind(query), args...)
+}
+
+func (db *storeDB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.Prepare(store.Rebind(query))
+}
+
+func (db *storeDB) Begin() (*storeTx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+func (db *storeDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*storeTx, error) {
+	tx, txErr := db.DB.BeginTx(ctx, opts)
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	return &storeTx{tx}, nil
+}
+
+// Transaction rebinding the query of every call to the backend's placeholders
+type storeTx struct {
+	*sql.Tx
+}
+
+func (tx *storeTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(store.Rebind(query), args...)
+}
+
+func (tx *storeTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, store.Rebind(query), args...)
+}
+
+// Replace "?" placeholder(s) outside of quoted string(s) with numbered one(s)
+//
+// @param query string, placeholder format (e.g. "$%d")
+// @return string
+func numberPlaceholders(query string, format string) string {
+	var rebound strings.Builder
+	var quote rune
+	number := 0
+
+	for _, char := range query {
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote != 0:
+		case char == '\'' || char == '"':
+			quote = char
+		case char == '?':
+			number++
+			rebound.WriteString(fmt.Sprintf(format, number))
+			continue
+		}
+		rebound.WriteRune(char)
+	}
+
+	return rebound.String()
+}
@@ -0,0 +1,204 @@
+// Namespace: database/writer.go
+
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"../logger" // Leveled Logger
+)
+
+// Global Constant(s)
+const maxStatements = 256 // Prepared statement(s) cached by a writer, dropped once exceeded (e.g. IN lists of every length)
+
+// Global Variable(s)
+var dbWriter *Writer // Shared by every helper (Open)
+
+// ErrWriterClosed - Result of a write queued after Close
+var ErrWriterClosed = errors.New("DB writer is closed")
+
+// WriteResult - Outcome of a single queued write
+type WriteResult struct {
+	Affected int64 // Row(s) changed (0: e.g. ignored duplicate)
+	Err      error
+}
+
+// Writer - Single goroutine applying queued write(s) in transaction(s)
+//
+// Every write queued while a transaction is being committed goes into the next
+// one (up to batchSize), so concurrent worker(s) share a commit instead of each
+// taking the SQLite write lock for a single row.
+type Writer struct {
+	db         *sql.DB
+	writes     chan queuedWrite
+	stopped    chan struct{}
+	batchSize  int
+	statements map[string]*sql.Stmt // Prepared once per query (writer goroutine only)
+	mutex      sync.RWMutex         // Held for writing by Close, so no write is queued on the closed channel
+	closed     bool
+}
+
+// Write waiting in the queue
+type queuedWrite struct {
+	query  string
+	args   []interface{}
+	result chan WriteResult
+}
+
+// NewWriter - Start writer goroutine; Close it to flush pending write(s)
+func NewWriter(db *sql.DB, batchSize int) *Writer {
+	writer := &Writer{
+		db:         db,
+		writes:     make(chan queuedWrite, batchSize),
+		stopped:    make(chan struct{}),
+		batchSize:  batchSize,
+		statements: map[string]*sql.Stmt{},
+	}
+	go writer.run()
+
+	return writer
+}
+
+// Exec - Queue a write and wait for its transaction to commit
+func (w *Writer) Exec(query string, args ...interface{}) (int64, error) {
+	result := <-w.Queue(query, args...)
+
+	return result.Affected, result.Err
+}
+
+// Queue - Queue a write; the result is sent once its transaction is committed (ErrWriterClosed after Close)
+func (w *Writer) Queue(query string, args ...interface{}) <-chan WriteResult {
+	result := make(chan WriteResult, 1)
+
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	if w.closed {
+		result <- WriteResult{Err: ErrWriterClosed}
+		return result
+	}
+	w.writes <- queuedWrite{query: query, args: args, result: result}
+
+	return result
+}
+
+// Close - Commit queued write(s), stop the writer goroutine and close its prepared statement(s)
+func (w *Writer) Close() {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.writes)
+	w.mutex.Unlock()
+
+	<-w.stopped
+	w.closeStatements()
+}
+
+// Writer goroutine: one transaction per batch of queued write(s)
+func (w *Writer) run() {
+	defer close(w.stopped)
+
+	for write := range w.writes {
+		batch := []queuedWrite{write}
+
+		// Taking whatever is already queued, without waiting for more
+	collect:
+		for len(batch) < w.batchSize {
+			select {
+			case next, ok := <-w.writes:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, next)
+			default:
+				break collect
+			}
+		}
+
+		w.commit(batch)
+	}
+}
+
+// Apply batch in one transaction (a failing write doesn't undo the other one(s))
+func (w *Writer) commit(batch []queuedWrite) {
+	results := make([]WriteResult, len(batch))
+
+	// Dropping the cached statement(s) between transaction(s), none is in use
+	if len(w.statements) > maxStatements {
+		w.closeStatements()
+	}
+
+	tx, txErr := w.db.Begin()
+	if txErr != nil {
+		for idx := range batch {
+			batch[idx].result <- WriteResult{Err: fmt.Errorf("begin transaction: %v", txErr)}
+		}
+		return
+	}
+
+	for idx, write := range batch {
+		statement, prepareErr := w.statement(write.query)
+		if prepareErr != nil {
+			results[idx].Err = prepareErr
+			continue
+		}
+
+		if store.Savepoints() {
+			tx.Exec("SAVEPOINT queued_write")
+		}
+
+		execResult, execErr := tx.Stmt(statement).Exec(write.args...)
+		if execErr != nil {
+			results[idx].Err = execErr
+			if store.Savepoints() {
+				tx.Exec("ROLLBACK TO SAVEPOINT queued_write") // Keeping the transaction usable
+			}
+			continue
+		}
+		results[idx].Affected, _ = execResult.RowsAffected()
+
+		if store.Savepoints() {
+			tx.Exec("RELEASE SAVEPOINT queued_write")
+		}
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		for idx := range results {
+			results[idx] = WriteResult{Err: fmt.Errorf("commit transaction: %v", commitErr)}
+		}
+	}
+
+	dbLog.Debug("Committed Write Batch", logger.Fields{"writes": len(batch)})
+	for idx, write := range batch {
+		write.result <- results[idx]
+	}
+}
+
+// Prepared statement of a query
+func (w *Writer) statement(query string) (*sql.Stmt, error) {
+	if statement, ok := w.statements[query]; ok {
+		return statement, nil
+	}
+
+	statement, prepareErr := w.db.Prepare(store.Rebind(query))
+	if prepareErr != nil {
+		return nil, prepareErr
+	}
+	w.statements[query] = statement
+
+	return statement, nil
+}
+
+// Close and forget the prepared statement(s)
+func (w *Writer) closeStatements() {
+	for query, statement := range w.statements {
+		statement.Close()
+		delete(w.statements, query)
+	}
+}
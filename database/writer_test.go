@@ -0,0 +1,46 @@
+// Namespace: database/writer_test.go
+
+package database
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWriterQueueAfterClose(t *testing.T) {
+	openTestDB(t)
+
+	writer := NewWriter(dbConnection.DB, 10)
+	if _, err := writer.Exec("INSERT INTO containers(name) VALUES(?)", "media"); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	writer.Close() // Closing twice is harmless
+
+	// Late write (e.g. lease release after shutdown): error, not a panic
+	if result := <-writer.Queue("UPDATE containers SET status = ? WHERE name = ?", 200, "media"); result.Err != ErrWriterClosed {
+		t.Errorf("write after Close = %+v, want %v", result, ErrWriterClosed)
+	}
+	if len(writer.statements) != 0 {
+		t.Errorf("%d prepared statement(s) left open", len(writer.statements))
+	}
+}
+
+func TestWriterDropsStatements(t *testing.T) {
+	openTestDB(t)
+
+	writer := NewWriter(dbConnection.DB, 10)
+	defer writer.Close()
+
+	// Distinct query text per write, as IN lists of every length
+	for idx := 0; idx < maxStatements+10; idx++ {
+		query := fmt.Sprintf("INSERT INTO containers(name, status) VALUES(?, %d)", idx)
+		if _, err := writer.Exec(query, fmt.Sprintf("container-%d", idx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(writer.statements) > maxStatements+1 {
+		t.Errorf("%d prepared statement(s) cached, want at most %d", len(writer.statements), maxStatements+1)
+	}
+}
@@ -0,0 +1,184 @@
+// Namespace: diskspace/main.go
+
+package diskspace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../logger" // Leveled Logger
+
+	"code.cloudfoundry.org/bytefmt" // Byte Format
+)
+
+// Global Variable(s)
+var volumeSpace = statVolume // Free / total bytes of the volume holding a path (replaced by tests)
+
+// Mark - High-water mark of the media volume: max. used share, and / or bytes always left free
+type Mark struct {
+	UsedPercent float64 // 0: no percentage limit
+	FreeBytes   int64
+}
+
+// Guard - Free space check of the media folder shared by every download worker: a download reserves its
+// size and waits while it would push the volume past the high-water mark
+type Guard struct {
+	path     string
+	mark     Mark
+	poll     time.Duration // Free space re-check period while paused
+	log      *logger.Logger
+	mutex    sync.Mutex
+	reserved int64 // Byte(s) of download(s) in progress not written yet
+	waiting  int   // Download(s) paused
+}
+
+// Reservation - Space held for one download: shrinks as its bytes are written (then counted in the free space)
+type Reservation struct {
+	guard *Guard
+	left  int64 // Reserved byte(s) not written yet
+}
+
+// ParseMark - Parse high-water mark such as "90%" (max. used share of the volume) or "50GB" (free space kept)
+func ParseMark(value string) (Mark, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return Mark{}, fmt.Errorf("invalid high-water mark %q: percentage must be in (0, 100]", value)
+		}
+
+		return Mark{UsedPercent: percent}, nil
+	}
+
+	free, err := bytefmt.ToBytes(value)
+	if err != nil {
+		return Mark{}, fmt.Errorf("invalid high-water mark %q: %v", value, err)
+	}
+
+	return Mark{FreeBytes: int64(free)}, nil
+}
+
+// NewGuard - Guard the volume of path, re-checking free space every poll while downloads are paused
+func NewGuard(path string, mark Mark, poll time.Duration, log *logger.Logger) *Guard {
+	return &Guard{path: path, mark: mark, poll: poll, log: log}
+}
+
+// Reserve - Wait until size bytes fit below the high-water mark and reserve them until they are written or
+// released (error: interrupted, or the file would never fit on the volume; nil reservation: no check)
+func (g *Guard) Reserve(ctx context.Context, size int64) (*Reservation, error) {
+	if g == nil {
+		return nil, nil
+	}
+
+	paused := false
+	for {
+		free, total, statErr := volumeSpace(g.path)
+		if statErr != nil { // Not blocking download(s) on a check which can't run
+			g.log.Warn("Unable to check free space of media folder", logger.Fields{"path": g.path, "error": statErr})
+			return nil, nil
+		}
+
+		keep := g.mark.keep(total)
+		if size > total-keep {
+			return nil, fmt.Errorf("file of %s can't fit on the media volume below the high-water mark (%s, keeping %s free)",
+				bytefmt.ByteSize(uint64(size)), bytefmt.ByteSize(uint64(total)), bytefmt.ByteSize(uint64(keep)))
+		}
+
+		g.mutex.Lock()
+		if free-g.reserved-size >= keep {
+			g.reserved += size
+			if paused {
+				g.waiting--
+				g.log.Info("Media folder has free space again, download resumed", logger.Fields{"free": bytefmt.ByteSize(uint64(free)), "paused": g.waiting})
+			}
+			g.mutex.Unlock()
+
+			return &Reservation{guard: g, left: size}, nil
+		}
+
+		if !paused {
+			paused = true
+			g.waiting++
+			g.log.Warn("Media folder is low on space, download paused until space frees up", logger.Fields{"path": g.path,
+				"free": bytefmt.ByteSize(uint64(free)), "reserved": bytefmt.ByteSize(uint64(g.reserved)), "needed": bytefmt.ByteSize(uint64(size)),
+				"keep_free": bytefmt.ByteSize(uint64(keep)), "paused": g.waiting})
+		}
+		g.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			g.mutex.Lock()
+			g.waiting--
+			g.mutex.Unlock()
+			return nil, ctx.Err()
+		case <-time.After(g.poll):
+		}
+	}
+}
+
+// Writer - Writer shrinking the reservation by the bytes written through it (the volume's free space drops as much)
+func (r *Reservation) Writer(writer io.Writer) io.Writer {
+	if r == nil {
+		return writer
+	}
+
+	return &reservedWriter{writer: writer, reservation: r}
+}
+
+// Release - Give back the reserved bytes not written (yet), once the download is over
+func (r *Reservation) Release() {
+	if r == nil {
+		return
+	}
+
+	r.guard.mutex.Lock()
+	defer r.guard.mutex.Unlock()
+
+	r.guard.reserved -= r.left
+	r.left = 0
+}
+
+// Free the reservation of n written bytes (at most what is left of it)
+//
+// @param n integer
+func (r *Reservation) written(n int64) {
+	r.guard.mutex.Lock()
+	defer r.guard.mutex.Unlock()
+
+	if n > r.left {
+		n = r.left
+	}
+	r.left -= n
+	r.guard.reserved -= n
+}
+
+// Writer counting written bytes against a reservation
+type reservedWriter struct {
+	writer      io.Writer
+	reservation *Reservation
+}
+
+func (w *reservedWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.reservation.written(int64(n))
+
+	return n, err
+}
+
+// Bytes which must stay free on a volume of total bytes
+//
+// @param total integer
+// @return integer
+func (m Mark) keep(total int64) int64 {
+	keep := m.FreeBytes
+	if byPercent := int64(float64(total) * (100 - m.UsedPercent) / 100); m.UsedPercent > 0 && byPercent > keep {
+		keep = byPercent
+	}
+
+	return keep
+}
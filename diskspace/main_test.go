@@ -0,0 +1,136 @@
+// Namespace: diskspace/main_test.go
+
+package diskspace
+
+import (
+	"context"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"../fakestorage" // Fake Blob / S3 Service(s) (test logger)
+)
+
+// Volume of 1000 byte(s) with the free byte(s) of the counter
+func fakeVolume(t *testing.T, free *int64) {
+	t.Helper()
+
+	volumeSpace = func(path string) (int64, int64, error) { return atomic.LoadInt64(free), 1000, nil }
+	t.Cleanup(func() { volumeSpace = statVolume })
+}
+
+func TestParseMark(t *testing.T) {
+	cases := map[string]Mark{"90%": {UsedPercent: 90}, " 2.5% ": {UsedPercent: 2.5}, "1K": {FreeBytes: 1024}, "50GB": {FreeBytes: 50 << 30}}
+	for value, want := range cases {
+		if mark, err := ParseMark(value); err != nil || mark != want {
+			t.Errorf("ParseMark(%q) = %+v, %v; want %+v", value, mark, err, want)
+		}
+	}
+
+	for _, value := range []string{"0%", "101%", "x%", "lots", ""} {
+		if _, err := ParseMark(value); err == nil {
+			t.Errorf("ParseMark(%q): no error", value)
+		}
+	}
+}
+
+func TestReservePausesUntilSpaceFreesUp(t *testing.T) {
+	free := int64(500)
+	fakeVolume(t, &free)
+	guard := NewGuard("/media", Mark{UsedPercent: 90, FreeBytes: 50}, 10*time.Millisecond, fakestorage.Logger(t)) // Keeps 100 byte(s) free
+
+	reservation, err := guard.Reserve(context.Background(), 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 500 - 300 reserved - 200 < 100: paused until the upload stage deletes local copies
+	resumed := make(chan error)
+	go func() {
+		_, reserveErr := guard.Reserve(context.Background(), 200)
+		resumed <- reserveErr
+	}()
+
+	select {
+	case <-resumed:
+		t.Fatal("second download was not paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	reservation.Release()
+	reservation.Release() // Released once
+	atomic.StoreInt64(&free, 300)
+	select {
+	case err = <-resumed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("download not resumed")
+	}
+	if guard.reserved != 200 || guard.waiting != 0 {
+		t.Errorf("reserved = %d, waiting = %d", guard.reserved, guard.waiting)
+	}
+}
+
+func TestReserveInterruptedOrTooLarge(t *testing.T) {
+	free := int64(0)
+	fakeVolume(t, &free)
+	guard := NewGuard("/media", Mark{FreeBytes: 100}, time.Hour, fakestorage.Logger(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := guard.Reserve(ctx, 10); err != context.Canceled {
+		t.Errorf("interrupted reserve error = %v", err)
+	}
+
+	if _, err := guard.Reserve(context.Background(), 901); err == nil {
+		t.Error("file larger than the volume below the mark: no error")
+	}
+
+	var none *Guard
+	reservation, err := none.Reserve(context.Background(), 1<<40)
+	if err != nil {
+		t.Errorf("nil guard: %v", err)
+	}
+	reservation.Writer(ioutil.Discard).Write([]byte("abc")) // No-op(s) without a guard
+	reservation.Release()
+}
+
+func TestReserveShrinksAsBytesAreWritten(t *testing.T) {
+	free := int64(500)
+	fakeVolume(t, &free)
+	guard := NewGuard("/media", Mark{FreeBytes: 100}, 10*time.Millisecond, fakestorage.Logger(t))
+
+	reservation, err := guard.Reserve(context.Background(), 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 250 byte(s) on disk: the volume's free space and the reservation both shrink, not counted twice
+	if _, err := reservation.Writer(ioutil.Discard).Write(make([]byte, 250)); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(&free, 250)
+	if guard.reserved != 50 {
+		t.Errorf("reserved after write = %d, want 50", guard.reserved)
+	}
+
+	// 250 free - 50 left - 100 >= 100 kept free: fits without waiting on the first download
+	second, err := guard.Reserve(context.Background(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writing past the reserved size (size unknown or wrong) never frees other download(s)' reservation
+	reservation.Writer(ioutil.Discard).Write(make([]byte, 100))
+	reservation.Release()
+	if guard.reserved != 100 {
+		t.Errorf("reserved = %d, want 100 (second download)", guard.reserved)
+	}
+	second.Release()
+	if guard.reserved != 0 {
+		t.Errorf("reserved after release = %d", guard.reserved)
+	}
+}
@@ -0,0 +1,23 @@
+// Namespace: diskspace/statfs.go
+
+//go:build !windows
+// +build !windows
+
+package diskspace
+
+import (
+	"syscall"
+)
+
+// Free (available to this user) and total bytes of the volume holding path
+//
+// @param path string
+// @return free integer, total integer, error
+func statVolume(path string) (int64, int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), int64(stat.Blocks) * int64(stat.Bsize), nil
+}
@@ -0,0 +1,31 @@
+// Namespace: diskspace/statfs_windows.go
+
+package diskspace
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Global Variable(s)
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// Free (available to this user) and total bytes of the volume holding path
+//
+// @param path string
+// @return free integer, total integer, error
+func statVolume(path string) (int64, int64, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var free, total, totalFree uint64
+	ok, _, callErr := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&free)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&totalFree)))
+	if ok == 0 {
+		return 0, 0, callErr
+	}
+
+	return int64(free), int64(total), nil
+}
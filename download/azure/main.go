@@ -4,121 +4,224 @@ package azure
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"net/url"
 	"os"
-	"os/signal"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"../../database" // DB Handler Package
-	"../../helpers"  // Helper Package
+	"../../api"       // Status API
+	"../../database"  // DB Handler Package
+	"../../diskspace" // Media Folder Free Space Guard
+	"../../endpoint"  // Azure Endpoint
+	"../../failures"  // Run Error Summary
+	"../../helpers"   // Helper Package
+	"../../logger"    // Leveled Logger
+	"../../metrics"   // Prometheus Metrics
+	"../../throttle"  // Bandwidth Throttling
 
 	"code.cloudfoundry.org/bytefmt"                            // Byte Format
-	"github.com/Azure/azure-pipeline-go/pipeline"              // Azure Pipeline
 	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob" // Azure Blob Package
-	"github.com/schollz/progressbar"                           // Progress Bar
 )
 
 // Global Constant(s)
 const (
-	statusCompleted = 1
-	statusFailed    = 2
+	statusCompleted   = 1
+	statusFailed      = 2
+	statusInterrupted = 3
 )
 
+// Global Variable(s)
+var dryRunFiles, dryRunBytes int64 // Download(s) which a dry run would perform
+var createFile = createMediaFile   // Creates the file of a download (replaced by tests)
+
+// Row(s) which hit a full disk in this run: left out of the claims until the next run, instead of being
+// downloaded again as soon as their partial file is deleted
+var diskFull = struct {
+	sync.Mutex
+	ids []int64
+}{}
+
 // EnvVars Struct
 type EnvVars struct {
-	AccountName, AccountKey, MediaFolder string
+	Azure          endpoint.Azure // Storage Account Endpoint / Credential
+	MediaFolder    string
+	SnapshotSuffix string            // Suffix of a snapshot's file, "{snapshot}" is the snapshot time (S3_SNAPSHOT_SUFFIX)
+	DryRun         bool              // Report download(s) without writing any file
+	Limiter        *throttle.Limiter // Shared by every download worker (nil: unlimited)
+	Space          *diskspace.Guard  // Pauses download(s) while the media folder is low on space (nil: no check)
+	Log            *logger.Logger    // Leveled Logger
 }
 
 // Run - Entry Point for Azure Content Download
-func Run(env EnvVars) bool {
+func Run(ctx context.Context, env EnvVars) bool {
 
-	fmt.Println("Azure Content Download...")
-	initiateDownload(env)
+	env.Log.Info("Azure Content Download...")
+	diskFull.Lock()
+	diskFull.ids = nil
+	diskFull.Unlock()
+
+	if err := initiateDownload(ctx, env, 0); err != nil {
+		env.Log.Error("Download Queue Failed", logger.Fields{"error": err})
+		failures.Record(metrics.StageDownload, "", "", err)
+		return false
+	}
+
+	if env.DryRun {
+		env.Log.Info(fmt.Sprintf("[Dry Run] would download %s files (%s)", helpers.FormatCount(dryRunFiles), bytefmt.ByteSize(uint64(dryRunBytes))),
+			logger.Fields{"files": dryRunFiles, "bytes": dryRunBytes})
+	}
 
 	return true
 }
 
 // Initiate Download of Azure Data
 //
+// @param ctx Context (cancelled on interrupt)
 // @param env EnvVars struct
-// @return nil
-func initiateDownload(env EnvVars) {
+// @param offset integer (dry run only, as statuses are not updated)
+// @return error (pending rows could not be fetched)
+func initiateDownload(ctx context.Context, env EnvVars, offset int) error {
 	var wg sync.WaitGroup // Checks if traversing gets completed.
 
-	// Getting Pending Download from Sync Table
-	syncList := database.GetPendingAzureContent()
+	// Getting Pending Download from Sync Table (claimed by this worker, so other process(es) skip them)
+	var syncList map[int]map[string]string
+	var err error
+	if env.DryRun {
+		syncList, err = database.GetPendingAzureContent(ctx, offset)
+	} else {
+		diskFull.Lock()
+		skip := append([]int64(nil), diskFull.ids...)
+		diskFull.Unlock()
 
-	// Initializing Empty Download Queue
-	downloadQueue := make(map[string]string)
+		syncList, err = database.ClaimAzureContent(ctx, 10, skip...)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Initializing Empty Download Queue (file name per row: snapshot(s) of a blob share its name)
+	downloadQueue := make(map[int]string)
 
 	// Creating Queue
 	for idx := 0; idx < len(syncList); idx++ {
-		downlodedBlob := helpers.ProcessBlobName(syncList[idx]["container"], syncList[idx]["blob"])
-		downloadQueue[syncList[idx]["blob"]] = downlodedBlob
+		downloadQueue[idx] = helpers.LocalFileName(syncList[idx]["container"], syncList[idx]["blob"], syncList[idx]["snapshot"], env.SnapshotSuffix)
 	}
 
-	// Processing 10 Media Files Concurrently
-	for idx := 0; idx < len(syncList); idx++ {
-		go startWorker(&wg, syncList[idx], downloadQueue, env)
-		wg.Add(1)
-	}
+	if env.DryRun {
+		reportDownload(ctx, syncList, downloadQueue, env)
+		offset += len(syncList)
+	} else {
+		// Processing 10 Media Files Concurrently (worker(s) only read the queue)
+		pending := int64(len(syncList))
+		for idx := 0; idx < len(syncList); idx++ {
+			wg.Add(1)
+			go startWorker(ctx, &wg, syncList[idx], downloadQueue[idx], &pending, env)
+		}
 
-	// Waiting for worker to finish download.
-	wg.Wait()
+		// Waiting for worker to finish download.
+		wg.Wait()
+	}
 
 	// Recursion Implementation:
-	if len(syncList) != 0 {
-		fmt.Println("-------------------------------------------------------")
-		fmt.Println("[Recursion] Fetching New Data...")
-		fmt.Println("[Time]", time.Now().Local())
-		fmt.Println("-------------------------------------------------------")
-		initiateDownload(env)
+	if len(syncList) != 0 && ctx.Err() == nil {
+		env.Log.Info("[Recursion] Fetching New Data...")
+		return initiateDownload(ctx, env, offset)
+	}
+
+	return nil
+}
+
+// Report Download(s) without Writing any File (Dry Run)
+//
+// @param ctx Context, syncList Maps, downloadQueue Maps, env EnvVars struct
+// @return nil
+func reportDownload(ctx context.Context, syncList map[int]map[string]string, downloadQueue map[int]string, env EnvVars) {
+	for idx := 0; idx < len(syncList) && ctx.Err() == nil; idx++ {
+		containerName, blobName, snapshot := syncList[idx]["container"], syncList[idx]["blob"], syncList[idx]["snapshot"]
+
+		// Fetching Blob Size (read-only request)
+		blobURL, err := env.Azure.BlobURL(containerName, blobName, snapshot)
+		if err != nil {
+			env.Log.Warn("[Dry Run] would fail to download", logger.Fields{"container": containerName, "blob": blobName, "snapshot": snapshot, "error": err})
+			continue
+		}
+
+		properties, err := blobURL.GetPropertiesAndMetadata(ctx, azblob.BlobAccessConditions{})
+		if err != nil {
+			env.Log.Warn("[Dry Run] would fail to download", logger.Fields{"container": containerName, "blob": blobName, "snapshot": snapshot, "error": err})
+			continue
+		}
+
+		dryRunFiles++
+		dryRunBytes += properties.ContentLength()
+		env.Log.Info(fmt.Sprintf("[Dry Run] would download %s to %s", bytefmt.ByteSize(uint64(properties.ContentLength())), env.MediaFolder+downloadQueue[idx]),
+			logger.Fields{"container": containerName, "blob": blobName, "snapshot": snapshot, "bytes": properties.ContentLength()})
 	}
 }
 
 // Start Worker to Download Blob
 //
-// @param wg WaitGroup, syncContent Maps, downloadQueue Maps, env EnvVars struct
+// @param ctx Context, wg WaitGroup, syncContent Maps, fileName string, pending counter, env EnvVars struct
 // @return nil
-func startWorker(wg *sync.WaitGroup, syncContent map[string]string, downloadQueue map[string]string, env EnvVars) {
+func startWorker(ctx context.Context, wg *sync.WaitGroup, syncContent map[string]string, fileName string, pending *int64, env EnvVars) {
 	defer wg.Done() // Work Completed
 
-	fmt.Println("Starting: ", syncContent["container"], "->", syncContent["blob"])
+	metrics.InFlightWorkers.WithLabelValues(metrics.StageDownload).Inc()
+	defer metrics.InFlightWorkers.WithLabelValues(metrics.StageDownload).Dec()
 
 	// From the Azure portal, get your Storage account blob service URL endpoint.
-	accountName, accountKey, mediaFolder := env.AccountName, env.AccountKey, env.MediaFolder
-	containerName, blobName := syncContent["container"], syncContent["blob"]
-	fileName := downloadQueue[blobName]
-
-	/* [START] Handling Interrupt Signal */
-	c := make(chan os.Signal, 2)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		fmt.Println("\r- Ctrl+C pressed in Terminal")
-		for _, delFileName := range downloadQueue {
-			os.Remove(mediaFolder + delFileName)
-		}
+	mediaFolder := env.MediaFolder
+	containerName, blobName, snapshot := syncContent["container"], syncContent["blob"], syncContent["snapshot"]
+
+	log := env.Log.With(logger.Fields{"container": containerName, "blob": blobName, "snapshot": snapshot})
+	log.Info("Starting Download")
+	startTime := time.Now()
 
-		os.Exit(0)
+	transfer := api.StartTransfer(metrics.StageDownload, containerName, blobName)
+	defer transfer.Done()
+
+	defer func() {
+		log.Debug("Pending File(s)", logger.Fields{"pending": atomic.AddInt64(pending, -1)})
 	}()
-	/* [END] Handling Interrupt Signal */
 
-	// Create a default request pipeline using your storage account name and account key.
-	azurePipeline := azblob.NewPipeline(azblob.NewSharedKeyCredential(accountName, accountKey), azblob.PipelineOptions{})
+	// Renewing Lease while transferring (a lost lease cancels the transfer, like an interrupt)
+	ctx, stopLease := database.KeepLease(ctx, containerName, blobName, snapshot)
+	defer stopLease()
+
+	// Waiting for Free Space: size captured by the sync, 0 when unknown (only the high-water mark is checked)
+	blobSize, _ := strconv.ParseInt(syncContent["size"], 10, 64)
+	reservation, spaceErr := env.Space.Reserve(ctx, blobSize)
+	if spaceErr != nil && ctx.Err() != nil { // Interrupted while paused: Picked up by the next run
+		log.Warn("Download Interrupted while waiting for free space")
+		recordFlagError(log, containerName, blobName, database.SetAzureFlag(containerName, blobName, snapshot, statusInterrupted, "interrupted"))
+		return
+	} else if spaceErr != nil {
+		log.Error("Not enough space in media folder", logger.Fields{"bytes": blobSize, "error": spaceErr})
+		failDownload(log, containerName, blobName, snapshot, spaceErr)
+		return
+	}
+	defer reservation.Release()
 
-	// Create a BlobURL object to a blob in the container (we assume the container & blob already exist).
-	azureURL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s", accountName, containerName, blobName))
-	blobURL := azblob.NewBlobURL(*azureURL, azurePipeline)
+	// Create a BlobURL object to a blob (snapshot) in the container (we assume the container & blob already exist).
+	blobURL, urlErr := env.Azure.BlobURL(containerName, blobName, snapshot)
+	if urlErr != nil {
+		log.Error("Invalid Blob URL", logger.Fields{"error": urlErr})
+		failDownload(log, containerName, blobName, snapshot, urlErr)
+		return
+	}
 
 	contentLength := int64(0) // Used for progress reporting to report the total number of bytes being downloaded.
 
+	var blobProperties database.BlobProperties // HTTP headers and metadata carried over to S3
+
 	// NewGetRetryStream creates an intelligent retryable stream around a blob; it returns an io.ReadCloser.
-	retryStream := azblob.NewDownloadStream(context.Background(),
+	retryStream := azblob.NewDownloadStream(ctx,
 		// We pass more tha "blobUrl.GetBlob" here so we can capture the blob's full
 		// content length on the very first internal call to Read.
 		func(ctx context.Context, blobRange azblob.BlobRange, ac azblob.BlobAccessConditions, rangeGetContentMD5 bool) (*azblob.GetResponse, error) {
@@ -126,47 +229,104 @@ func startWorker(wg *sync.WaitGroup, syncContent map[string]string, downloadQueu
 			if err == nil && contentLength == 0 {
 				// If 1st successful Get, record blob's full size for progress reporting
 				contentLength = get.ContentLength()
+				transfer.SetTotal(contentLength)
+
+				// Record blob's HTTP headers and metadata for the S3 upload
+				blobProperties = database.BlobProperties{
+					ContentType:        get.ContentType(),
+					CacheControl:       get.CacheControl(),
+					ContentDisposition: get.ContentDisposition(),
+					ContentEncoding:    get.ContentEncoding(),
+					Metadata:           get.NewMetadata(),
+					Size:               contentLength,
+				}
 			}
 			return get, err
 		},
 		azblob.DownloadStreamOptions{})
 
-	// NewResponseBodyStream wraps the GetRetryStream with progress reporting; it returns an io.ReadCloser.
-	stream := pipeline.NewResponseBodyProgress(retryStream,
-		func(bytesTransferred int64) {
-			bar := progressbar.NewOptions(int(contentLength),
-				progressbar.OptionEnableColorCodes(true),
-				progressbar.OptionSetBytes(100),
-				progressbar.OptionSetWidth(15),
-				progressbar.OptionSetDescription("[cyan]"+blobName+" {"+bytefmt.ByteSize(uint64(bytesTransferred))+"/"+bytefmt.ByteSize(uint64(contentLength))+"}[reset]"),
-				progressbar.OptionSetTheme(progressbar.Theme{
-					Saucer:        "[green]=[reset]",
-					SaucerHead:    "[green]>[reset]",
-					SaucerPadding: " ",
-					BarStart:      "[",
-					BarEnd:        "]",
-				}))
-
-			bar.Add(int(bytesTransferred))
-		})
+	// Progress is read from the transfer (see progress package), every worker shares one display
+	stream := retryStream
 	defer stream.Close() // The client must close the response body when finished with it
 
-	// Create the file to hold the downloaded blob contents.
-	file, _ := os.Create(mediaFolder + fileName)
-	defer file.Close()
+	// Create the file to hold the downloaded blob contents (in the virtual directories of the blob name).
+	file, fileErr := createFile(mediaFolder + fileName)
+	if fileErr != nil {
+		log.Error("Unable to create file", logger.Fields{"file": mediaFolder + fileName, "error": fileErr})
+		failDownload(log, containerName, blobName, snapshot, fileErr)
+		return
+	}
+
+	// Write to the file by reading from the blob (with intelligent retries), written bytes leave the space reservation.
+	downloadedBytes, downloadErr := io.Copy(reservation.Writer(file), env.Limiter.Reader(ctx, transfer.Reader(metrics.CountReader(stream, metrics.BytesDownloaded))))
+
+	// Closing before the row is completed: network file systems (NFS / SMB) may only report a failed write here
+	if closeErr := file.Close(); downloadErr == nil && closeErr != nil {
+		downloadErr = closeErr
+	}
+	if downloadErr == nil && downloadedBytes != contentLength {
+		downloadErr = fmt.Errorf("downloaded %d of %d bytes", downloadedBytes, contentLength)
+	}
 
-	// Write to the file by reading from the blob (with intelligent retries).
-	_, downloadErr := io.Copy(file, stream)
-	if downloadErr != nil { // Handling Download Error
-		fmt.Print("Download Error!!")
-		delete(downloadQueue, blobName)
-		database.SetAzureFlag(containerName, blobName, statusFailed, downloadErr.Error())
+	if downloadErr != nil && ctx.Err() != nil { // Interrupted: Picked up by the next run
+		log.Warn("Download Interrupted", logger.Fields{"bytes": downloadedBytes, "duration": time.Since(startTime)})
+		recordFlagError(log, containerName, blobName, database.SetAzureFlag(containerName, blobName, snapshot, statusInterrupted, "interrupted"))
+		os.Remove(mediaFolder + fileName) // Deleting Partial File
+	} else if errors.Is(downloadErr, syscall.ENOSPC) { // Disk Full (space used by another process): Retried by the next run
+		log.Warn("Media folder is full, download left for the next run", logger.Fields{"bytes": downloadedBytes, "error": downloadErr})
+		if id, idErr := strconv.ParseInt(syncContent["id"], 10, 64); idErr == nil {
+			diskFull.Lock()
+			diskFull.ids = append(diskFull.ids, id)
+			diskFull.Unlock()
+		}
+		recordFlagError(log, containerName, blobName, database.SetAzureFlag(containerName, blobName, snapshot, statusInterrupted, downloadErr.Error()))
+		os.Remove(mediaFolder + fileName) // Deleting Partial File
+	} else if downloadErr != nil { // Handling Download Error
+		log.Error("Download Error!!", logger.Fields{"bytes": downloadedBytes, "duration": time.Since(startTime), "error": downloadErr})
+		failDownload(log, containerName, blobName, snapshot, downloadErr)
 		os.Remove(mediaFolder + fileName) // Deleting Corrupt File
 	} else { // Download Completed
-		fmt.Println("\n[Completed]: ", blobName)
-		delete(downloadQueue, blobName)
-		database.SetAzureFlag(containerName, blobName, statusCompleted, "")
+		log.Info("[Completed]", logger.Fields{"bytes": downloadedBytes, "duration": time.Since(startTime)})
+		recordFlagError(log, containerName, blobName, database.SetBlobProperties(containerName, blobName, snapshot, blobProperties))
+		recordFlagError(log, containerName, blobName, database.SetAzureFlag(containerName, blobName, snapshot, statusCompleted, ""))
+		metrics.FilesCompleted.WithLabelValues(metrics.StageDownload).Inc()
 	}
+}
 
-	fmt.Println("Pending File(s): ", downloadQueue)
+// Create file, with its missing parent folder(s)
+//
+// @param path string
+// @return WriteCloser (File), error
+func createMediaFile(path string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err // Not a nil *os.File in a non-nil interface
+	}
+
+	return file, nil
+}
+
+// Mark blob as failed (azure_status 2 + azure_error) and record it for the run summary
+//
+// @param log Logger, containerName string, blobName string, snapshot string, err error
+// @return nil
+func failDownload(log *logger.Logger, containerName string, blobName string, snapshot string, err error) {
+	failures.Record(metrics.StageDownload, containerName, blobName, err)
+	metrics.FilesFailed.WithLabelValues(metrics.StageDownload).Inc()
+	recordFlagError(log, containerName, blobName, database.SetAzureFlag(containerName, blobName, snapshot, statusFailed, err.Error()))
+}
+
+// Record a failed sync table write of a blob for the run summary
+//
+// @param log Logger, containerName string, blobName string, err error
+// @return nil
+func recordFlagError(log *logger.Logger, containerName string, blobName string, err error) {
+	if err != nil {
+		log.Error("[Failed] Updating Sync Table", logger.Fields{"error": err})
+		failures.Record(metrics.StageDownload, containerName, blobName, err)
+	}
 }
@@ -0,0 +1,308 @@
+// Namespace: download/azure/main_test.go
+
+package azure
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"../../database"    // DB Handler Package
+	"../../diskspace"   // Media Folder Free Space Guard
+	"../../endpoint"    // Azure Endpoint
+	"../../fakestorage" // Fake Blob / S3 Service(s)
+	"../../helpers"     // Helper Package
+)
+
+// Fake storage account, a fresh DB, an empty media folder and the download setting(s) of a test
+func setupDownload(t *testing.T) (*fakestorage.Azure, EnvVars) {
+	t.Helper()
+
+	fake := fakestorage.NewAzure("account")
+	t.Cleanup(fake.Close)
+	fakestorage.OpenDB(t)
+
+	azure, err := endpoint.ParseAzureConnectionString(fake.ConnectionString())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fake, EnvVars{Azure: azure, MediaFolder: t.TempDir() + "/", SnapshotSuffix: helpers.DefaultSnapshotSuffix, Log: fakestorage.Logger(t)}
+}
+
+// Insert sync row(s) of blob(s) in the fake account, as the sync does
+func insertRows(t *testing.T, rows ...database.SyncRow) {
+	t.Helper()
+
+	for _, result := range database.InsertBlobs(rows) {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	fake, env := setupDownload(t)
+
+	content := bytes.Repeat([]byte("media"), 200*1024) // 1MB
+	fake.PutBlob("media", "a.mp4", fakestorage.Blob{Content: content, ContentType: "video/mp4", CacheControl: "max-age=60",
+		Metadata: map[string]string{"owner": "ops"}})
+	snapshot := fake.SnapshotBlob("media", "a.mp4", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	fake.PutBlob("media", "a.mp4", fakestorage.Blob{Content: []byte("current"), ContentType: "video/mp4"})
+	insertRows(t, database.SyncRow{Container: "media", Blob: "a.mp4", Snapshot: snapshot}, database.SyncRow{Container: "media", Blob: "a.mp4"})
+	env.SnapshotSuffix = "-v{snapshot}" // S3_SNAPSHOT_SUFFIX: the upload reads the file by the same name
+
+	if !Run(context.Background(), env) {
+		t.Fatal("download failed")
+	}
+	if completed := fakestorage.RowsWithStatus(t, "completed", "azure"); len(completed) != 2 {
+		t.Fatalf("completed rows = %+v", completed)
+	}
+
+	// Base blob and snapshot, each in its own file
+	files := map[string][]byte{
+		"a.mp4":              []byte("current"),
+		"a.mp4-v" + snapshot: content,
+	}
+	for name, want := range files {
+		got, err := ioutil.ReadFile(filepath.Join(env.MediaFolder, name))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("file %s: %d byte(s), want %d (%v)", name, len(got), len(want), err)
+		}
+	}
+
+	// Header(s) and metadata recorded for the upload
+	pending, err := database.GetPendingS3Content(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range pending {
+		if row["snapshot"] == snapshot && (row["content_type"] != "video/mp4" || row["cache_control"] != "max-age=60" || helpers.DecodeMetadata(row["metadata"])["owner"] != "ops") {
+			t.Errorf("snapshot properties = %v", row)
+		}
+	}
+}
+
+func TestDownloadNestedName(t *testing.T) {
+	fake, env := setupDownload(t)
+	fake.PutBlob("media", "2019/05/a.mp4", fakestorage.Blob{Content: []byte("nested")})
+	insertRows(t, database.SyncRow{Container: "media", Blob: "2019/05/a.mp4"})
+
+	if !Run(context.Background(), env) {
+		t.Fatal("download failed")
+	}
+	if completed := fakestorage.RowsWithStatus(t, "completed", "azure"); len(completed) != 1 {
+		t.Fatalf("completed rows = %+v", completed)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(env.MediaFolder, "2019", "05", "a.mp4")); err != nil || string(got) != "nested" {
+		t.Errorf("nested file = %q, %v", got, err)
+	}
+}
+
+func TestDownloadFailures(t *testing.T) {
+	fake, env := setupDownload(t)
+
+	faults := map[string]fakestorage.FaultKind{"missing.mp4": fakestorage.NotFound, "reset.mp4": fakestorage.ResetBody}
+	if !testing.Short() {
+		faults["busy.mp4"] = fakestorage.Unavailable // Every retry of the pipeline fails (~30s)
+	}
+	for name, kind := range faults {
+		fake.PutBlob("media", name, fakestorage.Blob{Content: bytes.Repeat([]byte("x"), 64*1024)})
+		insertRows(t, database.SyncRow{Container: "media", Blob: name})
+		fake.Inject(fakestorage.Fault{Path: "/account/media/" + name, Kind: kind})
+	}
+
+	if !Run(context.Background(), env) {
+		t.Fatal("download failed")
+	}
+
+	failed := fakestorage.RowsWithStatus(t, "failed", "azure")
+	if len(failed) != len(faults) {
+		t.Fatalf("failed rows = %+v", failed)
+	}
+	for _, row := range failed {
+		if len(row.AzureError) == 0 {
+			t.Errorf("%s: no error recorded", row.Blob)
+		}
+		if _, err := os.Stat(filepath.Join(env.MediaFolder, row.Blob)); !os.IsNotExist(err) {
+			t.Errorf("%s: partial file left behind (%v)", row.Blob, err)
+		}
+	}
+	if hits := fake.Hits("GET", "/account/media/busy.mp4"); !testing.Short() && hits < 2 {
+		t.Errorf("503 request(s) = %d, want retries", hits)
+	}
+}
+
+func TestDownloadRetried(t *testing.T) {
+	fake, env := setupDownload(t)
+	fake.PutBlob("media", "a.mp4", fakestorage.Blob{Content: []byte("content")})
+	insertRows(t, database.SyncRow{Container: "media", Blob: "a.mp4"})
+
+	// Throttled once: retried by the request pipeline
+	fake.Inject(fakestorage.Fault{Path: "/account/media/a.mp4", Kind: fakestorage.Throttle, Times: 1})
+	if !Run(context.Background(), env) {
+		t.Fatal("download failed")
+	}
+
+	if completed := fakestorage.RowsWithStatus(t, "completed", "azure"); len(completed) != 1 {
+		t.Fatalf("completed rows = %+v", completed)
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(env.MediaFolder, "a.mp4")); string(got) != "content" {
+		t.Errorf("file content = %q", got)
+	}
+}
+
+func TestDownloadSpaceGuard(t *testing.T) {
+	fake, env := setupDownload(t)
+	env.Space = diskspace.NewGuard(env.MediaFolder, diskspace.Mark{UsedPercent: 100}, time.Hour, env.Log)
+
+	fake.PutBlob("media", "a.mp4", fakestorage.Blob{Content: []byte("content")})
+	fake.PutBlob("media", "huge.mp4", fakestorage.Blob{Content: []byte("listed larger than any volume")})
+	insertRows(t, database.SyncRow{Container: "media", Blob: "a.mp4", Properties: database.BlobProperties{Size: 7}},
+		database.SyncRow{Container: "media", Blob: "huge.mp4", Properties: database.BlobProperties{Size: 1 << 60}})
+
+	if !Run(context.Background(), env) {
+		t.Fatal("download failed")
+	}
+
+	if completed := fakestorage.RowsWithStatus(t, "completed", "azure"); len(completed) != 1 || completed[0].Blob != "a.mp4" {
+		t.Errorf("completed rows = %+v", completed)
+	}
+	if failed := fakestorage.RowsWithStatus(t, "failed", "azure"); len(failed) != 1 || fake.Hits("GET", "/account/media/huge.mp4") != 0 {
+		t.Errorf("failed rows = %+v, huge.mp4 requested %d time(s)", failed, fake.Hits("GET", "/account/media/huge.mp4"))
+	}
+}
+
+func TestDownloadDryRun(t *testing.T) {
+	fake, env := setupDownload(t)
+	fake.PutBlob("media", "a.mp4", fakestorage.Blob{Content: []byte("media")})
+	fake.PutBlob("media", "dir/b.mp4", fakestorage.Blob{Content: []byte("more media")})
+	insertRows(t, database.SyncRow{Container: "media", Blob: "a.mp4"}, database.SyncRow{Container: "media", Blob: "dir/b.mp4"})
+
+	database.SetDryRun(true)
+	defer database.SetDryRun(false)
+	env.DryRun = true
+	files := dryRunFiles
+
+	if !Run(context.Background(), env) {
+		t.Fatal("dry run failed")
+	}
+	if reported := dryRunFiles - files; reported != 2 {
+		t.Errorf("dry run reported %d file(s), want 2", reported)
+	}
+
+	// Nothing written, every row still pending
+	if files, err := ioutil.ReadDir(env.MediaFolder); err != nil || len(files) != 0 {
+		t.Errorf("media folder = %v, %v; want empty", files, err)
+	}
+	if pending := fakestorage.RowsWithStatus(t, "pending", "azure"); len(pending) != 2 {
+		t.Errorf("pending rows = %+v", pending)
+	}
+}
+
+// Media file failing with writeErr once more than limit byte(s) are written, or with closeErr on Close
+type faultyFile struct {
+	io.WriteCloser
+	limit    int
+	written  int
+	writeErr error
+	closeErr error
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if f.writeErr != nil && f.written+len(p) > f.limit {
+		return 0, &os.PathError{Op: "write", Path: "media", Err: f.writeErr}
+	}
+	n, err := f.WriteCloser.Write(p)
+	f.written += n
+
+	return n, err
+}
+
+func (f *faultyFile) Close() error {
+	if err := f.WriteCloser.Close(); err != nil || f.closeErr == nil {
+		return err
+	}
+
+	return &os.PathError{Op: "close", Path: "media", Err: f.closeErr}
+}
+
+// Create the file of a.mp4 as fault, every other file as usual
+func faultyMediaFile(t *testing.T, fault faultyFile) {
+	t.Helper()
+
+	t.Cleanup(func() { createFile = createMediaFile })
+	createFile = func(path string) (io.WriteCloser, error) {
+		file, err := createMediaFile(path)
+		if err != nil || filepath.Base(path) != "a.mp4" {
+			return file, err
+		}
+
+		wrapped := fault
+		wrapped.WriteCloser = file
+		return &wrapped, nil
+	}
+}
+
+func TestDownloadDiskFull(t *testing.T) {
+	fake, env := setupDownload(t)
+	fake.PutBlob("media", "a.mp4", fakestorage.Blob{Content: bytes.Repeat([]byte("media"), 1000)})
+	fake.PutBlob("media", "b.mp4", fakestorage.Blob{Content: []byte("content")})
+	insertRows(t, database.SyncRow{Container: "media", Blob: "a.mp4"}, database.SyncRow{Container: "media", Blob: "b.mp4"})
+	faultyMediaFile(t, faultyFile{limit: 100, writeErr: syscall.ENOSPC})
+
+	// Disk full while writing a.mp4: left for the next run, not downloaded again and again
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !Run(ctx, env) || ctx.Err() != nil {
+		t.Fatalf("download did not return (%v)", ctx.Err())
+	}
+
+	if hits := fake.Hits("GET", "/account/media/a.mp4"); hits != 1 {
+		t.Errorf("a.mp4 downloaded %d time(s), want once", hits)
+	}
+	if interrupted := fakestorage.RowsWithStatus(t, "interrupted", "azure"); len(interrupted) != 1 || interrupted[0].Blob != "a.mp4" ||
+		!strings.Contains(interrupted[0].AzureError, "no space left") {
+		t.Errorf("interrupted rows = %+v", interrupted)
+	}
+	if _, err := os.Stat(filepath.Join(env.MediaFolder, "a.mp4")); !os.IsNotExist(err) {
+		t.Errorf("partial file kept: %v", err)
+	}
+	if completed := fakestorage.RowsWithStatus(t, "completed", "azure"); len(completed) != 1 || completed[0].Blob != "b.mp4" {
+		t.Errorf("completed rows = %+v", completed)
+	}
+
+	// Next run, space freed up
+	createFile = createMediaFile
+	if !Run(context.Background(), env) {
+		t.Fatal("download failed")
+	}
+	if completed := fakestorage.RowsWithStatus(t, "completed", "azure"); len(completed) != 2 {
+		t.Errorf("completed rows after next run = %+v", completed)
+	}
+}
+
+func TestDownloadCloseError(t *testing.T) {
+	fake, env := setupDownload(t)
+	fake.PutBlob("media", "a.mp4", fakestorage.Blob{Content: []byte("content")})
+	insertRows(t, database.SyncRow{Container: "media", Blob: "a.mp4"})
+	faultyMediaFile(t, faultyFile{closeErr: syscall.EIO}) // Network file system: failed write reported on close
+
+	if !Run(context.Background(), env) {
+		t.Fatal("download failed")
+	}
+
+	if failed := fakestorage.RowsWithStatus(t, "failed", "azure"); len(failed) != 1 || !strings.Contains(failed[0].AzureError, "input/output error") {
+		t.Errorf("failed rows = %+v", failed)
+	}
+	if _, err := os.Stat(filepath.Join(env.MediaFolder, "a.mp4")); !os.IsNotExist(err) {
+		t.Errorf("file of a failed close kept: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+// Namespace: endpoint/azure.go
+
+package endpoint
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"../metrics" // Prometheus Metrics
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob" // Azure Blob Package
+)
+
+// Azurite (emulator) well-known development account
+const (
+	azuriteAccount  = "devstoreaccount1"
+	azuriteKey      = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	azuriteEndpoint = "http://127.0.0.1:10000/devstoreaccount1"
+)
+
+// Snapshot time layout of the blob service (snapshot query parameter), in UTC so that snapshot(s) of a blob sort by time
+const snapshotLayout = "2006-01-02T15:04:05.0000000Z"
+
+// Blob service domain suffix per Azure cloud (.env: AZURE_CLOUD)
+var azureClouds = map[string]string{
+	"public":     "core.windows.net",
+	"china":      "core.chinacloudapi.cn",
+	"government": "core.usgovcloudapi.net",
+}
+
+// Azure - Blob service endpoint and credential of a storage account
+type Azure struct {
+	AccountName string
+	AccountKey  string  // Shared key auth (preferred over SAS)
+	SASToken    string  // SAS auth (query string, without "?")
+	Endpoint    url.URL // Blob service URL, container / blob are appended to its path (path-style for Azurite)
+}
+
+// AzureFromEnv - Storage account of the .env: AZURE_STORAGE_CONNECTION_STRING, or AZURE_STORAGE_ACCOUNT with
+// AZURE_STORAGE_ACCESS_KEY / AZURE_STORAGE_SAS_TOKEN and optionally AZURE_STORAGE_ENDPOINT / AZURE_CLOUD
+func AzureFromEnv() (Azure, error) {
+	if connectionString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); len(connectionString) != 0 {
+		return ParseAzureConnectionString(connectionString)
+	}
+
+	suffix, ok := azureClouds[os.Getenv("AZURE_CLOUD")]
+	if !ok && len(os.Getenv("AZURE_CLOUD")) != 0 {
+		return Azure{}, fmt.Errorf("invalid AZURE_CLOUD %q (public, china, government)", os.Getenv("AZURE_CLOUD"))
+	}
+
+	return newAzure(os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_ACCESS_KEY"), os.Getenv("AZURE_STORAGE_SAS_TOKEN"),
+		os.Getenv("AZURE_STORAGE_ENDPOINT"), "https", suffix)
+}
+
+// ParseAzureConnectionString - Storage account of a connection string, e.g.
+// "DefaultEndpointsProtocol=https;AccountName=...;AccountKey=...;EndpointSuffix=core.chinacloudapi.cn",
+// "BlobEndpoint=https://...;SharedAccessSignature=sv=..." or "UseDevelopmentStorage=true" (Azurite)
+func ParseAzureConnectionString(connectionString string) (Azure, error) {
+	settings := map[string]string{}
+	for _, part := range strings.Split(connectionString, ";") {
+		if len(strings.TrimSpace(part)) == 0 {
+			continue
+		}
+
+		pair := strings.SplitN(part, "=", 2) // Key / SAS values contain "="
+		if len(pair) != 2 {
+			return Azure{}, fmt.Errorf("invalid connection string setting %q", part)
+		}
+		settings[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+	}
+
+	if strings.EqualFold(settings["UseDevelopmentStorage"], "true") {
+		return newAzure(azuriteAccount, azuriteKey, "", azuriteEndpoint, "http", "")
+	}
+
+	protocol := settings["DefaultEndpointsProtocol"]
+	if len(protocol) == 0 {
+		protocol = "https"
+	}
+
+	return newAzure(settings["AccountName"], settings["AccountKey"], settings["SharedAccessSignature"], settings["BlobEndpoint"], protocol, settings["EndpointSuffix"])
+}
+
+// Validate setting(s) and build the blob service URL
+//
+// @param accountName, accountKey, sasToken, endpoint (overrides protocol / suffix), protocol, suffix (default: public cloud)
+// @return Azure, error
+func newAzure(accountName string, accountKey string, sasToken string, endpoint string, protocol string, suffix string) (Azure, error) {
+	azure := Azure{AccountName: accountName, AccountKey: accountKey, SASToken: strings.TrimPrefix(sasToken, "?")}
+
+	if len(accountKey) == 0 && len(azure.SASToken) == 0 {
+		return Azure{}, fmt.Errorf("azure credentials are missing: account key or SAS token")
+	}
+
+	if len(accountKey) != 0 && len(accountName) == 0 {
+		return Azure{}, fmt.Errorf("azure account name is missing (required by the account key)")
+	}
+
+	if len(endpoint) == 0 {
+		if len(accountName) == 0 {
+			return Azure{}, fmt.Errorf("azure account name or blob endpoint is missing")
+		}
+		if len(suffix) == 0 {
+			suffix = azureClouds["public"]
+		}
+		endpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, accountName, suffix)
+	}
+
+	endpointURL, parseErr := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if parseErr != nil || len(endpointURL.Host) == 0 {
+		return Azure{}, fmt.Errorf("invalid azure blob endpoint %q", endpoint)
+	}
+	if len(accountKey) == 0 {
+		endpointURL.RawQuery = azure.SASToken // Sent with every request (kept by container / blob URL(s))
+	}
+
+	azure.Endpoint = *endpointURL
+	return azure, nil
+}
+
+// Credential - Shared key credential, or anonymous when the SAS token in the URL authorizes the request(s)
+func (a Azure) Credential() azblob.Credential {
+	if len(a.AccountKey) == 0 {
+		return azblob.NewAnonymousCredential()
+	}
+
+	return azblob.NewSharedKeyCredential(a.AccountName, a.AccountKey)
+}
+
+// ServiceURL - Blob service of the storage account, with a new request pipeline
+func (a Azure) ServiceURL() azblob.ServiceURL {
+	return azblob.NewServiceURL(a.Endpoint, metrics.NewAzurePipeline(a.Credential()))
+}
+
+// BlobURL - Blob of a container (or a snapshot of it, see FormatSnapshot), with a new request pipeline
+func (a Azure) BlobURL(containerName string, blobName string, snapshot string) (azblob.BlobURL, error) {
+	blobURL := a.ServiceURL().NewContainerURL(containerName).NewBlobURL(blobName)
+	if len(snapshot) == 0 {
+		return blobURL, nil // Base Blob
+	}
+
+	snapshotTime, parseErr := time.Parse(snapshotLayout, snapshot)
+	if parseErr != nil {
+		return azblob.BlobURL{}, fmt.Errorf("invalid blob snapshot %q: %v", snapshot, parseErr)
+	}
+
+	return blobURL.WithSnapshot(snapshotTime), nil
+}
+
+// FormatSnapshot - Snapshot time of a listed blob as stored in the sync table, empty for the base blob
+func FormatSnapshot(snapshot time.Time) string {
+	if snapshot.IsZero() {
+		return ""
+	}
+
+	return snapshot.UTC().Format(snapshotLayout)
+}
+
+// String - Blob service URL without the SAS token (logging)
+func (a Azure) String() string {
+	endpoint := a.Endpoint
+	endpoint.RawQuery = ""
+
+	return endpoint.String()
+}
@@ -0,0 +1,95 @@
+// Namespace: endpoint/s3.go
+
+package endpoint
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"                      // AWS Core SDK
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds" // Assume Role Credential(s)
+	"github.com/aws/aws-sdk-go/aws/session"              // Maintains AWS Session
+)
+
+// S3 - Bucket and endpoint of Amazon S3 or an S3-compatible store (MinIO, Ceph, Wasabi, Snowball Edge)
+type S3 struct {
+	Bucket     string
+	Region     string
+	Endpoint   string // Empty: Amazon S3 of the region
+	PathStyle  bool   // https://host/bucket/key instead of https://bucket.host/key
+	Profile    string // Shared config / credentials profile (default: AWS_PROFILE)
+	RoleARN    string // Assumed on top of the resolved credential(s)
+	ExternalID string // Required by the role's trust policy (third-party access)
+}
+
+// S3FromEnv - Bucket of the .env: AWS_BUCKET, AWS_DEFAULT_REGION and optionally
+// AWS_ENDPOINT_URL, AWS_S3_FORCE_PATH_STYLE, AWS_PROFILE, AWS_ASSUME_ROLE_ARN, AWS_EXTERNAL_ID
+func S3FromEnv() (S3, error) {
+	bucket := S3{
+		Bucket:     os.Getenv("AWS_BUCKET"),
+		Region:     os.Getenv("AWS_DEFAULT_REGION"),
+		Endpoint:   strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/"),
+		PathStyle:  os.Getenv("AWS_S3_FORCE_PATH_STYLE") == "true",
+		Profile:    os.Getenv("AWS_PROFILE"),
+		RoleARN:    os.Getenv("AWS_ASSUME_ROLE_ARN"),
+		ExternalID: os.Getenv("AWS_EXTERNAL_ID"),
+	}
+
+	if len(bucket.Bucket) == 0 || len(bucket.Region) == 0 {
+		return S3{}, fmt.Errorf("aws bucket or region is missing")
+	}
+
+	if len(bucket.ExternalID) != 0 && len(bucket.RoleARN) == 0 {
+		return S3{}, fmt.Errorf("aws external ID is set without a role to assume (AWS_ASSUME_ROLE_ARN)")
+	}
+
+	return bucket, nil
+}
+
+// Session - AWS session of the bucket's S3 client(s), shared by every upload of the run
+//
+// Credential(s) resolve through the standard chain: environment (AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY),
+// web identity (AWS_WEB_IDENTITY_TOKEN_FILE + AWS_ROLE_ARN), shared credentials / config profile (including
+// role_arn + external_id), then container / EC2 instance role; RoleARN is assumed on top of them.
+// Endpoint and PathStyle only apply to S3: credential(s) are resolved by a session without them, so that
+// AssumeRole / web identity call(s) go to AWS STS, not to the S3-compatible store.
+// wrap decorates the HTTP transport (throttling, metrics) once the SDK has configured it (AWS_CA_BUNDLE).
+func (s S3) Session(wrap func(http.RoundTripper) http.RoundTripper) (*session.Session, error) {
+	sess, sessionErr := session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{
+			Region:     aws.String(s.Region),
+			HTTPClient: &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		},
+		Profile:           s.Profile,
+		SharedConfigState: session.SharedConfigEnable, // Region / role setting(s) of ~/.aws/config
+	})
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	sess.Config.HTTPClient.Transport = wrap(sess.Config.HTTPClient.Transport)
+
+	s3Config := &aws.Config{S3ForcePathStyle: aws.Bool(s.PathStyle)}
+	if len(s.Endpoint) != 0 {
+		s3Config.Endpoint = aws.String(s.Endpoint)
+	}
+	if len(s.RoleARN) != 0 {
+		s3Config.Credentials = stscreds.NewCredentials(sess, s.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if len(s.ExternalID) != 0 {
+				p.ExternalID = aws.String(s.ExternalID)
+			}
+		})
+	}
+
+	return sess.Copy(s3Config), nil
+}
+
+// String - Bucket location (logging)
+func (s S3) String() string {
+	if len(s.Endpoint) == 0 {
+		return fmt.Sprintf("s3://%s (%s)", s.Bucket, s.Region)
+	}
+
+	return fmt.Sprintf("s3://%s (%s)", s.Bucket, s.Endpoint)
+}
@@ -0,0 +1,113 @@
+// Namespace: endpoint/s3_test.go
+
+package endpoint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"        // AWS Core SDK
+	"github.com/aws/aws-sdk-go/service/s3" // S3 Client
+)
+
+// Transport answering STS call(s) with temporary credential(s) and every other request with 404,
+// recording the host of each request
+type recordingTransport struct {
+	mutex sync.Mutex
+	hosts map[string][]string // Host => STS action(s) / S3 path(s)
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var form url.Values
+	if req.Body != nil { // STS: form in the body
+		body, _ := ioutil.ReadAll(req.Body)
+		form, _ = url.ParseQuery(string(body))
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	action := form.Get("Action")
+	if len(action) == 0 {
+		r.hosts[req.URL.Host] = append(r.hosts[req.URL.Host], req.URL.Path)
+		return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader("")), Header: http.Header{}, Request: req}, nil
+	}
+
+	r.hosts[req.URL.Host] = append(r.hosts[req.URL.Host], action)
+	response := fmt.Sprintf(`<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><%[1]sResult><Credentials>
+		<AccessKeyId>AKID</AccessKeyId><SecretAccessKey>SECRET</SecretAccessKey><SessionToken>TOKEN</SessionToken>
+		<Expiration>2100-01-01T00:00:00Z</Expiration></Credentials></%[1]sResult></%[1]sResponse>`, action)
+
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(response)),
+		Header: http.Header{"Content-Type": {"text/xml"}}, Request: req}, nil
+}
+
+func TestSessionCredentialsSkipEndpoint(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("web-identity-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		env    map[string]string
+		bucket S3
+		action string
+	}{
+		"assume role": {
+			env:    map[string]string{"AWS_ACCESS_KEY_ID": "key", "AWS_SECRET_ACCESS_KEY": "secret"},
+			bucket: S3{RoleARN: "arn:aws:iam::123456789012:role/sync", ExternalID: "partner"},
+			action: "AssumeRole",
+		},
+		"web identity": {
+			env:    map[string]string{"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile, "AWS_ROLE_ARN": "arn:aws:iam::123456789012:role/sync"},
+			action: "AssumeRoleWithWebIdentity",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_CA_BUNDLE",
+				"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME"} {
+				t.Setenv(key, tc.env[key])
+			}
+			t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+			t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+			t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+			bucket := tc.bucket
+			bucket.Bucket, bucket.Region, bucket.Endpoint, bucket.PathStyle = "bucket", "us-east-1", "https://minio.local:9000", true
+
+			transport := &recordingTransport{hosts: map[string][]string{}}
+			sess, err := bucket.Session(func(http.RoundTripper) http.RoundTripper { return transport })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// S3 call(s): the S3-compatible store, path style; credential(s): AWS STS
+			s3.New(sess).HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("a.mp4")})
+
+			transport.mutex.Lock()
+			defer transport.mutex.Unlock()
+			if calls := transport.hosts["minio.local:9000"]; len(calls) == 0 || calls[0] != "/bucket/a.mp4" {
+				t.Errorf("S3 request(s) to the endpoint = %v, all request(s) = %v", calls, transport.hosts)
+			}
+			for host, calls := range transport.hosts {
+				if host == "minio.local:9000" {
+					continue
+				}
+				if !strings.HasPrefix(host, "sts.") || !strings.HasSuffix(host, ".amazonaws.com") || len(calls) != 1 || calls[0] != tc.action {
+					t.Errorf("request(s) to %s = %v, want %s to AWS STS", host, calls, tc.action)
+				}
+			}
+			if len(transport.hosts) != 2 {
+				t.Errorf("request(s) = %v, want %s to AWS STS and the object request to the endpoint", transport.hosts, tc.action)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Namespace: failures/main.go
+
+package failures
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"../logger" // Leveled Logger
+)
+
+// Global Constant(s)
+const (
+	samplesPerGroup = 3    // Failure(s) kept per stage and message
+	groupsPerStage  = 1000 // Distinct message(s) kept per stage, further ones are only counted
+)
+
+// Global Variable(s)
+var mutex sync.Mutex
+var total int                       // Failure(s) of the current run
+var stageCounts = map[string]int{}  // Failure(s) per stage
+var groups = map[[2]string]*group{} // Failure(s) per stage and message
+var order []*group                  // Group(s) in order of their first failure
+var stageGroups = map[string]int{}  // Group(s) per stage
+var overflow = map[string]int{}     // Failure(s) per stage whose message didn't fit in groupsPerStage
+
+// Failure - Error of a single container / blob, recorded instead of stopping the run
+type Failure struct {
+	Stage     string
+	Container string
+	Blob      string
+	Message   string
+}
+
+// Failure(s) of a stage sharing their message: counted, and the first samplesPerGroup ones kept
+type group struct {
+	samples []Failure
+	count   int
+}
+
+// Record - Remember a per-item error for the run summary
+func Record(stage string, container string, blob string, err error) {
+	if err == nil {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	total++
+	stageCounts[stage]++
+
+	key := [2]string{stage, err.Error()}
+	g := groups[key]
+	if g == nil {
+		if stageGroups[stage] >= groupsPerStage {
+			overflow[stage]++
+			return
+		}
+
+		stageGroups[stage]++
+		g = &group{}
+		groups[key] = g
+		order = append(order, g)
+	}
+
+	g.count++
+	if len(g.samples) < samplesPerGroup {
+		g.samples = append(g.samples, Failure{Stage: stage, Container: container, Blob: blob, Message: err.Error()})
+	}
+}
+
+// Count - Number of failure(s) recorded so far
+func Count() int {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return total
+}
+
+// LogSummary - Log failure count(s) per stage and the most frequent message(s)
+func LogSummary(log *logger.Logger, top int) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if total == 0 {
+		return
+	}
+
+	fields := logger.Fields{}
+	for stage, count := range stageCounts {
+		fields[stage] = count
+	}
+
+	sorted := append([]*group(nil), order...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].count > sorted[j].count })
+
+	log.Error(fmt.Sprintf("Run finished with %d error(s)", total), fields)
+	for idx, g := range sorted {
+		if idx == top {
+			log.Error(fmt.Sprintf("... and %d more error message(s)", len(sorted)-top))
+			break
+		}
+
+		first := g.samples[0]
+		groupFields := logger.Fields{"stage": first.Stage}
+		if len(first.Container) != 0 {
+			groupFields["container"] = first.Container // Example
+		}
+		if len(first.Blob) != 0 {
+			groupFields["blob"] = first.Blob // Example
+		}
+		if len(g.samples) > 1 {
+			var more []string
+			for _, sample := range g.samples[1:] {
+				more = append(more, strings.TrimSuffix(sample.Container+"/"+sample.Blob, "/"))
+			}
+			groupFields["more_examples"] = strings.Join(more, ", ")
+		}
+		log.Error(fmt.Sprintf("%d x %s", g.count, first.Message), groupFields)
+	}
+
+	for stage, count := range overflow {
+		log.Error(fmt.Sprintf("... and %d error(s) with further distinct message(s)", count), logger.Fields{"stage": stage})
+	}
+}
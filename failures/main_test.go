@@ -0,0 +1,55 @@
+// Namespace: failures/main_test.go
+
+package failures
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"../logger" // Leveled Logger
+)
+
+// Forget the failure(s) of an earlier test
+func reset(t *testing.T) {
+	t.Helper()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	total, stageCounts, groups, order, stageGroups, overflow = 0, map[string]int{}, map[[2]string]*group{}, nil, map[string]int{}, map[string]int{}
+}
+
+func TestRecordKeepsCappedSamples(t *testing.T) {
+	reset(t)
+
+	for idx := 0; idx < 100; idx++ {
+		Record("download", "media", fmt.Sprintf("%d.mp4", idx), fmt.Errorf("connection reset"))
+	}
+	for idx := 0; idx < groupsPerStage+50; idx++ {
+		Record("upload", "media", "x.mp4", fmt.Errorf("object %d rejected", idx)) // Message per blob
+	}
+	Record("download", "media", "y.mp4", nil)
+
+	if Count() != 100+groupsPerStage+50 {
+		t.Errorf("Count = %d", Count())
+	}
+	if stageCounts["download"] != 100 || stageCounts["upload"] != groupsPerStage+50 {
+		t.Errorf("stage counts = %v", stageCounts)
+	}
+	if g := groups[[2]string{"download", "connection reset"}]; g == nil || g.count != 100 || len(g.samples) != samplesPerGroup {
+		t.Errorf("download group = %+v", g)
+	}
+	if len(groups) != groupsPerStage+1 || overflow["upload"] != 50 {
+		t.Errorf("groups = %d, overflow = %v", len(groups), overflow)
+	}
+
+	var out bytes.Buffer
+	LogSummary(logger.New(&out, logger.DebugLevel, "text"), 2)
+	for _, want := range []string{fmt.Sprintf("Run finished with %d error(s)", Count()), "100 x connection reset",
+		"more_examples=\"media/1.mp4, media/2.mp4\"", "more error message(s)", "50 error(s) with further distinct message(s)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,489 @@
+// Namespace: fakestorage/azure.go
+
+package fakestorage
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Snapshot time layout of the blob service (same as endpoint.FormatSnapshot)
+const azureSnapshotLayout = "2006-01-02T15:04:05.0000000Z"
+
+// Blob - Content, HTTP header(s) and user metadata of a blob (or snapshot)
+type Blob struct {
+	Content                                                        []byte
+	ContentType, CacheControl, ContentDisposition, ContentEncoding string
+	Metadata                                                       map[string]string
+}
+
+// Azure - In-process Blob service of one storage account (path-style: <URL>/<account>/<container>/<blob>),
+// with container / blob listing (prefix, delimiter, snapshots, paging), ranged get, put and fault injection
+type Azure struct {
+	*httptest.Server
+	faults
+	Account  string
+	PageSize int // Max. item(s) per list response, below the requested maxresults (0: 5000)
+
+	mutex      sync.Mutex
+	containers map[string]map[string]*azureBlob
+}
+
+// Stored blob and its snapshot(s)
+type azureBlob struct {
+	Blob
+	modified  time.Time
+	snapshots map[string]Blob // Snapshot time => content at that time
+}
+
+// NewAzure - Start a Blob service for the account (Close it when done)
+func NewAzure(account string) *Azure {
+	fake := &Azure{Account: account, containers: map[string]map[string]*azureBlob{}}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+
+	return fake
+}
+
+// ConnectionString - Shared key connection string of the account (the key is not verified)
+func (a *Azure) ConnectionString() string {
+	key := base64.StdEncoding.EncodeToString([]byte("fakestorage"))
+
+	return fmt.Sprintf("AccountName=%s;AccountKey=%s;BlobEndpoint=%s/%s", a.Account, key, a.URL, a.Account)
+}
+
+// CreateContainer - Add an empty container (no-op when it exists)
+func (a *Azure) CreateContainer(name string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, ok := a.containers[name]; !ok {
+		a.containers[name] = map[string]*azureBlob{}
+	}
+}
+
+// PutBlob - Create or overwrite a blob, creating its container when missing
+func (a *Azure) PutBlob(container string, name string, blob Blob) {
+	a.CreateContainer(container)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	existing, ok := a.containers[container][name]
+	if !ok {
+		existing = &azureBlob{snapshots: map[string]Blob{}}
+		a.containers[container][name] = existing
+	}
+	existing.Blob, existing.modified = blob, time.Now().UTC()
+}
+
+// SnapshotBlob - Snapshot the current content of a blob at the time, returns the snapshot ("" when the blob is missing)
+func (a *Azure) SnapshotBlob(container string, name string, at time.Time) string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	existing, ok := a.containers[container][name]
+	if !ok {
+		return ""
+	}
+
+	snapshot := at.UTC().Format(azureSnapshotLayout)
+	existing.snapshots[snapshot] = existing.Blob
+
+	return snapshot
+}
+
+// GetBlob - Blob, or snapshot of it, as stored
+func (a *Azure) GetBlob(container string, name string, snapshot string) (Blob, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	existing, ok := a.containers[container][name]
+	if !ok {
+		return Blob{}, false
+	}
+	if len(snapshot) == 0 {
+		return existing.Blob, true
+	}
+
+	blob, ok := existing.snapshots[snapshot]
+	return blob, ok
+}
+
+// Route a request of the account: service, container or blob
+func (a *Azure) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	fault := a.match(r)
+	if fault != nil && fault.Kind != ResetBody {
+		a.writeFault(w, fault.Kind, r)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/"+a.Account)
+	if path == r.URL.Path {
+		writeAzureError(w, http.StatusBadRequest, "InvalidUri", "Unknown storage account")
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	query := r.URL.Query()
+	switch {
+	case len(parts[0]) == 0 && query.Get("comp") == "list":
+		a.listContainers(w, r)
+	case len(parts) == 1 && r.Method == http.MethodPut && query.Get("restype") == "container":
+		a.CreateContainer(parts[0])
+		w.WriteHeader(http.StatusCreated)
+	case len(parts) == 1 && query.Get("comp") == "list":
+		a.listBlobs(w, r, parts[0])
+	case len(parts) == 2 && r.Method == http.MethodPut:
+		a.putBlob(w, r, parts[0], parts[1])
+	case len(parts) == 2 && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		a.getBlob(w, r, parts[0], parts[1], fault != nil)
+	default:
+		writeAzureError(w, http.StatusBadRequest, "UnsupportedHttpVerb", "Operation not supported by the fake Blob service")
+	}
+}
+
+// Respond with an injected fault
+func (a *Azure) writeFault(w http.ResponseWriter, kind FaultKind, r *http.Request) {
+	switch kind {
+	case NotFound:
+		if strings.Count(strings.Trim(r.URL.Path, "/"), "/") < 2 {
+			writeAzureError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		} else {
+			writeAzureError(w, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+		}
+	case Unavailable:
+		writeAzureError(w, http.StatusServiceUnavailable, "ServerBusy", "The server is currently unable to receive requests.")
+	case Throttle:
+		writeAzureError(w, http.StatusServiceUnavailable, "ServerBusy", "Ingress is over the account limit.")
+	case Reset:
+		resetConnection(w)
+	}
+}
+
+// Blob service error document
+func writeAzureError(w http.ResponseWriter, status int, code string, message string) {
+	body := fmt.Sprintf(`%s<Error><Code>%s</Code><Message>%s</Message></Error>`, xml.Header, code, message)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// List Containers
+func (a *Azure) listContainers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	a.mutex.Lock()
+	var names []string
+	for name := range a.containers {
+		if strings.HasPrefix(name, query.Get("prefix")) {
+			names = append(names, name)
+		}
+	}
+	a.mutex.Unlock()
+	sort.Strings(names)
+
+	start, end, nextMarker := a.page(query, len(names))
+	result := azureContainerList{Prefix: query.Get("prefix"), Marker: query.Get("marker"), NextMarker: nextMarker}
+	for _, name := range names[start:end] {
+		result.Containers = append(result.Containers, azureContainerItem{Name: name, LastModified: time.Now().UTC().Format(http.TimeFormat)})
+	}
+
+	writeXML(w, result)
+}
+
+// List Blobs of a container (flat, or hierarchical with a delimiter), snapshot(s) before their base blob
+func (a *Azure) listBlobs(w http.ResponseWriter, r *http.Request, container string) {
+	query := r.URL.Query()
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	include := query.Get("include")
+
+	// Hierarchical listing can't include snapshot(s), as on Azure
+	if len(delimiter) != 0 && strings.Contains(include, "snapshots") {
+		writeAzureError(w, http.StatusBadRequest, "InvalidQueryParameterValue", "Value for one of the query parameters specified in the request URI is invalid.")
+		return
+	}
+
+	a.mutex.Lock()
+	blobs, ok := a.containers[container]
+	if !ok {
+		a.mutex.Unlock()
+		writeAzureError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+
+	var names []string
+	for name := range blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// Listing Item(s): virtual directory, snapshot(s) (oldest first) and base blob
+	type listItem struct {
+		prefix string
+		blob   azureBlobItem
+	}
+	var items []listItem
+	seenPrefixes := map[string]bool{}
+	for _, name := range names {
+		if rest := strings.TrimPrefix(name, prefix); len(delimiter) != 0 && strings.Contains(rest, delimiter) {
+			directory := prefix + rest[:strings.Index(rest, delimiter)+len(delimiter)]
+			if !seenPrefixes[directory] {
+				seenPrefixes[directory] = true
+				items = append(items, listItem{prefix: directory})
+			}
+			continue
+		}
+
+		stored := blobs[name]
+		if strings.Contains(include, "snapshots") {
+			var snapshots []string
+			for snapshot := range stored.snapshots {
+				snapshots = append(snapshots, snapshot)
+			}
+			sort.Strings(snapshots)
+
+			for _, snapshot := range snapshots {
+				items = append(items, listItem{blob: newAzureBlobItem(name, snapshot, stored.snapshots[snapshot], stored.modified, include)})
+			}
+		}
+		items = append(items, listItem{blob: newAzureBlobItem(name, "", stored.Blob, stored.modified, include)})
+	}
+	a.mutex.Unlock()
+
+	start, end, nextMarker := a.page(query, len(items))
+	result := azureBlobList{ContainerName: container, Prefix: prefix, Marker: query.Get("marker"), Delimiter: delimiter, NextMarker: nextMarker}
+	for _, item := range items[start:end] {
+		if len(item.prefix) != 0 {
+			result.Blobs.Prefixes = append(result.Blobs.Prefixes, azurePrefixItem{Name: item.prefix})
+		} else {
+			result.Blobs.Blobs = append(result.Blobs.Blobs, item.blob)
+		}
+	}
+
+	writeXML(w, result)
+}
+
+// Page of a listing: the marker is the offset of the first item
+//
+// @param query Values (marker, maxresults), total integer
+// @return start, end, next marker ("" on the last page)
+func (a *Azure) page(query map[string][]string, total int) (int, int, string) {
+	start, _ := strconv.Atoi(firstValue(query, "marker"))
+	if start > total {
+		start = total
+	}
+
+	size := 5000
+	if maxResults, err := strconv.Atoi(firstValue(query, "maxresults")); err == nil && maxResults > 0 && maxResults < size {
+		size = maxResults
+	}
+	if a.PageSize > 0 && a.PageSize < size {
+		size = a.PageSize
+	}
+
+	end, nextMarker := start+size, ""
+	if end < total {
+		nextMarker = strconv.Itoa(end)
+	} else {
+		end = total
+	}
+
+	return start, end, nextMarker
+}
+
+// Put Blob (block blob in a single request)
+func (a *Azure) putBlob(w http.ResponseWriter, r *http.Request, container string, name string) {
+	a.mutex.Lock()
+	_, ok := a.containers[container]
+	a.mutex.Unlock()
+	if !ok {
+		writeAzureError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return // Client Gone
+	}
+
+	blob := Blob{
+		Content:            content,
+		ContentType:        r.Header.Get("x-ms-blob-content-type"),
+		CacheControl:       r.Header.Get("x-ms-blob-cache-control"),
+		ContentDisposition: r.Header.Get("x-ms-blob-content-disposition"),
+		ContentEncoding:    r.Header.Get("x-ms-blob-content-encoding"),
+		Metadata:           map[string]string{},
+	}
+	for header := range r.Header {
+		if key := strings.ToLower(header); strings.HasPrefix(key, "x-ms-meta-") {
+			blob.Metadata[strings.TrimPrefix(key, "x-ms-meta-")] = r.Header.Get(header)
+		}
+	}
+	a.PutBlob(container, name, blob)
+
+	w.Header().Set("ETag", etag(content))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Get Blob / Get Blob Properties, of the whole blob or the x-ms-range / Range byte range
+func (a *Azure) getBlob(w http.ResponseWriter, r *http.Request, container string, name string, reset bool) {
+	a.mutex.Lock()
+	_, containerExists := a.containers[container]
+	a.mutex.Unlock()
+	if !containerExists {
+		writeAzureError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+
+	blob, ok := a.GetBlob(container, name, r.URL.Query().Get("snapshot"))
+	if !ok {
+		writeAzureError(w, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+		return
+	}
+
+	blobETag := etag(blob.Content)
+	if ifMatch := r.Header.Get("If-Match"); len(ifMatch) != 0 && ifMatch != blobETag {
+		writeAzureError(w, http.StatusPreconditionFailed, "ConditionNotMet", "The condition specified using HTTP conditional header(s) is not met.")
+		return
+	}
+
+	header := w.Header()
+	header.Set("ETag", blobETag)
+	header.Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	header.Set("x-ms-blob-type", "BlockBlob")
+	setHeader(header, "Content-Type", blob.ContentType)
+	setHeader(header, "Cache-Control", blob.CacheControl)
+	setHeader(header, "Content-Disposition", blob.ContentDisposition)
+	setHeader(header, "Content-Encoding", blob.ContentEncoding)
+	for key, value := range blob.Metadata {
+		header.Set("x-ms-meta-"+key, value)
+	}
+
+	rangeHeader := r.Header.Get("x-ms-range")
+	if len(rangeHeader) == 0 {
+		rangeHeader = r.Header.Get("Range")
+	}
+
+	writeContent(w, r, rangeHeader, blob.Content, reset)
+}
+
+// Set a response header which has a value
+func setHeader(header http.Header, key string, value string) {
+	if len(value) != 0 {
+		header.Set(key, value)
+	}
+}
+
+// First value of a query parameter
+func firstValue(query map[string][]string, key string) string {
+	if values := query[key]; len(values) != 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// List Containers response
+type azureContainerList struct {
+	XMLName    xml.Name             `xml:"EnumerationResults"`
+	Prefix     string               `xml:"Prefix"`
+	Marker     string               `xml:"Marker"`
+	Containers []azureContainerItem `xml:"Containers>Container"`
+	NextMarker string               `xml:"NextMarker"`
+}
+
+type azureContainerItem struct {
+	Name         string `xml:"Name"`
+	LastModified string `xml:"Properties>Last-Modified"`
+}
+
+// List Blobs response
+type azureBlobList struct {
+	XMLName       xml.Name `xml:"EnumerationResults"`
+	ContainerName string   `xml:"ContainerName,attr"`
+	Prefix        string   `xml:"Prefix"`
+	Marker        string   `xml:"Marker"`
+	Delimiter     string   `xml:"Delimiter"`
+	Blobs         struct {
+		Prefixes []azurePrefixItem `xml:"BlobPrefix"`
+		Blobs    []azureBlobItem   `xml:"Blob"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+type azurePrefixItem struct {
+	Name string `xml:"Name"`
+}
+
+type azureBlobItem struct {
+	Name       string `xml:"Name"`
+	Snapshot   string `xml:"Snapshot,omitempty"`
+	Properties struct {
+		LastModified       string `xml:"Last-Modified"`
+		Etag               string `xml:"Etag"`
+		ContentLength      int    `xml:"Content-Length"`
+		ContentType        string `xml:"Content-Type,omitempty"`
+		ContentEncoding    string `xml:"Content-Encoding,omitempty"`
+		ContentDisposition string `xml:"Content-Disposition,omitempty"`
+		CacheControl       string `xml:"Cache-Control,omitempty"`
+		BlobType           string `xml:"BlobType"`
+	} `xml:"Properties"`
+	Metadata *azureMetadata `xml:"Metadata,omitempty"`
+}
+
+// Listed blob, with its metadata when requested (include=metadata)
+func newAzureBlobItem(name string, snapshot string, blob Blob, modified time.Time, include string) azureBlobItem {
+	item := azureBlobItem{Name: name, Snapshot: snapshot}
+	item.Properties.LastModified = modified.Format(http.TimeFormat)
+	item.Properties.Etag = etag(blob.Content)
+	item.Properties.ContentLength = len(blob.Content)
+	item.Properties.ContentType = blob.ContentType
+	item.Properties.ContentEncoding = blob.ContentEncoding
+	item.Properties.ContentDisposition = blob.ContentDisposition
+	item.Properties.CacheControl = blob.CacheControl
+	item.Properties.BlobType = "BlockBlob"
+
+	if strings.Contains(include, "metadata") {
+		metadata := azureMetadata(blob.Metadata)
+		item.Metadata = &metadata
+	}
+
+	return item
+}
+
+// User metadata as <Metadata><key>value</key>...</Metadata>
+type azureMetadata map[string]string
+
+// MarshalXML - One element per key, sorted
+func (m azureMetadata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := e.EncodeElement(m[key], xml.StartElement{Name: xml.Name{Local: key}}); err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
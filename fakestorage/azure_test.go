@@ -0,0 +1,155 @@
+// Namespace: fakestorage/azure_test.go
+
+package fakestorage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob" // Azure Blob Package
+)
+
+// Container URL of the fake account, with the SDK's default pipeline (retries included)
+func containerURL(t *testing.T, fake *Azure, container string) azblob.ContainerURL {
+	t.Helper()
+
+	endpoint, err := url.Parse(fake.URL + "/" + fake.Account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential := azblob.NewSharedKeyCredential(fake.Account, "ZmFrZXN0b3JhZ2U=")
+
+	return azblob.NewServiceURL(*endpoint, azblob.NewPipeline(credential, azblob.PipelineOptions{})).NewContainerURL(container)
+}
+
+func TestAzureListBlobs(t *testing.T) {
+	fake := NewAzure("account")
+	defer fake.Close()
+	fake.PageSize = 2
+
+	fake.PutBlob("media", "a.mp4", Blob{Content: []byte("a"), Metadata: map[string]string{"owner": "ops"}})
+	fake.PutBlob("media", "dir/b.mp4", Blob{Content: []byte("b")})
+	fake.PutBlob("media", "c.mp4", Blob{Content: []byte("c")})
+	snapshot := fake.SnapshotBlob("media", "a.mp4", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	ctx := context.Background()
+	var names, prefixes []string
+	var pages int
+	for marker := (azblob.Marker{}); marker.NotDone(); pages++ {
+		list, err := containerURL(t, fake, "media").ListBlobs(ctx, marker, azblob.ListBlobsOptions{Delimiter: "/",
+			Details: azblob.BlobListingDetails{Metadata: true}})
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+
+		for _, blob := range list.Blobs.Blob {
+			name := blob.Name
+			if !blob.Snapshot.IsZero() {
+				name += "@" + blob.Snapshot.UTC().Format(azureSnapshotLayout)
+			}
+			if blob.Name == "a.mp4" && blob.Metadata["owner"] != "ops" {
+				t.Errorf("metadata of %s = %v", name, blob.Metadata)
+			}
+			names = append(names, name)
+		}
+		for _, prefix := range list.Blobs.BlobPrefix {
+			prefixes = append(prefixes, prefix.Name)
+		}
+		marker = list.NextMarker
+	}
+
+	want := []string{"a.mp4", "c.mp4"}
+	if pages != 2 || len(names) != len(want) || len(prefixes) != 1 || prefixes[0] != "dir/" {
+		t.Fatalf("pages = %d, blobs = %v, prefixes = %v", pages, names, prefixes)
+	}
+	for idx := range want {
+		if names[idx] != want[idx] {
+			t.Errorf("blob %d = %q, want %q", idx, names[idx], want[idx])
+		}
+	}
+
+	// Snapshot(s) are only listed flat: with a delimiter, Azure rejects the listing
+	_, err := containerURL(t, fake, "media").ListBlobs(ctx, azblob.Marker{}, azblob.ListBlobsOptions{Delimiter: "/",
+		Details: azblob.BlobListingDetails{Snapshots: true}})
+	if serr, ok := err.(azblob.StorageError); !ok || serr.Response().StatusCode != 400 {
+		t.Errorf("hierarchical listing with snapshots: error = %v, want 400", err)
+	}
+
+	list, err := containerURL(t, fake, "media").ListBlobs(ctx, azblob.Marker{}, azblob.ListBlobsOptions{Details: azblob.BlobListingDetails{Snapshots: true}})
+	if err != nil {
+		t.Fatalf("flat list: %v", err)
+	}
+	names = nil
+	for _, blob := range list.Blobs.Blob {
+		name := blob.Name
+		if !blob.Snapshot.IsZero() {
+			name += "@" + blob.Snapshot.UTC().Format(azureSnapshotLayout)
+		}
+		names = append(names, name)
+	}
+	if want := []string{"a.mp4@" + snapshot, "a.mp4"}; len(names) != 2 || names[0] != want[0] || names[1] != want[1] {
+		t.Errorf("flat blobs = %v, want %v on the first page", names, want)
+	}
+}
+
+func TestAzureRangedGet(t *testing.T) {
+	fake := NewAzure("account")
+	defer fake.Close()
+	fake.PutBlob("media", "a.mp4", Blob{Content: []byte("0123456789"), ContentType: "video/mp4"})
+
+	blobURL := containerURL(t, fake, "media").NewBlobURL("a.mp4")
+	get, err := blobURL.GetBlob(context.Background(), azblob.BlobRange{Offset: 2, Count: 5}, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer get.Body().Close()
+
+	content, _ := ioutil.ReadAll(get.Body())
+	if !bytes.Equal(content, []byte("23456")) || get.ContentType() != "video/mp4" || get.ContentRange() != "bytes 2-6/10" {
+		t.Errorf("content = %q, type = %q, range = %q", content, get.ContentType(), get.ContentRange())
+	}
+}
+
+func TestAzureFaults(t *testing.T) {
+	fake := NewAzure("account")
+	defer fake.Close()
+	fake.PutBlob("media", "a.mp4", Blob{Content: []byte("a")})
+	ctx := context.Background()
+
+	// Missing container: ContainerNotFound, not retried
+	_, err := containerURL(t, fake, "missing").ListBlobs(ctx, azblob.Marker{}, azblob.ListBlobsOptions{})
+	if serr, ok := err.(azblob.StorageError); !ok || serr.ServiceCode() != azblob.ServiceCodeContainerNotFound {
+		t.Errorf("missing container error = %v", err)
+	}
+
+	// Injected 404 on a blob
+	fake.Inject(Fault{Method: "HEAD", Path: "/account/media/a.mp4", Kind: NotFound, Times: 1})
+	_, err = containerURL(t, fake, "media").NewBlobURL("a.mp4").GetPropertiesAndMetadata(ctx, azblob.BlobAccessConditions{})
+	if serr, ok := err.(azblob.StorageError); !ok || serr.Response().StatusCode != 404 {
+		t.Errorf("injected 404 error = %v", err)
+	}
+
+	// A single 503 is retried by the pipeline
+	fake.Inject(Fault{Method: "GET", Path: "/account/media", Query: "comp", Kind: Unavailable, Times: 1})
+	if _, err = containerURL(t, fake, "media").ListBlobs(ctx, azblob.Marker{}, azblob.ListBlobsOptions{}); err != nil {
+		t.Errorf("503 once: %v", err)
+	}
+	if hits := fake.Hits("GET", "/account/media"); hits != 2 {
+		t.Errorf("list request(s) = %d, want 2 (503 + retry)", hits)
+	}
+
+	// Connection reset (every attempt: net/http retries a GET once on a reused connection)
+	fake.Inject(Fault{Method: "GET", Path: "/account/media", Kind: Reset})
+	if _, err = containerURL(t, fake, "media").ListBlobs(ctx, azblob.Marker{}, azblob.ListBlobsOptions{}); err == nil {
+		t.Error("connection reset: no error")
+	}
+
+	fake.ClearFaults()
+	if _, err = containerURL(t, fake, "media").ListBlobs(ctx, azblob.Marker{}, azblob.ListBlobsOptions{}); err != nil {
+		t.Errorf("after ClearFaults: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Namespace: fakestorage/db.go
+
+package fakestorage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"../database" // DB Handler Package
+	"../helpers"  // Helper Package
+	"../logger"   // Leveled Logger
+)
+
+// OpenDB - Fresh SQLite state store (sync / containers tables) in a temporary directory, closed with the test
+func OpenDB(t testing.TB) {
+	t.Helper()
+
+	database.SetLogger(Logger(t))
+
+	path := filepath.Join(t.TempDir(), "storage.sqlite")
+	file, err := os.Create(path) // Opened read-write, the DB file must exist
+	if err != nil {
+		t.Fatalf("create DB file: %v", err)
+	}
+	file.Close()
+
+	if _, err := database.Open("sqlite", path, 5*time.Second); err != nil {
+		t.Fatalf("open DB: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if !database.BuildTable() {
+		t.Fatal("build DB tables failed")
+	}
+}
+
+// AddDownloaded - Downloaded sync row (azure_status 1, with its properties) and its file in the media folder,
+// named as the download names it (nil content: no local copy)
+func AddDownloaded(t testing.TB, mediaFolder string, snapshotSuffix string, row database.SyncRow, content []byte) {
+	t.Helper()
+
+	for _, result := range database.InsertBlobs([]database.SyncRow{row}) {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+	}
+	if err := database.SetBlobProperties(row.Container, row.Blob, row.Snapshot, row.Properties); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SetAzureFlag(row.Container, row.Blob, row.Snapshot, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if content == nil {
+		return
+	}
+
+	path := filepath.Join(mediaFolder, helpers.LocalFileName(row.Container, row.Blob, row.Snapshot, snapshotSuffix))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// RowsWithStatus - Sync row(s) with the status (pending, completed, ...) of the stage (azure / s3)
+func RowsWithStatus(t testing.TB, status string, stage string) []database.SyncItem {
+	t.Helper()
+
+	items, _, err := database.GetSyncItems(status, stage, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return items
+}
+
+// Logger - Debug logger writing to the test log (shown on failure or with -v)
+func Logger(t testing.TB) *logger.Logger {
+	return logger.New(testWriter{t}, logger.DebugLevel, "text")
+}
+
+// Log line(s) as test log entries
+type testWriter struct {
+	t testing.TB
+}
+
+// Write - One test log entry per write (one log line)
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,132 @@
+// Namespace: fakestorage/fault.go
+
+package fakestorage
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FaultKind - Failure injected into a matching request
+type FaultKind int
+
+// Fault Kind(s)
+const (
+	NotFound    FaultKind = iota // 404 (Azure: ContainerNotFound / BlobNotFound, S3: NoSuchBucket / NoSuchKey)
+	Unavailable                  // 503 (Azure: ServerBusy, S3: ServiceUnavailable)
+	Throttle                     // Azure: 503 ServerBusy (ingress / egress limit), S3: 503 SlowDown
+	Reset                        // Connection closed before any response
+	ResetBody                    // Response header(s) and half of the body, then connection closed (GET only)
+)
+
+// Fault - Error injection rule of a fake server
+type Fault struct {
+	Method string    // Empty: any method
+	Path   string    // Request path prefix, e.g. "/account/container/blob" (empty: any path)
+	Query  string    // Query parameter the request must carry, e.g. "comp", "uploads" (empty: any)
+	Kind   FaultKind // Injected failure
+	Times  int       // Matching request(s) which fail, 0: every one
+}
+
+// Injected fault(s) of a server, consumed in order
+type faults struct {
+	mutex sync.Mutex
+	rules []*Fault
+	hits  map[string]int // Request count per "METHOD path"
+}
+
+// Inject - Fail matching request(s) with the fault
+func (f *faults) Inject(fault Fault) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.rules = append(f.rules, &fault)
+}
+
+// ClearFaults - Remove every injected fault
+func (f *faults) ClearFaults() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.rules = nil
+}
+
+// Hits - Number of request(s) received with the method (empty: any) and path prefix
+func (f *faults) Hits(method string, path string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	count := 0
+	for request, hits := range f.hits {
+		parts := strings.SplitN(request, " ", 2)
+		if (len(method) == 0 || parts[0] == method) && strings.HasPrefix(parts[1], path) {
+			count += hits
+		}
+	}
+
+	return count
+}
+
+// Count the request and return the fault it triggers
+//
+// @param r Request
+// @return Fault pointer (nil: serve the request)
+func (f *faults) match(r *http.Request) *Fault {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.hits == nil {
+		f.hits = map[string]int{}
+	}
+	f.hits[r.Method+" "+r.URL.Path]++
+
+	for idx, rule := range f.rules {
+		if len(rule.Method) != 0 && rule.Method != r.Method {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, rule.Path) {
+			continue
+		}
+		if _, ok := r.URL.Query()[rule.Query]; len(rule.Query) != 0 && !ok {
+			continue
+		}
+
+		matched := *rule
+		if rule.Times != 0 {
+			if rule.Times--; rule.Times == 0 {
+				f.rules = append(f.rules[:idx], f.rules[idx+1:]...) // Used Up
+			}
+		}
+
+		return &matched
+	}
+
+	return nil
+}
+
+// Close the client connection without (the rest of) a response
+//
+// @param w ResponseWriter
+// @return nil
+func resetConnection(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		panic("fakestorage: response writer can't be hijacked")
+	}
+
+	conn, _, err := hijacker.Hijack() // Flushes header(s) / body written so far
+	if err == nil {
+		conn.Close()
+	}
+}
+
+// Write status, header(s) and half of the body, then close the connection (ResetBody)
+//
+// @param w ResponseWriter, status integer, body slice
+// @return nil
+func resetBody(w http.ResponseWriter, status int, body []byte) {
+	w.WriteHeader(status)
+	w.Write(body[:len(body)/2])
+	resetConnection(w)
+}
@@ -0,0 +1,109 @@
+// Namespace: fakestorage/http.go
+
+package fakestorage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Write a 200 XML document
+//
+// @param w ResponseWriter, body interface (xml.Marshal)
+// @return nil
+func writeXML(w http.ResponseWriter, body interface{}) {
+	content, err := xml.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(content)))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(content)
+}
+
+// Serve content of an object, or the byte range of the header value ("bytes=0-99", "bytes=100-", "bytes=-100")
+//
+// @param w ResponseWriter, r Request, rangeHeader string, content slice, reset boolean (ResetBody fault)
+// @return nil
+func writeContent(w http.ResponseWriter, r *http.Request, rangeHeader string, content []byte, reset bool) {
+	status := http.StatusOK
+	if len(rangeHeader) != 0 {
+		start, end, ok := parseRange(rangeHeader, len(content))
+		if !ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(content)))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		content, status = content[start:end+1], http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	switch {
+	case r.Method == http.MethodHead:
+		w.WriteHeader(status)
+	case reset:
+		resetBody(w, status, content)
+	default:
+		w.WriteHeader(status)
+		w.Write(content)
+	}
+}
+
+// Parse a single byte range of an object of size byte(s)
+//
+// @param header string, size integer
+// @return first byte, last byte (inclusive), satisfiable boolean
+func parseRange(header string, size int) (int, int, bool) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	bounds := strings.SplitN(spec, "-", 2)
+	if len(bounds) != 2 || spec == header {
+		return 0, 0, false
+	}
+
+	if len(bounds[0]) == 0 { // Suffix: last N byte(s)
+		suffix, err := strconv.Atoi(bounds[1])
+		if err != nil || suffix <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size - 1, true
+	}
+
+	start, err := strconv.Atoi(bounds[0])
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if len(bounds[1]) != 0 {
+		if end, err = strconv.Atoi(bounds[1]); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end, true
+}
+
+// Quoted MD5 of the content (ETag)
+func etag(content []byte) string {
+	sum := md5.Sum(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -0,0 +1,496 @@
+// Namespace: fakestorage/s3.go
+
+package fakestorage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Object - Content, HTTP header(s), canned ACL and user metadata of an object (version)
+type Object struct {
+	Content                                                        []byte
+	ContentType, CacheControl, ContentDisposition, ContentEncoding string
+	ACL                                                            string
+	Metadata                                                       map[string]string
+	ETag                                                           string
+	VersionID                                                      string // Empty: bucket versioning disabled
+}
+
+// S3 - In-process S3 service (path-style: <URL>/<bucket>/<key>), with ListObjectsV2, ranged get,
+// put, multipart upload, bucket versioning and fault injection
+type S3 struct {
+	*httptest.Server
+	faults
+	PageSize int // Max. key(s) per list response, below the requested max-keys (0: 1000)
+
+	mutex   sync.Mutex
+	buckets map[string]*s3Bucket
+	uploads map[string]*s3Upload
+	nextID  int
+}
+
+// Stored bucket: version(s) of each key, latest last
+type s3Bucket struct {
+	versioning bool
+	objects    map[string][]Object
+}
+
+// Multipart upload in progress
+type s3Upload struct {
+	bucket, key string
+	object      Object // Header(s) of CreateMultipartUpload
+	parts       map[int][]byte
+}
+
+// NewS3 - Start an S3 service (Close it when done)
+func NewS3() *S3 {
+	fake := &S3{buckets: map[string]*s3Bucket{}, uploads: map[string]*s3Upload{}}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+
+	return fake
+}
+
+// CreateBucket - Add an empty bucket, with versioning enabled or not (no-op when it exists)
+func (s *S3) CreateBucket(name string, versioning bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.buckets[name]; !ok {
+		s.buckets[name] = &s3Bucket{versioning: versioning, objects: map[string][]Object{}}
+	}
+}
+
+// PutObject - Store an object, as a new version when versioning is enabled (the bucket must exist)
+func (s *S3) PutObject(bucket string, key string, object Object) (Object, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, ok := s.buckets[bucket]
+	if !ok {
+		return Object{}, false
+	}
+
+	if len(object.ETag) == 0 {
+		object.ETag = etag(object.Content)
+	}
+
+	if stored.versioning {
+		s.nextID++
+		object.VersionID = fmt.Sprintf("v%d", s.nextID)
+		stored.objects[key] = append(stored.objects[key], object)
+	} else {
+		object.VersionID = ""
+		stored.objects[key] = []Object{object}
+	}
+
+	return object, true
+}
+
+// GetObject - Latest version of an object
+func (s *S3) GetObject(bucket string, key string) (Object, bool) {
+	versions := s.Versions(bucket, key)
+	if len(versions) == 0 {
+		return Object{}, false
+	}
+
+	return versions[len(versions)-1], true
+}
+
+// Versions - Every version of an object, oldest first
+func (s *S3) Versions(bucket string, key string) []Object {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, ok := s.buckets[bucket]
+	if !ok {
+		return nil
+	}
+
+	return append([]Object(nil), stored.objects[key]...)
+}
+
+// Keys - Object key(s) of a bucket, sorted
+func (s *S3) Keys(bucket string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var keys []string
+	if stored, ok := s.buckets[bucket]; ok {
+		for key := range stored.objects {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// PendingUploads - Multipart upload(s) neither completed nor aborted
+func (s *S3) PendingUploads() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.uploads)
+}
+
+// Route a request: bucket or object operation
+func (s *S3) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+
+	fault := s.match(r)
+	if fault != nil && fault.Kind != ResetBody {
+		s.writeFault(w, fault.Kind, bucket, key)
+		return
+	}
+
+	s.mutex.Lock()
+	_, bucketExists := s.buckets[bucket]
+	s.mutex.Unlock()
+	if !bucketExists {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist", bucket)
+		return
+	}
+
+	query := r.URL.Query()
+	_, uploads := query["uploads"]
+	_, versioning := query["versioning"]
+	switch {
+	case len(key) == 0 && versioning && r.Method == http.MethodGet:
+		s.getVersioning(w, bucket)
+	case len(key) == 0 && r.Method == http.MethodGet:
+		s.listObjects(w, r, bucket)
+	case len(key) != 0 && uploads && r.Method == http.MethodPost:
+		s.createUpload(w, r, bucket, key)
+	case len(key) != 0 && len(query.Get("uploadId")) != 0 && r.Method == http.MethodPut:
+		s.uploadPart(w, r)
+	case len(key) != 0 && len(query.Get("uploadId")) != 0 && r.Method == http.MethodPost:
+		s.completeUpload(w, r)
+	case len(key) != 0 && len(query.Get("uploadId")) != 0 && r.Method == http.MethodDelete:
+		s.abortUpload(w, r)
+	case len(key) != 0 && r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	case len(key) != 0 && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		s.getObject(w, r, bucket, key, fault != nil)
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "Operation not supported by the fake S3 service", bucket)
+	}
+}
+
+// Respond with an injected fault
+func (s *S3) writeFault(w http.ResponseWriter, kind FaultKind, bucket string, key string) {
+	switch kind {
+	case NotFound:
+		if len(key) == 0 {
+			writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist", bucket)
+		} else {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.", bucket)
+		}
+	case Unavailable:
+		writeS3Error(w, http.StatusServiceUnavailable, "ServiceUnavailable", "Please reduce your request rate.", bucket)
+	case Throttle:
+		writeS3Error(w, http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.", bucket)
+	case Reset:
+		resetConnection(w)
+	}
+}
+
+// S3 error document
+func writeS3Error(w http.ResponseWriter, status int, code string, message string, bucket string) {
+	body := fmt.Sprintf(`%s<Error><Code>%s</Code><Message>%s</Message><BucketName>%s</BucketName><RequestId>fakestorage</RequestId></Error>`,
+		xml.Header, code, message, bucket)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// GetBucketVersioning
+func (s *S3) getVersioning(w http.ResponseWriter, bucket string) {
+	s.mutex.Lock()
+	enabled := s.buckets[bucket].versioning
+	s.mutex.Unlock()
+
+	result := s3Versioning{}
+	if enabled {
+		result.Status = "Enabled"
+	}
+
+	writeXML(w, result)
+}
+
+// ListObjectsV2: latest version of each key, the continuation token is the offset of the first key
+func (s *S3) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+
+	var keys []string
+	for _, key := range s.Keys(bucket) {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	if start > len(keys) {
+		start = len(keys)
+	}
+
+	size := 1000
+	if maxKeys, err := strconv.Atoi(query.Get("max-keys")); err == nil && maxKeys > 0 && maxKeys < size {
+		size = maxKeys
+	}
+	if s.PageSize > 0 && s.PageSize < size {
+		size = s.PageSize
+	}
+
+	end := start + size
+	result := s3ObjectList{Name: bucket, Prefix: prefix, MaxKeys: size, ContinuationToken: query.Get("continuation-token")}
+	if end < len(keys) {
+		result.IsTruncated, result.NextContinuationToken = true, strconv.Itoa(end)
+	} else {
+		end = len(keys)
+	}
+
+	for _, key := range keys[start:end] {
+		object, _ := s.GetObject(bucket, key)
+		result.Contents = append(result.Contents, s3ObjectItem{
+			Key:          key,
+			LastModified: time.Now().UTC().Format(time.RFC3339),
+			ETag:         object.ETag,
+			Size:         len(object.Content),
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents)
+
+	writeXML(w, result)
+}
+
+// PutObject
+func (s *S3) putObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return // Client Gone
+	}
+
+	object := objectFromHeaders(r.Header)
+	object.Content = content
+	stored, _ := s.PutObject(bucket, key, object)
+
+	w.Header().Set("ETag", stored.ETag)
+	setHeader(w.Header(), "x-amz-version-id", stored.VersionID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// CreateMultipartUpload
+func (s *S3) createUpload(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	s.mutex.Lock()
+	s.nextID++
+	uploadID := fmt.Sprintf("upload-%d", s.nextID)
+	s.uploads[uploadID] = &s3Upload{bucket: bucket, key: key, object: objectFromHeaders(r.Header), parts: map[int][]byte{}}
+	s.mutex.Unlock()
+
+	writeXML(w, s3InitiateUpload{Bucket: bucket, Key: key, UploadID: uploadID})
+}
+
+// UploadPart
+func (s *S3) uploadPart(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000", "")
+		return
+	}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return // Client Gone
+	}
+
+	s.mutex.Lock()
+	upload, ok := s.uploads[query.Get("uploadId")]
+	if ok {
+		upload.parts[partNumber] = content
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.", "")
+		return
+	}
+
+	w.Header().Set("ETag", etag(content))
+	w.WriteHeader(http.StatusOK)
+}
+
+// CompleteMultipartUpload: part(s) joined in the order of the request, ETag "<md5 of part md5s>-<parts>"
+func (s *S3) completeUpload(w http.ResponseWriter, r *http.Request) {
+	var request s3CompleteUpload
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.", "")
+		return
+	}
+
+	uploadID := r.URL.Query().Get("uploadId")
+	s.mutex.Lock()
+	upload, ok := s.uploads[uploadID]
+	if ok {
+		delete(s.uploads, uploadID)
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.", "")
+		return
+	}
+
+	object, sums := upload.object, md5.New()
+	for _, part := range request.Parts {
+		content, exists := upload.parts[part.PartNumber]
+		if !exists || etag(content) != part.ETag {
+			writeS3Error(w, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found.", upload.bucket)
+			return
+		}
+
+		sum := md5.Sum(content)
+		sums.Write(sum[:])
+		object.Content = append(object.Content, content...)
+	}
+	object.ETag = fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sums.Sum(nil)), len(request.Parts))
+
+	stored, _ := s.PutObject(upload.bucket, upload.key, object)
+	setHeader(w.Header(), "x-amz-version-id", stored.VersionID)
+	writeXML(w, s3CompletedUpload{
+		Location: fmt.Sprintf("%s/%s/%s", s.URL, upload.bucket, upload.key),
+		Bucket:   upload.bucket,
+		Key:      upload.key,
+		ETag:     stored.ETag,
+	})
+}
+
+// AbortMultipartUpload
+func (s *S3) abortUpload(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	delete(s.uploads, r.URL.Query().Get("uploadId"))
+	s.mutex.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetObject / HeadObject, of the whole object or the Range byte range
+func (s *S3) getObject(w http.ResponseWriter, r *http.Request, bucket string, key string, reset bool) {
+	object, ok := s.GetObject(bucket, key)
+	if versionID := r.URL.Query().Get("versionId"); len(versionID) != 0 {
+		ok = false
+		for _, version := range s.Versions(bucket, key) {
+			if version.VersionID == versionID {
+				object, ok = version, true
+			}
+		}
+	}
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.", bucket)
+		return
+	}
+
+	header := w.Header()
+	header.Set("ETag", object.ETag)
+	header.Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	setHeader(header, "x-amz-version-id", object.VersionID)
+	setHeader(header, "Content-Type", object.ContentType)
+	setHeader(header, "Cache-Control", object.CacheControl)
+	setHeader(header, "Content-Disposition", object.ContentDisposition)
+	setHeader(header, "Content-Encoding", object.ContentEncoding)
+	for name, value := range object.Metadata {
+		header.Set("x-amz-meta-"+name, value)
+	}
+
+	writeContent(w, r, r.Header.Get("Range"), object.Content, reset)
+}
+
+// Object header(s), canned ACL and x-amz-meta-* metadata (lower-cased names) of a put
+func objectFromHeaders(header http.Header) Object {
+	object := Object{
+		ContentType:        header.Get("Content-Type"),
+		CacheControl:       header.Get("Cache-Control"),
+		ContentDisposition: header.Get("Content-Disposition"),
+		ContentEncoding:    header.Get("Content-Encoding"),
+		ACL:                header.Get("x-amz-acl"),
+		Metadata:           map[string]string{},
+	}
+	for name := range header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-meta-") {
+			object.Metadata[strings.TrimPrefix(lower, "x-amz-meta-")] = header.Get(name)
+		}
+	}
+
+	return object
+}
+
+// GetBucketVersioning response
+type s3Versioning struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ VersioningConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+// ListObjectsV2 response
+type s3ObjectList struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []s3ObjectItem `xml:"Contents"`
+}
+
+type s3ObjectItem struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// CreateMultipartUpload response
+type s3InitiateUpload struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+// CompleteMultipartUpload request
+type s3CompleteUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+// CompleteMultipartUpload response
+type s3CompletedUpload struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
@@ -0,0 +1,129 @@
+// Namespace: fakestorage/s3_test.go
+
+package fakestorage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"                  // AWS Core SDK
+	"github.com/aws/aws-sdk-go/aws/credentials"      // Static Credential(s)
+	"github.com/aws/aws-sdk-go/aws/session"          // Maintains AWS Session
+	"github.com/aws/aws-sdk-go/service/s3"           // AWS S3 API
+	"github.com/aws/aws-sdk-go/service/s3/s3manager" // AWS S3 Manager (Upload/Upload Data)
+)
+
+// Path-style session of the fake service, with static credential(s)
+func s3Session(t *testing.T, fake *S3) *session.Session {
+	t.Helper()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(fake.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sess
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+	fake := NewS3()
+	defer fake.Close()
+	fake.CreateBucket("bucket", false)
+
+	content := bytes.Repeat([]byte("0123456789"), 1100*1024) // 11MB: three 5MB part(s)
+	uploader := s3manager.NewUploader(s3Session(t, fake), func(u *s3manager.Uploader) { u.PartSize = 5 * 1024 * 1024 })
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String("bucket"),
+		Key:         aws.String("dir/a.mp4"),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("video/mp4"),
+		ACL:         aws.String("public-read"),
+		Metadata:    aws.StringMap(map[string]string{"owner": "ops"}),
+	})
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	object, ok := fake.GetObject("bucket", "dir/a.mp4")
+	if !ok || !bytes.Equal(object.Content, content) {
+		t.Fatalf("stored object missing or corrupt (%d byte(s))", len(object.Content))
+	}
+	if object.ContentType != "video/mp4" || object.ACL != "public-read" || object.Metadata["owner"] != "ops" {
+		t.Errorf("object header(s) = %q, %q, %v", object.ContentType, object.ACL, object.Metadata)
+	}
+	if object.ETag[len(object.ETag)-3:] != `-3"` || fake.PendingUploads() != 0 {
+		t.Errorf("etag = %s, pending uploads = %d", object.ETag, fake.PendingUploads())
+	}
+}
+
+func TestS3RangedGetAndList(t *testing.T) {
+	fake := NewS3()
+	defer fake.Close()
+	fake.PageSize = 1
+	fake.CreateBucket("bucket", false)
+	fake.PutObject("bucket", "a.mp4", Object{Content: []byte("0123456789")})
+	fake.PutObject("bucket", "b.mp4", Object{Content: []byte("b")})
+
+	client := s3.New(s3Session(t, fake))
+	get, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("a.mp4"), Range: aws.String("bytes=-3")})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	content, _ := ioutil.ReadAll(get.Body)
+	get.Body.Close()
+	if string(content) != "789" || aws.StringValue(get.ContentRange) != "bytes 7-9/10" {
+		t.Errorf("content = %q, range = %q", content, aws.StringValue(get.ContentRange))
+	}
+
+	var keys []string
+	err = client.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String("bucket")}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	if err != nil || len(keys) != 2 || keys[0] != "a.mp4" || keys[1] != "b.mp4" {
+		t.Errorf("keys = %v, error = %v", keys, err)
+	}
+}
+
+func TestS3VersioningAndFaults(t *testing.T) {
+	fake := NewS3()
+	defer fake.Close()
+	fake.CreateBucket("bucket", true)
+	client := s3.New(s3Session(t, fake))
+
+	versioning, err := client.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String("bucket")})
+	if err != nil || aws.StringValue(versioning.Status) != s3.BucketVersioningStatusEnabled {
+		t.Errorf("versioning = %v, error = %v", versioning, err)
+	}
+
+	// Throttled once: retried by the SDK
+	fake.Inject(Fault{Method: "PUT", Path: "/bucket/a.mp4", Kind: Throttle, Times: 1})
+	for _, body := range []string{"v1", "v2"} {
+		put, putErr := client.PutObject(&s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("a.mp4"), Body: bytes.NewReader([]byte(body))})
+		if putErr != nil || len(aws.StringValue(put.VersionId)) == 0 {
+			t.Fatalf("put %s: %v (version %q)", body, putErr, aws.StringValue(put.VersionId))
+		}
+	}
+	if versions := fake.Versions("bucket", "a.mp4"); len(versions) != 2 || string(versions[0].Content) != "v1" {
+		t.Errorf("versions = %d", len(versions))
+	}
+
+	// Missing bucket / key
+	_, err = client.GetObject(&s3.GetObjectInput{Bucket: aws.String("missing"), Key: aws.String("a.mp4")})
+	if aerr, ok := err.(interface{ Code() string }); !ok || aerr.Code() != s3.ErrCodeNoSuchBucket {
+		t.Errorf("missing bucket error = %v", err)
+	}
+	fake.Inject(Fault{Method: "GET", Path: "/bucket/a.mp4", Kind: NotFound})
+	_, err = client.GetObject(&s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("a.mp4")})
+	if aerr, ok := err.(interface{ Code() string }); !ok || aerr.Code() != s3.ErrCodeNoSuchKey {
+		t.Errorf("injected 404 error = %v", err)
+	}
+}
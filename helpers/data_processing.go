@@ -3,20 +3,45 @@
 package helpers
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
+	"mime"
+	"path/filepath"
 	"strings"
 )
 
 const (
 	columnCapacity = 10
 	tenGap         = 10
+	genericType    = "application/octet-stream"
 )
 
+// DefaultSnapshotSuffix - Suffix of a blob snapshot's downloaded file, and of its S3 key unless S3_SNAPSHOT_SUFFIX is set
+const DefaultSnapshotSuffix = ".snapshot-{snapshot}"
+
+// Content type(s) which are missing from Go's built-in MIME table
+var mediaContentTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".vtt":  "text/vtt",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".csv":  "text/csv",
+	".doc":  "application/msword",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xls":  "application/vnd.ms-excel",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".zip":  "application/zip",
+}
+
 // CreateContainerMatrix - Create matrix table for Container
 func CreateContainerMatrix(container []string) [][]string {
-	fmt.Println("Arranging Matrix")
-
 	// Setting Range Variable(s)
 	var maxRange, minRange int
 
@@ -58,3 +83,75 @@ func ProcessBlobName(containerName string, blobName string) string {
 
 	return blobName
 }
+
+// SnapshotName - Name of a blob snapshot: blob name with the suffix, in which "{snapshot}" is the snapshot time
+// (the base blob, without snapshot, keeps its name)
+func SnapshotName(blobName string, snapshot string, suffix string) string {
+	if len(snapshot) == 0 {
+		return blobName
+	}
+
+	return blobName + strings.Replace(suffix, "{snapshot}", snapshot, -1)
+}
+
+// LocalFileName - File of a row in the media folder: processed blob name with the snapshot suffix (S3_SNAPSHOT_SUFFIX),
+// shared by every stage which writes, reads or deletes local copies
+func LocalFileName(containerName string, blobName string, snapshot string, suffix string) string {
+	return SnapshotName(ProcessBlobName(containerName, blobName), snapshot, suffix)
+}
+
+// FormatCount - Format number with thousands separator (3214 => "3,214")
+func FormatCount(count int64) string {
+	if count < 0 {
+		return "-" + FormatCount(-count)
+	}
+
+	digits := fmt.Sprintf("%d", count)
+	for idx := len(digits) - 3; idx > 0; idx -= 3 {
+		digits = digits[:idx] + "," + digits[idx:]
+	}
+
+	return digits
+}
+
+// ResolveContentType - Content type for an object, inferring it from the file extension (optional)
+func ResolveContentType(contentType string, fileName string, inferFromExtension bool) string {
+	if !inferFromExtension || (len(contentType) != 0 && contentType != genericType) {
+		return contentType
+	}
+
+	fileExt := strings.ToLower(filepath.Ext(fileName))
+	if mediaType, ok := mediaContentTypes[fileExt]; ok {
+		return mediaType
+	}
+
+	if mediaType := mime.TypeByExtension(fileExt); len(mediaType) != 0 {
+		return mediaType
+	}
+
+	return contentType // Unknown Extension
+}
+
+// EncodeMetadata - Serialize blob metadata for the sync table
+func EncodeMetadata(metadata map[string]string) string {
+	if len(metadata) == 0 {
+		return ""
+	}
+
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return ""
+	}
+
+	return string(encoded)
+}
+
+// DecodeMetadata - Deserialize blob metadata from the sync table
+func DecodeMetadata(encoded string) map[string]string {
+	metadata := map[string]string{}
+	if len(encoded) != 0 {
+		json.Unmarshal([]byte(encoded), &metadata)
+	}
+
+	return metadata
+}
@@ -3,103 +3,674 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"./api"
 	"./database"
+	"./diskspace"
 	"./download/azure"
+	"./endpoint"
+	"./failures"
+	"./helpers"
+	"./logger"
+	"./metrics"
+	"./progress"
+	"./reconcile"
+	"./report"
+	"./retention"
+	"./snowball"
 	"./sync"
+	"./throttle"
 	"./upload/s3"
 
+	"code.cloudfoundry.org/bytefmt"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
 	"github.com/joho/godotenv"
 )
 
 // EnvVars Struct
 type EnvVars struct {
-	accountName, accountKey                 string // Azure Specific Setting
-	awsKey, awsSecret, awsBucket, awsRegion string // AWS Specific Setting
-	dbName                                  string // DB Specific Setting
-	mediaFolder                             string // Content Specific Setting
+	azureAccount        endpoint.Azure // Azure Specific Setting
+	s3Bucket            endpoint.S3    // AWS Specific Setting
+	dbName              string         // DB Specific Setting
+	mediaFolder         string         // Content Specific Setting
+	contentTypeFallback bool           // Content Specific Setting
 }
 
+// Exit Code(s)
+const (
+	exitSuccess     = 0   // Every item processed
+	exitFailed      = 1   // Command could not run / aborted
+	exitPartial     = 2   // Command ran, but some container(s) / blob(s) failed
+	exitInterrupted = 130 // Ctrl+C / SIGTERM, resumed by the next run
+)
+
 // Global Variable
 var status bool
 
 // Main Function
 func main() {
-	fmt.Println("[START] Sync Cloud Storage")
-
 	// Loading .env variables
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file")
 	}
 
+	// Configuring Logger (.env: LOG_LEVEL, LOG_FORMAT, LOG_FILE, LOG_MAX_SIZE, LOG_MAX_BACKUPS)
+	appLog := buildLogger()
+	database.SetLogger(appLog)
+
+	appLog.Info("[START] Sync Cloud Storage")
+
 	// Processing .env Configuration File.
-	accountName, accountKey := os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_ACCESS_KEY")
-	awsKey, awsSecret, awsBucket, awsRegion := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_BUCKET"), os.Getenv("AWS_DEFAULT_REGION")
-	dbName, mediaFolder := os.Getenv("DB_FILE"), os.Getenv("MEDIA_FOLDER")
-	if len(accountName) == 0 || len(accountKey) == 0 {
-		log.Fatal("Azure Credentials are missing from environment variable (.env)")
+	azureAccount, azureErr := endpoint.AzureFromEnv()
+	s3Bucket, s3Err := endpoint.S3FromEnv()
+	dbDriver, dbName, mediaFolder := os.Getenv("DB_DRIVER"), os.Getenv("DB_FILE"), os.Getenv("MEDIA_FOLDER")
+	contentTypeFallback := os.Getenv("CONTENT_TYPE_FALLBACK") == "true"
+	snapshotMode, snapshotSuffix := os.Getenv("S3_SNAPSHOT_MODE"), os.Getenv("S3_SNAPSHOT_SUFFIX")
+	retentionPolicy, retentionErr := retention.ParsePolicy(os.Getenv("RETENTION_POLICY"))
+	if azureErr != nil {
+		appLog.Fatal("Azure Credentials are missing from environment variable (.env)", logger.Fields{"error": azureErr})
+	}
+
+	if s3Err != nil {
+		appLog.Fatal("AWS Credentials are missing from environment variable (.env)", logger.Fields{"error": s3Err})
+	}
+
+	// Blob Snapshot(s) in S3: own key with a suffix (default) or object version(s) of the base key
+	if len(snapshotMode) == 0 {
+		snapshotMode = s3.SnapshotSuffix
+	}
+	if snapshotMode != s3.SnapshotSuffix && snapshotMode != s3.SnapshotVersions {
+		appLog.Fatal("Invalid S3_SNAPSHOT_MODE (suffix, versions)", logger.Fields{"mode": snapshotMode})
+	}
+	if len(snapshotSuffix) == 0 {
+		snapshotSuffix = helpers.DefaultSnapshotSuffix
+	}
+	if !strings.Contains(snapshotSuffix, "{snapshot}") {
+		appLog.Fatal("S3_SNAPSHOT_SUFFIX must contain {snapshot}, so that snapshots of a blob get distinct keys", logger.Fields{"suffix": snapshotSuffix})
+	}
+
+	// Local Copy of Uploaded File(s): all (default), immediate, live or days such as 7d
+	if retentionErr != nil {
+		appLog.Fatal("Invalid RETENTION_POLICY", logger.Fields{"error": retentionErr})
 	}
 
-	if len(awsKey) == 0 || len(awsSecret) == 0 || len(awsBucket) == 0 || len(awsRegion) == 0 {
-		log.Fatal("AWS Credentials are missing from environment variable (.env)")
+	// State Store: SQLite file (DB_FILE, default) or PostgreSQL server (DB_URL)
+	if len(dbDriver) == 0 {
+		dbDriver = "sqlite"
+	}
+	if dbDriver == "postgres" {
+		dbName = os.Getenv("DB_URL")
 	}
 
 	if len(dbName) == 0 {
-		log.Fatal("DB Setting is missing from environment variable (.env)")
+		appLog.Fatal("DB Setting is missing from environment variable (.env)", logger.Fields{"driver": dbDriver})
 	}
 
 	if len(mediaFolder) == 0 {
-		log.Fatal("Media Storage is missing from environment variable (.env)")
+		appLog.Fatal("Media Storage is missing from environment variable (.env)")
 	}
 
 	// Checking if DB File Exists
-	if _, err := os.Stat(dbName); os.IsNotExist(err) {
-		log.Fatal("DB File is Missing!")
+	if _, err := os.Stat(dbName); dbDriver == "sqlite" && os.IsNotExist(err) {
+		appLog.Fatal("DB File is Missing!")
+	}
+
+	// Opening DB Connection Pool shared by every package (.env: DB_BUSY_TIMEOUT in ms, default 5000)
+	busyTimeout := 5000
+	if value, err := strconv.Atoi(os.Getenv("DB_BUSY_TIMEOUT")); err == nil {
+		busyTimeout = value
 	}
 
+	_, dbErr := database.Open(dbDriver, dbName, time.Duration(busyTimeout)*time.Millisecond)
+	if dbErr != nil {
+		appLog.Fatal("Unable to open DB", logger.Fields{"driver": dbDriver, "error": dbErr})
+	}
+
+	// Leasing Claimed Row(s) (.env: WORKER_ID, default hostname-pid; LEASE_DURATION, default 5m)
+	leaseDuration, leaseErr := time.ParseDuration(os.Getenv("LEASE_DURATION"))
+	if leaseErr != nil && len(os.Getenv("LEASE_DURATION")) != 0 {
+		appLog.Fatal("Invalid LEASE_DURATION", logger.Fields{"error": leaseErr})
+	}
+	database.SetLease(os.Getenv("WORKER_ID"), leaseDuration)
+
 	// Initializing Global Flag
 	syncFlag := flag.Bool("sync", false, "a bool")         // Init: Sync Flag!
 	cleanFlag := flag.Bool("clean", false, "a bool")       // Init: Clean Flag!
 	uploadFlag := flag.Bool("upload", false, "a bool")     // Init: Upload Flag!
 	downloadFlag := flag.Bool("download", false, "a bool") // Init: Download Flag!
+	serveFlag := flag.Bool("serve", false, "a bool")       // Init: Daemon (API only) Flag!
+	reportFlag := flag.Bool("report", false, "a bool")     // Init: Report Flag!
+	exportFlag := flag.Bool("export", false, "a bool")     // Init: Manifest Export Flag!
+	importFlag := flag.Bool("import", false, "a bool")     // Init: Manifest Import Flag!
+	pruneFlag := flag.Bool("prune", false, "a bool")       // Init: Prune Media Folder Flag!
+	snowballFlag := flag.Bool("snowball", false, "a bool") // Init: Snowball Manifest Flag!
 
 	// Initializing Sync Flag
 	containerFlag := flag.Bool("container", false, "a bool") // Init: Container Flag!
 	blobFlag := flag.Bool("blob", false, "a bool")           // Init: Container:Blob Flag!
+	containerNameFlag := flag.String("container-name", "", "sync -blob: only traverse this container (snowball: only export it)")
+	prefixFlag := flag.String("prefix", "", "sync -blob: only record blobs under this prefix, e.g. 2019/ (reconcile: only list these keys)")
+	delimiterFlag := flag.String("delimiter", "", "sync -blob: list virtual directories hierarchically, e.g. /")
+	snapshotsFlag := flag.Bool("snapshots", os.Getenv("SYNC_SNAPSHOTS") == "true", "sync -blob: record blob snapshots as rows linked to their base blob")
 
 	// Initializing Reset Flag
 	resetLiveContainerFlag := flag.Bool("reset-live", false, "a bool") // Init: Reset Live Container Flag!
 
+	// Initializing Report Flag(s)
+	formatFlag := flag.String("format", "table", "report format: table, csv, json or html (manifest: csv or jsonl)")
+	outputFlag := flag.String("output", "", "write report/manifest to this file instead of stdout")
+	periodFlag := flag.String("period", "day", "report throughput per day or hour")
+	topErrorsFlag := flag.Int("top-errors", 20, "number of error patterns in report")
+
+	// Initializing Manifest Flag(s)
+	tableFlag := flag.String("table", "sync", "manifest table: containers or sync")
+	inputFlag := flag.String("input", "", "manifest file to import (csv or jsonl)")
+
+	// Initializing Snowball Flag(s)
+	snowballReconcileFlag := flag.Bool("snowball-reconcile", false, "a bool") // Init: Snowball Reconcile Flag!
+	jobFlag := flag.String("job", "", "snowball: job ID whose device carries the exported files")
+	capacityFlag := flag.String("capacity", "", "snowball: max. bytes exported to the device, e.g. 72TB")
+
+	// Initializing Reconcile Flag(s)
+	reconcileFlag := flag.String("reconcile", "", "match objects in S3 with rows pending upload: s3")
+	inventoryFlag := flag.String("inventory", "", "reconcile: S3 Inventory manifest.json file(s), comma separated, instead of listing the bucket")
+
+	// Initializing Dry Run Flag
+	dryRunFlag := flag.Bool("dry-run", false, "a bool") // Init: Dry Run Flag!
+
+	// Initializing Bandwidth Flag(s)
+	maxRateFlag := flag.String("max-rate", "", "bandwidth limit shared by downloads and uploads, e.g. 100MB/s")
+	maxDownloadRateFlag := flag.String("max-download-rate", "", "bandwidth limit for Azure downloads, e.g. 50MB/s")
+	maxUploadRateFlag := flag.String("max-upload-rate", "", "bandwidth limit for S3 uploads, e.g. 20MB/s")
+
+	// Initializing Metrics Flag
+	metricsAddrFlag := flag.String("metrics-addr", "", "serve Prometheus /metrics on this address, e.g. :9100")
+
+	// Initializing Status API Flag
+	apiAddrFlag := flag.String("api-addr", "", "serve JSON status API (/status, /containers, /items) on this address, e.g. :8080")
+
+	// Initializing Progress Flag(s)
+	progressFlag := flag.String("progress", progress.ModeAuto, "download/upload progress: auto (bars on a terminal, plain lines otherwise), bars, plain or off")
+	progressIntervalFlag := flag.Duration("progress-interval", 10*time.Second, "period of plain progress lines")
+
 	flag.Parse() // Parsing the command line flag data
 
+	// Exposing Prometheus Metrics (optional)
+	if len(*metricsAddrFlag) != 0 {
+		metrics.Serve(*metricsAddrFlag, appLog)
+	}
+
+	// Exposing Status API (optional)
+	if len(*apiAddrFlag) != 0 {
+		api.Serve(*apiAddrFlag, appLog)
+	}
+
+	// Building Bandwidth Limiter(s): flag sets the default rate, .env schedule overrides it by time of day
+	globalLimiter := buildLimiter(appLog, *maxRateFlag, "RATE_SCHEDULE", nil)
+	downloadLimiter := buildLimiter(appLog, *maxDownloadRateFlag, "DOWNLOAD_RATE_SCHEDULE", globalLimiter)
+	uploadLimiter := buildLimiter(appLog, *maxUploadRateFlag, "UPLOAD_RATE_SCHEDULE", globalLimiter)
+
+	// Root Context: cancelled on Ctrl+C / SIGTERM, so workers can stop cleanly
+	ctx := interruptContext(appLog)
+
+	// Reporting DB Mutation(s) instead of executing them
+	database.SetDryRun(*dryRunFlag)
+	if *dryRunFlag {
+		appLog.Info("[Dry Run] Nothing will be written to DB, media folder or S3.")
+	}
+
 	// Check Flag
 	if *syncFlag {
-		env := sync.EnvVars{AccountName: accountName, AccountKey: accountKey, ContainerFlag: *containerFlag, BlobFlag: *blobFlag}
+		api.SetStage("sync")
+		scopes, scopeErr := sync.LoadScopes(os.Getenv("SYNC_SCOPE_FILE"))
+		if scopeErr != nil {
+			appLog.Fatal("Unable to load sync scope", logger.Fields{"error": scopeErr})
+		}
+
+		env := sync.EnvVars{Azure: azureAccount, ContainerFlag: *containerFlag, BlobFlag: *blobFlag, ContainerName: *containerNameFlag,
+			Prefix: *prefixFlag, Delimiter: *delimiterFlag, Scopes: scopes, Snapshots: *snapshotsFlag, DryRun: *dryRunFlag, Log: appLog}
 		if database.BuildTable() {
-			status = sync.Run(env)
+			status = sync.Run(ctx, env)
 		} else {
-			fmt.Println("Sync Table Creation Failed!")
+			appLog.Error("Sync Table Creation Failed!")
 		}
 	} else if *cleanFlag {
-		if !database.CleanUp() {
-			fmt.Println("CleanUp Failed!")
+		api.SetStage("clean")
+		if status = database.CleanUp(); !status {
+			appLog.Error("CleanUp Failed!")
 		}
 	} else if *uploadFlag {
-		env := s3.EnvVars{AWSKey: awsKey, AWSSecret: awsSecret, AWSBucket: awsBucket, AWSRegion: awsRegion, MediaFolder: mediaFolder}
-		status = s3.Run(env)
+		api.SetStage("upload")
+		env := s3.EnvVars{S3: s3Bucket, MediaFolder: mediaFolder, ContentTypeFallback: contentTypeFallback, SnapshotMode: snapshotMode,
+			SnapshotSuffix: snapshotSuffix, DryRun: *dryRunFlag, Limiter: uploadLimiter, Retention: retentionPolicy, Log: appLog}
+		if !database.BuildTable() { // Migrating lease column(s) of an older DB first
+			appLog.Error("Sync Table Migration Failed!")
+		} else {
+			display := startProgress(appLog, metrics.StageUpload, *progressFlag, *progressIntervalFlag, *dryRunFlag)
+			status = s3.Run(ctx, env)
+			display.Stop()
+		}
 	} else if *downloadFlag {
-		env := azure.EnvVars{AccountName: accountName, AccountKey: accountKey, MediaFolder: mediaFolder}
-		status = azure.Run(env)
+		api.SetStage("download")
+		env := azure.EnvVars{Azure: azureAccount, MediaFolder: mediaFolder, SnapshotSuffix: snapshotSuffix, DryRun: *dryRunFlag, Limiter: downloadLimiter,
+			Space: buildSpaceGuard(appLog, mediaFolder), Log: appLog}
+		if !database.BuildTable() { // Migrating lease column(s) of an older DB first
+			appLog.Error("Sync Table Migration Failed!")
+		} else {
+			display := startProgress(appLog, metrics.StageDownload, *progressFlag, *progressIntervalFlag, *dryRunFlag)
+			status = azure.Run(ctx, env)
+			display.Stop()
+		}
 	} else if *resetLiveContainerFlag {
-		database.ResetLiveContainer()
+		api.SetStage("reset-live")
+		if !database.BuildTable() { // Creating the container registry of an older DB first
+			appLog.Error("Sync Table Creation Failed!")
+		} else if err := database.ResetLiveContainer(); err != nil {
+			appLog.Error("Reset Failed!", logger.Fields{"error": err})
+		} else {
+			status = true
+		}
+	} else if *reportFlag {
+		api.SetStage("report")
+		if status = database.BuildTable() && writeReport(appLog, *formatFlag, *outputFlag, *periodFlag, *topErrorsFlag); !status {
+			appLog.Error("Report Failed!")
+		}
+	} else if *exportFlag {
+		api.SetStage("export")
+		if status = database.BuildTable() && exportManifest(appLog, *tableFlag, *formatFlag, *outputFlag); !status {
+			appLog.Error("Export Failed!")
+		}
+	} else if *importFlag {
+		api.SetStage("import")
+		if status = database.BuildTable() && importManifest(appLog, *tableFlag, *formatFlag, *inputFlag); !status {
+			appLog.Error("Import Failed!")
+		}
+	} else if *pruneFlag {
+		api.SetStage("prune")
+		if status = database.BuildTable(); status {
+			if _, err := retention.Prune(ctx, retentionPolicy, mediaFolder, snapshotSuffix, *dryRunFlag, appLog); err != nil && ctx.Err() == nil {
+				appLog.Error("Prune Failed!", logger.Fields{"error": err})
+				status = false
+			}
+		}
+	} else if *snowballFlag || *snowballReconcileFlag {
+		api.SetStage("snowball")
+		opts := snowball.Options{Job: *jobFlag, Container: *containerNameFlag, Capacity: parseCapacity(appLog, *capacityFlag),
+			Format: manifestFormat(*formatFlag, *outputFlag), DryRun: *dryRunFlag, Log: appLog,
+			Upload: s3.EnvVars{S3: s3Bucket, MediaFolder: mediaFolder, SnapshotMode: snapshotMode, SnapshotSuffix: snapshotSuffix}}
+		if status = database.BuildTable(); status && *snowballFlag {
+			status = exportSnowball(ctx, appLog, opts, *outputFlag)
+		} else if status {
+			if _, err := snowball.Reconcile(ctx, opts); err != nil && ctx.Err() == nil {
+				appLog.Error("Snowball Reconcile Failed!", logger.Fields{"job": opts.Job, "error": err})
+				status = false
+			}
+		}
+	} else if len(*reconcileFlag) != 0 {
+		api.SetStage("reconcile")
+		if *reconcileFlag != "s3" {
+			appLog.Fatal("Invalid -reconcile (s3)", logger.Fields{"reconcile": *reconcileFlag})
+		}
+
+		opts := reconcile.Options{Upload: s3.EnvVars{S3: s3Bucket, MediaFolder: mediaFolder, SnapshotMode: snapshotMode, SnapshotSuffix: snapshotSuffix}, Log: appLog}
+		if status = database.BuildTable(); status {
+			status = reconcileS3(ctx, appLog, opts, *inventoryFlag, *prefixFlag)
+		}
+	} else if flag.Arg(0) == "containers" {
+		api.SetStage("containers")
+		if status = database.BuildTable() && manageContainers(appLog, flag.Args()[1:]); !status {
+			appLog.Error("Container Registry Update Failed!")
+		}
+	} else if *serveFlag {
+		if len(*apiAddrFlag) == 0 && len(*metricsAddrFlag) == 0 {
+			appLog.Fatal("Daemon Mode needs -api-addr and/or -metrics-addr")
+		}
+
+		// Serving until Ctrl+C / SIGTERM
+		api.SetStage("serve")
+		<-ctx.Done()
+		status = true
 	} else {
-		fmt.Println("Invalid Flag")
+		appLog.Error("Invalid Flag")
+	}
+
+	// Summarizing Per-Item Error(s)
+	failures.LogSummary(appLog, 10)
+
+	exitCode := exitSuccess
+	if ctx.Err() != nil && !*serveFlag {
+		appLog.Warn("Interrupted, run the same command again to resume")
+		exitCode = exitInterrupted
+	} else if !status {
+		exitCode = exitFailed
+	} else if failures.Count() != 0 {
+		exitCode = exitPartial
+	}
+
+	if err := database.Close(); err != nil {
+		appLog.Error("Closing DB Failed", logger.Fields{"error": err})
+	}
+
+	appLog.Info("[END] Sync Cloud Storage", logger.Fields{"exit_code": exitCode})
+	os.Exit(exitCode)
+}
+
+// Build context which is cancelled on the first Ctrl+C / SIGTERM (the second one exits immediately)
+func interruptContext(appLog *logger.Logger) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		appLog.Warn("Interrupt received, stopping worker(s)... (press Ctrl+C again to force exit)")
+		cancel()
+
+		<-signals
+		appLog.Error("Forced Exit")
+		os.Exit(1)
+	}()
+
+	return ctx
+}
+
+// Build bandwidth limiter from rate flag and schedule (.env)
+func buildLimiter(appLog *logger.Logger, rateFlag string, scheduleVar string, parent *throttle.Limiter) *throttle.Limiter {
+	rate, rateErr := throttle.ParseRate(rateFlag)
+	if rateErr != nil {
+		appLog.Fatal("Invalid Bandwidth Limit", logger.Fields{"error": rateErr})
+	}
+
+	schedule, scheduleErr := throttle.ParseSchedule(os.Getenv(scheduleVar))
+	if scheduleErr != nil {
+		appLog.Fatal("Invalid Bandwidth Schedule", logger.Fields{"setting": scheduleVar, "error": scheduleErr})
+	}
+
+	if rate == 0 && len(schedule) == 0 {
+		return parent // No limit of its own
+	}
+
+	return throttle.NewLimiter(rate, schedule, parent)
+}
+
+// Build free space guard of the media folder from .env setting(s): MEDIA_HIGH_WATER_MARK (default 95%),
+// DISK_POLL_INTERVAL (default 30s) or nil when the mark is "off"
+func buildSpaceGuard(appLog *logger.Logger, mediaFolder string) *diskspace.Guard {
+	markSetting := os.Getenv("MEDIA_HIGH_WATER_MARK")
+	if len(markSetting) == 0 {
+		markSetting = "95%"
+	} else if markSetting == "off" {
+		return nil
+	}
+
+	mark, markErr := diskspace.ParseMark(markSetting)
+	if markErr != nil {
+		appLog.Fatal("Invalid MEDIA_HIGH_WATER_MARK", logger.Fields{"error": markErr})
+	}
+
+	poll := 30 * time.Second
+	if value := os.Getenv("DISK_POLL_INTERVAL"); len(value) != 0 {
+		var pollErr error
+		if poll, pollErr = time.ParseDuration(value); pollErr != nil || poll <= 0 {
+			appLog.Fatal("Invalid DISK_POLL_INTERVAL", logger.Fields{"interval": value})
+		}
+	}
+
+	return diskspace.NewGuard(mediaFolder, mark, poll, appLog)
+}
+
+// Build logger from .env setting(s)
+func buildLogger() *logger.Logger {
+	level, levelErr := logger.ParseLevel(os.Getenv("LOG_LEVEL"))
+	if levelErr != nil {
+		log.Fatal(levelErr)
+	}
+
+	format := os.Getenv("LOG_FORMAT")
+	if len(format) == 0 {
+		format = "text"
+	} else if format != "text" && format != "json" {
+		log.Fatal("LOG_FORMAT must be text or json")
+	}
+
+	logFile := os.Getenv("LOG_FILE")
+	if len(logFile) == 0 {
+		return logger.New(os.Stderr, level, format) // Keeps stdout clean for report output
+	}
+
+	// Rotating Log File (default: 100 MB x 5 backups)
+	maxSize, maxBackups := 100, 5
+	if value, err := strconv.Atoi(os.Getenv("LOG_MAX_SIZE")); err == nil {
+		maxSize = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("LOG_MAX_BACKUPS")); err == nil {
+		maxBackups = value
+	}
+
+	rotatingFile, fileErr := logger.OpenRotatingFile(logFile, int64(maxSize)*1024*1024, maxBackups)
+	if fileErr != nil {
+		log.Fatal("Unable to open LOG_FILE: ", fileErr)
+	}
+
+	return logger.New(rotatingFile, level, format)
+}
+
+// Show download / upload progress on stdout (nothing for a dry run, which transfers nothing)
+func startProgress(appLog *logger.Logger, stage string, mode string, interval time.Duration, dryRun bool) *progress.Display {
+	switch mode {
+	case progress.ModeAuto, progress.ModeBars, progress.ModePlain, progress.ModeOff:
+	default:
+		appLog.Fatal("Invalid -progress (auto, bars, plain, off)", logger.Fields{"progress": mode})
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	return progress.Start(progress.Options{Stage: stage, Mode: mode, Interval: interval, Out: os.Stdout, Log: appLog})
+}
+
+// Build migration report and write it to stdout or file
+func writeReport(appLog *logger.Logger, format string, output string, period string, topErrors int) bool {
+	migrationReport, err := report.Build(period, topErrors)
+	if err != nil {
+		appLog.Error("Report Query Failed", logger.Fields{"error": err})
+		return false
+	}
+
+	out := os.Stdout
+	if len(output) != 0 {
+		out, err = os.Create(output)
+		if err != nil {
+			appLog.Error("Unable to create report file", logger.Fields{"file": output, "error": err})
+			return false
+		}
+		defer out.Close()
+	}
+
+	if err := report.Write(out, migrationReport, format); err != nil {
+		appLog.Error("Report Rendering Failed", logger.Fields{"format": format, "error": err})
+		return false
+	}
+
+	if len(output) != 0 {
+		appLog.Info("Report Written", logger.Fields{"file": output, "format": format})
+	}
+
+	return true
+}
+
+// Manifest format from -format flag, or from file extension (default: csv)
+func manifestFormat(format string, fileName string) string {
+	if format != "table" { // Set explicitly (invalid one(s) are rejected by database package)
+		return format
+	}
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	}
+
+	return "csv"
+}
+
+// Export containers/sync table to stdout or file
+func exportManifest(appLog *logger.Logger, table string, format string, output string) bool {
+	format = manifestFormat(format, output)
+
+	out := os.Stdout
+	if len(output) != 0 {
+		var err error
+		out, err = os.Create(output)
+		if err != nil {
+			appLog.Error("Unable to create manifest file", logger.Fields{"file": output, "error": err})
+			return false
+		}
+		defer out.Close()
+	}
+
+	count, err := database.ExportTable(out, table, format)
+	if err != nil {
+		appLog.Error("Manifest Export Failed", logger.Fields{"table": table, "format": format, "error": err})
+		return false
+	}
+
+	appLog.Info("Manifest Exported", logger.Fields{"table": table, "format": format, "rows": count, "file": output})
+	return true
+}
+
+// Snowball device capacity from -capacity flag (0: unlimited)
+func parseCapacity(appLog *logger.Logger, capacity string) int64 {
+	if len(capacity) == 0 {
+		return 0
+	}
+
+	bytes, err := bytefmt.ToBytes(capacity)
+	if err != nil {
+		appLog.Fatal("Invalid -capacity", logger.Fields{"capacity": capacity, "error": err})
+	}
+
+	return int64(bytes)
+}
+
+// Export Snowball manifest to stdout or file and mark its rows as shipped
+func exportSnowball(ctx context.Context, appLog *logger.Logger, opts snowball.Options, output string) bool {
+	out := os.Stdout
+	if len(output) != 0 && !opts.DryRun {
+		var err error
+		out, err = os.Create(output)
+		if err != nil {
+			appLog.Error("Unable to create manifest file", logger.Fields{"file": output, "error": err})
+			return false
+		}
+		defer out.Close()
+	}
+
+	if _, err := snowball.Export(ctx, out, opts); err != nil && ctx.Err() == nil {
+		appLog.Error("Snowball Export Failed!", logger.Fields{"job": opts.Job, "error": err})
+		return false
+	}
+
+	return true
+}
+
+// Reconcile rows pending upload with the bucket listing (under prefix) or S3 Inventory report(s)
+func reconcileS3(ctx context.Context, appLog *logger.Logger, opts reconcile.Options, inventory string, prefix string) bool {
+	var source reconcile.Source
+	if len(inventory) != 0 {
+		source = reconcile.Inventory(strings.Split(inventory, ","), opts.Upload.S3.Bucket)
+	} else {
+		sess, err := opts.Upload.S3.Session(metrics.Transport)
+		if err != nil {
+			appLog.Error("Session Error", logger.Fields{"error": err})
+			return false
+		}
+		source = reconcile.BucketListing(awss3.New(sess), opts.Upload.S3.Bucket, prefix)
+	}
+
+	if _, err := reconcile.Run(ctx, source, opts); err != nil && ctx.Err() == nil {
+		appLog.Error("Reconcile Failed!", logger.Fields{"error": err})
+		return false
+	}
+
+	return true
+}
+
+// Add / remove / list container(s) of the live or exclude list: containers live|exclude add|remove|list [name...]
+func manageContainers(appLog *logger.Logger, args []string) bool {
+	if len(args) < 2 || (args[0] != database.RegistryLive && args[0] != database.RegistryExclude) {
+		appLog.Error("Usage: containers live|exclude add|remove|list [name...]")
+		return false
+	}
+
+	list, action, names := args[0], args[1], args[2:]
+	if action == "list" {
+		containers, err := database.RegistryList(list)
+		if err != nil {
+			appLog.Error("Unable to list containers", logger.Fields{"list": list, "error": err})
+			return false
+		}
+		for _, name := range containers {
+			fmt.Println(name)
+		}
+		return true
+	} else if (action != "add" && action != "remove") || len(names) == 0 {
+		appLog.Error("Usage: containers live|exclude add|remove|list [name...]")
+		return false
+	}
+
+	for _, name := range names {
+		var changed bool
+		var err error
+		if action == "add" {
+			changed, err = database.RegistryAdd(list, name)
+		} else {
+			changed, err = database.RegistryRemove(list, name)
+		}
+		if err != nil {
+			appLog.Error("Unable to update container list", logger.Fields{"list": list, "container": name, "action": action, "error": err})
+			return false
+		}
+
+		appLog.Info(fmt.Sprintf("Container %s list: %s", list, action), logger.Fields{"container": name, "changed": changed})
+	}
+
+	return true
+}
+
+// Upsert containers/sync table from file or stdin
+func importManifest(appLog *logger.Logger, table string, format string, input string) bool {
+	format = manifestFormat(format, input)
+
+	in := os.Stdin
+	if len(input) != 0 {
+		var err error
+		in, err = os.Open(input)
+		if err != nil {
+			appLog.Error("Unable to open manifest file", logger.Fields{"file": input, "error": err})
+			return false
+		}
+		defer in.Close()
+	}
+
+	inserted, updated, err := database.ImportTable(in, table, format)
+	if err != nil {
+		appLog.Error("Manifest Import Failed", logger.Fields{"table": table, "format": format, "error": err})
+		return false
 	}
 
-	fmt.Println("[END] Sync Cloud Storage")
+	appLog.Info("Manifest Imported", logger.Fields{"table": table, "format": format, "inserted": inserted, "updated": updated})
+	return true
 }
@@ -0,0 +1,293 @@
+// Namespace: logger/main.go
+
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level - Log severity
+type Level int
+
+// Log Level(s)
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// Fields - Structured context (container, blob, bytes, duration, attempt, ...)
+type Fields map[string]interface{}
+
+// Logger - Leveled logger shared by every package
+type Logger struct {
+	out    io.Writer
+	mutex  *sync.Mutex // Shared with child logger(s)
+	level  Level
+	json   bool
+	fields Fields
+}
+
+// New - Create logger writing "text" or "json" lines to out
+//
+// @param out Writer, level Level, format string
+// @return pointer
+func New(out io.Writer, level Level, format string) *Logger {
+	return &Logger{out: out, mutex: &sync.Mutex{}, level: level, json: format == "json", fields: Fields{}}
+}
+
+// Default - Info level text logger on stderr
+func Default() *Logger {
+	return New(os.Stderr, InfoLevel, "text")
+}
+
+// ParseLevel - Parse "debug", "info", "warn" or "error"
+func ParseLevel(value string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return DebugLevel, nil
+	case "", "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+
+	return InfoLevel, fmt.Errorf("invalid log level %q", value)
+}
+
+// String - Level name
+func (level Level) String() string {
+	switch level {
+	case DebugLevel:
+		return "debug"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	}
+
+	return "info"
+}
+
+// With - Child logger which adds fields to every line
+func (l *Logger) With(fields Fields) *Logger {
+	merged := Fields{}
+	for key, value := range l.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+
+	return &Logger{out: l.out, mutex: l.mutex, level: l.level, json: l.json, fields: merged}
+}
+
+// Debug - Log progress detail
+func (l *Logger) Debug(msg string, fields ...Fields) {
+	l.write(DebugLevel, msg, fields)
+}
+
+// Info - Log progress
+func (l *Logger) Info(msg string, fields ...Fields) {
+	l.write(InfoLevel, msg, fields)
+}
+
+// Warn - Log recoverable problem
+func (l *Logger) Warn(msg string, fields ...Fields) {
+	l.write(WarnLevel, msg, fields)
+}
+
+// Error - Log failure
+func (l *Logger) Error(msg string, fields ...Fields) {
+	l.write(ErrorLevel, msg, fields)
+}
+
+// Fatal - Log failure and exit
+func (l *Logger) Fatal(msg string, fields ...Fields) {
+	l.write(ErrorLevel, msg, fields)
+	os.Exit(1)
+}
+
+// Write single log line
+func (l *Logger) write(level Level, msg string, extra []Fields) {
+	if level < l.level {
+		return
+	}
+
+	fields := l.fields
+	if len(extra) != 0 {
+		fields = l.With(mergeFields(extra)).fields
+	}
+
+	var line []byte
+	if l.json {
+		line = jsonLine(level, msg, fields)
+	} else {
+		line = textLine(level, msg, fields)
+	}
+
+	l.mutex.Lock()
+	l.out.Write(line)
+	l.mutex.Unlock()
+}
+
+// Merge optional field set(s)
+func mergeFields(extra []Fields) Fields {
+	merged := Fields{}
+	for _, fields := range extra {
+		for key, value := range fields {
+			merged[key] = value
+		}
+	}
+
+	return merged
+}
+
+// Format: {"time":"...","level":"info","msg":"...","container":"..."}
+func jsonLine(level Level, msg string, fields Fields) []byte {
+	entry := map[string]interface{}{}
+	for key, value := range fields {
+		entry[key] = fieldValue(value)
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": level.String(), "msg": msg, "log_error": err.Error()})
+	}
+
+	return append(line, '\n')
+}
+
+// Format: 2006-01-02T15:04:05Z07:00 INFO  msg container=... blob=...
+func textLine(level Level, msg string, fields Fields) []byte {
+	var line strings.Builder
+	line.WriteString(time.Now().Format(time.RFC3339))
+	line.WriteString(" ")
+	line.WriteString(fmt.Sprintf("%-5s", strings.ToUpper(level.String())))
+	line.WriteString(" ")
+	line.WriteString(msg)
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := fmt.Sprint(fieldValue(fields[key]))
+		if strings.ContainsAny(value, " \t\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		line.WriteString(" " + key + "=" + value)
+	}
+	line.WriteString("\n")
+
+	return []byte(line.String())
+}
+
+// Render error(s) and duration(s) readably
+func fieldValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case error:
+		return typed.Error()
+	case time.Duration:
+		return typed.String()
+	}
+
+	return value
+}
+
+// RotatingFile - Log file which is rotated once it reaches maxBytes (path.1 ... path.N)
+type RotatingFile struct {
+	mutex      sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// OpenRotatingFile - Open (append) log file with size based rotation
+//
+// @param path string, maxBytes integer, maxBackups integer
+// @return pointer, error
+func OpenRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	rotatingFile := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := rotatingFile.open(); err != nil {
+		return nil, err
+	}
+
+	return rotatingFile, nil
+}
+
+// Write - Append to log file, rotating beforehand if needed
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.maxBytes > 0 && f.size+int64(len(p)) > f.maxBytes && f.size > 0 {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// Close - Close log file
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.file.Close()
+}
+
+// Open log file in append mode
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file, f.size = file, fileInfo.Size()
+	return nil
+}
+
+// Shift backup(s) (path.1 => path.2, ...) and start a new log file
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+
+	if f.maxBackups <= 0 {
+		os.Remove(f.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+		for idx := f.maxBackups - 1; idx >= 1; idx-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, idx), fmt.Sprintf("%s.%d", f.path, idx+1))
+		}
+		os.Rename(f.path, f.path+".1")
+	}
+
+	return f.open()
+}
@@ -0,0 +1,51 @@
+// Namespace: logger/main_test.go
+
+package logger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	file, err := OpenRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { file.Close() }() // Reopened below
+
+	// 60 byte(s) per line, 100 byte(s) per file: one line per file, the oldest line(s) dropped past 2 backup(s)
+	for idx := 0; idx < 5; idx++ {
+		if _, err := fmt.Fprintf(file, "line %d %s\n", idx, strings.Repeat("x", 52)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]string{"app.log": "line 4", "app.log.1": "line 3", "app.log.2": "line 2"}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != len(want) {
+		t.Fatalf("log file(s) = %d, %v; want %d (2 backup(s) kept)", len(files), err, len(want))
+	}
+	for name, prefix := range want {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || !strings.HasPrefix(string(content), prefix) || len(content) > 100 {
+			t.Errorf("%s = %q, %v; want one %q line", name, content, err, prefix)
+		}
+	}
+
+	// Reopened: appended to the current file, its size counted toward the next rotation
+	file.Close()
+	if file, err = OpenRotatingFile(path, 100, 2); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(file, "line 5 %s\n", strings.Repeat("x", 52))
+	if content, _ := ioutil.ReadFile(path + ".1"); !strings.HasPrefix(string(content), "line 4") {
+		t.Errorf("app.log.1 after reopen = %q, want line 4", content)
+	}
+}
@@ -0,0 +1,186 @@
+// Namespace: metrics/main.go
+
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"../database" // DB Handler Package
+	"../logger"   // Leveled Logger
+
+	"github.com/Azure/azure-pipeline-go/pipeline"              // Azure Pipeline
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob" // Azure Blob Package
+	"github.com/prometheus/client_golang/prometheus"           // Prometheus Metrics
+	"github.com/prometheus/client_golang/prometheus/promhttp"  // Prometheus HTTP Handler
+)
+
+// Stage Label(s)
+const (
+	StageSync     = "sync"
+	StageDownload = "download"
+	StageUpload   = "upload"
+)
+
+// Global Metric(s)
+var (
+	BytesDownloaded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sync_cloud_storage_downloaded_bytes_total",
+		Help: "Bytes downloaded from Azure.",
+	})
+	BytesUploaded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sync_cloud_storage_uploaded_bytes_total",
+		Help: "Bytes uploaded to S3 (successful part / object requests).",
+	})
+	FilesCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sync_cloud_storage_files_completed_total",
+		Help: "Files completed by stage (sync: blob rows recorded).",
+	}, []string{"stage"})
+	FilesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sync_cloud_storage_files_failed_total",
+		Help: "Files failed by stage.",
+	}, []string{"stage"})
+	InFlightWorkers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sync_cloud_storage_in_flight_workers",
+		Help: "Workers currently running by stage.",
+	}, []string{"stage"})
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sync_cloud_storage_request_duration_seconds",
+		Help:    "Latency of Azure and S3 HTTP requests (time to response headers).",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"service", "method", "code"})
+)
+
+// Queue depth is read from the sync table on every scrape
+var queueDepthDesc = prometheus.NewDesc(
+	"sync_cloud_storage_queue_items",
+	"Sync table rows by stage (azure / s3) and status.",
+	[]string{"stage", "status"}, nil)
+
+// Collector for sync table queue depth
+type queueCollector struct{}
+
+// Describe - Prometheus Collector
+func (queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+}
+
+// Collect - Prometheus Collector
+func (queueCollector) Collect(ch chan<- prometheus.Metric) {
+	statusCounts, err := database.GetStatusCounts()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(queueDepthDesc, err)
+		return
+	}
+
+	for stage, counts := range statusCounts {
+		for status, count := range counts {
+			ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(count), stage, strconv.Itoa(status))
+		}
+	}
+}
+
+func init() {
+	prometheus.MustRegister(BytesDownloaded, BytesUploaded, FilesCompleted, FilesFailed, InFlightWorkers, RequestDuration, queueCollector{})
+}
+
+// Serve - Expose /metrics on addr (e.g. ":9100") in the background
+func Serve(addr string, log *logger.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		log.Info("Serving Metrics", logger.Fields{"addr": addr, "path": "/metrics"})
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Error("Metrics Server Failed", logger.Fields{"addr": addr, "error": err})
+		}
+	}()
+}
+
+// CountReader - Wrap reader so that every read is added to counter
+func CountReader(reader io.Reader, counter prometheus.Counter) io.Reader {
+	return &countingReader{reader: reader, counter: counter}
+}
+
+// Counting io.Reader
+type countingReader struct {
+	reader  io.Reader
+	counter prometheus.Counter
+}
+
+// Read - Read and count the bytes read
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.counter.Add(float64(n))
+
+	return n, err
+}
+
+// Transport - Wrap S3 HTTP transport to observe request latency and uploaded bytes
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &s3Transport{base: base}
+}
+
+// Instrumented http.RoundTripper
+type s3Transport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip - Send request and record metrics
+func (t *s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	startTime := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		RequestDuration.WithLabelValues("s3", req.Method, "error").Observe(time.Since(startTime).Seconds())
+		return resp, err
+	}
+
+	RequestDuration.WithLabelValues("s3", req.Method, strconv.Itoa(resp.StatusCode)).Observe(time.Since(startTime).Seconds())
+	if req.Method == http.MethodPut && resp.StatusCode/100 == 2 && req.ContentLength > 0 {
+		BytesUploaded.Add(float64(req.ContentLength))
+	}
+
+	return resp, err
+}
+
+// NewAzurePipeline - Same as azblob.NewPipeline, plus request latency metrics (per attempt)
+func NewAzurePipeline(credential azblob.Credential) pipeline.Pipeline {
+	options := azblob.PipelineOptions{}
+	factories := []pipeline.Factory{
+		azblob.NewTelemetryPolicyFactory(options.Telemetry),
+		azblob.NewUniqueRequestIDPolicyFactory(),
+		azblob.NewRetryPolicyFactory(options.Retry),
+		credential,
+		pipeline.MethodFactoryMarker(),
+		azblob.NewRequestLogPolicyFactory(options.RequestLog),
+		azureLatencyFactory{}, // Closest to the wire
+	}
+
+	return pipeline.NewPipeline(factories, pipeline.Options{Log: options.Log})
+}
+
+// Pipeline factory observing Azure request latency
+type azureLatencyFactory struct{}
+
+// New - pipeline.Factory
+func (azureLatencyFactory) New(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.Policy {
+	return pipeline.PolicyFunc(func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+		startTime := time.Now()
+		response, err := next.Do(ctx, request)
+
+		code := "error"
+		if response != nil && response.Response() != nil {
+			code = strconv.Itoa(response.Response().StatusCode)
+		}
+		RequestDuration.WithLabelValues("azure", request.Method, code).Observe(time.Since(startTime).Seconds())
+
+		return response, err
+	})
+}
@@ -0,0 +1,241 @@
+// Namespace: progress/main.go
+
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"../api"      // Status API (active transfer(s))
+	"../database" // DB Handler Package
+	"../logger"   // Leveled Logger
+
+	"code.cloudfoundry.org/bytefmt" // Byte Format
+)
+
+// Display Mode(s)
+const (
+	ModeAuto  = "auto"  // Bars on a terminal, plain lines otherwise
+	ModeBars  = "bars"  // One bar per active transfer and an overall bar, redrawn in place
+	ModePlain = "plain" // One summary line per interval (log files, CI, pipes)
+	ModeOff   = "off"
+)
+
+// Global Constant(s)
+const (
+	defaultInterval = 10 * time.Second // Plain lines
+	barWidth        = 25
+	nameWidth       = 40
+	maxBars         = 20 // Active transfer(s) drawn, the rest are counted
+)
+
+// Global Variable(s)
+var redrawInterval = 500 * time.Millisecond // Bars (replaced by tests)
+
+// Options - What to show, where and how often
+type Options struct {
+	Stage    string         // Transfer stage shown: metrics.StageDownload or metrics.StageUpload
+	Mode     string         // ModeAuto (default), ModeBars, ModePlain or ModeOff
+	Interval time.Duration  // Period of plain line(s) (default 10s)
+	Out      io.Writer      // Usually stdout, bars need a terminal
+	Log      *logger.Logger // Leveled Logger
+}
+
+// Display - Progress of the current queue, rendered in the background until Stop
+type Display struct {
+	options    Options
+	bars       bool
+	queueFiles int64 // Pending row(s) and byte(s) when the display started
+	queueBytes int64
+	startedAt  time.Time
+	lines      int // Line(s) drawn by the last redraw (bars)
+	stop       chan struct{}
+	stopped    sync.WaitGroup
+}
+
+// Start - Show progress of the stage's pending queue (nil for ModeOff)
+func Start(options Options) *Display {
+	if options.Mode == ModeOff {
+		return nil
+	}
+	if options.Interval <= 0 {
+		options.Interval = defaultInterval
+	}
+
+	display := &Display{options: options, startedAt: time.Now(), stop: make(chan struct{})}
+	display.bars = options.Mode == ModeBars || (options.Mode != ModePlain && isTerminal(options.Out))
+
+	var queueErr error
+	display.queueFiles, display.queueBytes, queueErr = database.GetQueueTotals(options.Stage)
+	if queueErr != nil {
+		options.Log.Warn("Unable to size the queue, progress is shown without total / ETA", logger.Fields{"stage": options.Stage, "error": queueErr})
+	}
+
+	interval := options.Interval
+	if display.bars {
+		interval = redrawInterval
+	}
+
+	display.stopped.Add(1)
+	go display.run(interval)
+
+	return display
+}
+
+// Stop - Render the final state and stop the display
+func (d *Display) Stop() {
+	if d == nil {
+		return
+	}
+
+	close(d.stop)
+	d.stopped.Wait()
+}
+
+// Render every interval until stopped
+//
+// @param interval Duration
+// @return nil
+func (d *Display) run(interval time.Duration) {
+	defer d.stopped.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.render()
+		case <-d.stop:
+			d.render()
+			return
+		}
+	}
+}
+
+// Write one frame: redraw bars in place, or append a plain line
+func (d *Display) render() {
+	totals := api.Totals(d.options.Stage)
+	active := api.ActiveTransfers(d.options.Stage)
+
+	if !d.bars {
+		fmt.Fprintf(d.options.Out, "[%s] %s, %d active\n", d.options.Stage, d.overall(totals), len(active))
+		return
+	}
+
+	var frame strings.Builder
+	if d.lines != 0 {
+		fmt.Fprintf(&frame, "\x1b[%dF\x1b[J", d.lines) // Back to the first line of the last frame, erase it
+	}
+
+	lines := 0
+	for idx, transfer := range active {
+		if idx == maxBars {
+			fmt.Fprintf(&frame, "  ... %d more\n", len(active)-maxBars)
+			lines++
+			break
+		}
+
+		name := shortName(transfer.Container + "/" + transfer.Blob)
+		if transfer.Percent == nil { // Size not known yet
+			fmt.Fprintf(&frame, "%-*s %s    ? %9s\n", nameWidth, name, bar(0), bytefmt.ByteSize(uint64(transfer.Bytes)))
+		} else {
+			fmt.Fprintf(&frame, "%-*s %s %3.0f%% %9s / %s\n", nameWidth, name, bar(*transfer.Percent), *transfer.Percent,
+				bytefmt.ByteSize(uint64(transfer.Bytes)), bytefmt.ByteSize(uint64(transfer.Total)))
+		}
+		lines++
+	}
+
+	fmt.Fprintf(&frame, "[%s] %s %s\n", d.options.Stage, bar(d.percent(totals)), d.overall(totals))
+	d.lines = lines + 1
+
+	io.WriteString(d.options.Out, frame.String())
+}
+
+// Name cut to the bar's name column: its end, kept whole on a character boundary
+//
+// @param name string
+// @return string
+func shortName(name string) string {
+	if utf8.RuneCountInString(name) <= nameWidth {
+		return name
+	}
+
+	cut := len(name)
+	for kept := 0; kept < nameWidth-3; kept++ {
+		_, size := utf8.DecodeLastRuneInString(name[:cut])
+		cut -= size
+	}
+
+	return "..." + name[cut:]
+}
+
+// Overall summary: file(s), byte(s), throughput and ETA of the queue
+//
+// @param totals StageTotals
+// @return string
+func (d *Display) overall(totals api.StageTotals) string {
+	elapsed := time.Since(d.startedAt)
+	rate := float64(totals.Bytes) / elapsed.Seconds()
+
+	eta := "-"
+	if remaining := d.queueBytes - totals.Bytes; rate > 0 && remaining > 0 {
+		eta = (time.Duration(float64(remaining)/rate) * time.Second).Round(time.Second).String()
+	} else if d.queueBytes > 0 && remaining <= 0 {
+		eta = "0s"
+	}
+
+	return fmt.Sprintf("%d/%d files, %s / %s (%.0f%%), %s/s, elapsed %s, ETA %s",
+		totals.Files, d.queueFiles, bytefmt.ByteSize(uint64(totals.Bytes)), bytefmt.ByteSize(uint64(d.queueBytes)),
+		d.percent(totals), bytefmt.ByteSize(uint64(rate)), elapsed.Round(time.Second), eta)
+}
+
+// Done percentage of the queue: by byte(s), or by file(s) when sizes are unknown
+//
+// @param totals StageTotals
+// @return float
+func (d *Display) percent(totals api.StageTotals) float64 {
+	var percent float64
+	if d.queueBytes > 0 {
+		percent = float64(totals.Bytes) * 100 / float64(d.queueBytes)
+	} else if d.queueFiles > 0 {
+		percent = float64(totals.Files) * 100 / float64(d.queueFiles)
+	}
+
+	if percent > 100 {
+		return 100
+	}
+
+	return percent
+}
+
+// Fixed width bar, e.g. "[=========>           ]"
+func bar(percent float64) string {
+	filled := int(percent * barWidth / 100)
+	if filled >= barWidth {
+		return "[" + strings.Repeat("=", barWidth) + "]"
+	}
+
+	head := ""
+	if filled > 0 {
+		head, filled = ">", filled-1
+	}
+
+	return "[" + strings.Repeat("=", filled) + head + strings.Repeat(" ", barWidth-filled-len(head)) + "]"
+}
+
+// Terminal (character device) output
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
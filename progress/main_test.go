@@ -0,0 +1,100 @@
+// Namespace: progress/main_test.go
+
+package progress
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"../api"         // Status API (active transfer(s))
+	"../database"    // DB Handler Package
+	"../fakestorage" // Fake Blob / S3 Service(s)
+)
+
+// Two pending download row(s) of 100 byte(s) each
+func setupQueue(t *testing.T) {
+	t.Helper()
+
+	fakestorage.OpenDB(t)
+	for _, blob := range []string{"a.mp4", "b.mp4"} {
+		rows := []database.SyncRow{{Container: "media", Blob: blob, Properties: database.BlobProperties{Size: 100}}}
+		if result := database.InsertBlobs(rows); result[0].Err != nil {
+			t.Fatal(result[0].Err)
+		}
+	}
+}
+
+func TestPlainLines(t *testing.T) {
+	setupQueue(t)
+
+	var out bytes.Buffer
+	display := Start(Options{Stage: "download", Mode: ModeAuto, Interval: time.Hour, Out: &out, Log: fakestorage.Logger(t)})
+
+	finished := api.StartTransfer("download", "media", "a.mp4")
+	finished.SetTotal(100)
+	finished.Add(100)
+	finished.Done()
+	active := api.StartTransfer("download", "media", "b.mp4")
+	active.Add(50)
+	defer active.Done()
+
+	display.Stop()
+
+	line := out.String()
+	for _, want := range []string{"[download] 1/2 files", "150B / 200B (75%)", "1 active"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("plain line %q lacks %q", line, want)
+		}
+	}
+}
+
+func TestBars(t *testing.T) {
+	setupQueue(t)
+	previous := redrawInterval
+	redrawInterval = time.Hour // Frame(s) drawn by the test only
+	defer func() { redrawInterval = previous }()
+
+	var out bytes.Buffer
+	display := Start(Options{Stage: "upload", Mode: ModeBars, Out: &out, Log: fakestorage.Logger(t)})
+
+	transfer := api.StartTransfer("upload", "media", "a.mp4")
+	transfer.SetTotal(200)
+	transfer.Add(100)
+	defer transfer.Done()
+	display.render() // One redraw, then the final one
+
+	display.Stop()
+
+	frames := out.String()
+	if !strings.Contains(frames, "\x1b[2F\x1b[J") {
+		t.Errorf("second frame doesn't redraw the first one in place: %q", frames)
+	}
+	if !strings.Contains(frames, "media/a.mp4") || !strings.Contains(frames, "[===========>             ]  50%") {
+		t.Errorf("transfer bar missing: %q", frames)
+	}
+	if !strings.Contains(frames, "[upload] ") || !strings.Contains(frames, "0/0 files") {
+		t.Errorf("overall bar missing: %q", frames)
+	}
+	if Start(Options{Mode: ModeOff}) != nil {
+		t.Error("ModeOff started a display")
+	}
+}
+
+func TestShortName(t *testing.T) {
+	long := strings.Repeat("é", 50) + ".mp4" // 2 byte(s) per character
+	cases := map[string]string{
+		"media/a.mp4":                      "media/a.mp4",
+		long:                               "..." + strings.Repeat("é", nameWidth-7) + ".mp4",
+		"media/" + strings.Repeat("x", 40): "..." + strings.Repeat("x", nameWidth-3),
+	}
+
+	for name, want := range cases {
+		got := shortName(name)
+		if got != want || !utf8.ValidString(got) || utf8.RuneCountInString(got) > nameWidth {
+			t.Errorf("shortName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
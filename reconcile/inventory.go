@@ -0,0 +1,274 @@
+// Namespace: reconcile/inventory.go
+
+package reconcile
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go" // Parquet Reader (S3 Inventory)
+)
+
+// S3 Inventory manifest (manifest.json of a report)
+type inventoryManifest struct {
+	SourceBucket string `json:"sourceBucket"`
+	FileFormat   string `json:"fileFormat"` // CSV, Parquet (ORC isn't supported)
+	FileSchema   string `json:"fileSchema"` // CSV: column name(s), e.g. "Bucket, Key, Size, ETag"
+	Files        []struct {
+		Key         string `json:"key"`
+		MD5Checksum string `json:"MD5checksum"`
+	} `json:"files"`
+}
+
+// Object row of a Parquet inventory file (column(s) of other field(s) are skipped)
+type inventoryRow struct {
+	Key            string  `parquet:"key"`
+	Size           *int64  `parquet:"size,optional"`
+	ETag           *string `parquet:"e_tag,optional"`
+	IsLatest       *bool   `parquet:"is_latest,optional"`
+	IsDeleteMarker *bool   `parquet:"is_delete_marker,optional"`
+}
+
+// Inventory - Source reading S3 Inventory report(s) downloaded next to their manifest.json: data file(s) in the
+// manifest's folder, its "data" sub-folder or at their key below it (CSV or Parquet, with Size and ETag field(s));
+// a report of another bucket than bucket is rejected
+func Inventory(manifests []string, bucket string) Source {
+	return func(ctx context.Context, fn func(Object) error) error {
+		for _, manifestPath := range manifests {
+			manifest, err := readManifest(manifestPath)
+			if err != nil {
+				return err
+			} else if manifest.SourceBucket != bucket {
+				return fmt.Errorf("inventory manifest %s: report of bucket %q, not %q", manifestPath, manifest.SourceBucket, bucket)
+			}
+
+			for _, file := range manifest.Files {
+				dataPath, err := inventoryFile(filepath.Dir(manifestPath), file.Key)
+				if err != nil {
+					return err
+				}
+
+				switch strings.ToLower(manifest.FileFormat) {
+				case "csv":
+					err = readCSVInventory(ctx, dataPath, file.MD5Checksum, manifest.FileSchema, fn)
+				case "parquet":
+					err = readParquetInventory(ctx, dataPath, file.MD5Checksum, fn)
+				}
+				if err != nil {
+					return fmt.Errorf("inventory file %s: %v", dataPath, err)
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// Read and check manifest.json of an inventory report
+//
+// @param manifestPath string
+// @return inventoryManifest, error
+func readManifest(manifestPath string) (inventoryManifest, error) {
+	var manifest inventoryManifest
+	content, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return manifest, err
+	}
+
+	if err = json.Unmarshal(content, &manifest); err != nil {
+		return manifest, fmt.Errorf("inventory manifest %s: %v", manifestPath, err)
+	}
+
+	switch strings.ToLower(manifest.FileFormat) {
+	case "csv", "parquet":
+	default:
+		return manifest, fmt.Errorf("inventory manifest %s: unsupported file format %q (CSV, Parquet)", manifestPath, manifest.FileFormat)
+	}
+
+	return manifest, nil
+}
+
+// Local path of an inventory data file
+//
+// @param folder string (manifest's folder), key string (data file key in the destination bucket)
+// @return string, error
+func inventoryFile(folder string, key string) (string, error) {
+	candidates := []string{
+		filepath.Join(folder, path.Base(key)),
+		filepath.Join(folder, "data", path.Base(key)),
+		filepath.Join(folder, filepath.FromSlash(key)),
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("inventory file %s not found next to its manifest (%s)", path.Base(key), folder)
+}
+
+// Check an inventory data file against the MD5 which the manifest lists
+//
+// @param dataPath string, checksum string (empty: not checked)
+// @return error
+func checkFile(dataPath string, checksum string) error {
+	if len(checksum) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return err
+	}
+	if !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), checksum) {
+		return fmt.Errorf("MD5 differs from manifest, re-download it")
+	}
+
+	return nil
+}
+
+// Walk the object(s) of a gzipped CSV inventory file, which has the column(s) of the manifest's schema
+//
+// @param ctx Context, dataPath string, checksum string, schema string, fn callback
+// @return error
+func readCSVInventory(ctx context.Context, dataPath string, checksum string, schema string, fn func(Object) error) error {
+	columns := map[string]int{}
+	for idx, column := range strings.Split(schema, ",") {
+		columns[strings.TrimSpace(column)] = idx
+	}
+	for _, required := range []string{"Key", "Size", "ETag"} {
+		if _, ok := columns[required]; !ok {
+			return fmt.Errorf("inventory schema lacks %s field", required)
+		}
+	}
+
+	if err := checkFile(dataPath, checksum); err != nil {
+		return err
+	}
+
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	csvReader := csv.NewReader(gzipReader)
+	csvReader.FieldsPerRecord = len(columns)
+	for ctx.Err() == nil {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if isLatest, ok := columns["IsLatest"]; ok && record[isLatest] == "false" {
+			continue // Older version
+		}
+		if deleteMarker, ok := columns["IsDeleteMarker"]; ok && record[deleteMarker] == "true" {
+			continue
+		}
+
+		key, err := url.QueryUnescape(record[columns["Key"]]) // URL-encoded in CSV report(s)
+		if err != nil {
+			return fmt.Errorf("key %q: %v", record[columns["Key"]], err)
+		}
+		size, err := strconv.ParseInt(record[columns["Size"]], 10, 64)
+		if err != nil {
+			size = -1 // Blank / invalid: not reported
+		}
+
+		if err = fn(Object{Key: key, Size: size, ETag: record[columns["ETag"]]}); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// Walk the object(s) of a Parquet inventory file
+//
+// @param ctx Context, dataPath string, checksum string, fn callback
+// @return error
+func readParquetInventory(ctx context.Context, dataPath string, checksum string, fn func(Object) error) error {
+	if err := checkFile(dataPath, checksum); err != nil {
+		return err
+	}
+
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	parquetFile, err := parquet.OpenFile(file, fileInfo.Size())
+	if err != nil {
+		return err
+	}
+	for _, required := range []string{"key", "size", "e_tag"} {
+		if _, ok := parquetFile.Schema().Lookup(required); !ok {
+			return fmt.Errorf("inventory schema lacks %s field", required)
+		}
+	}
+
+	reader := parquet.NewGenericReader[inventoryRow](parquetFile)
+	defer reader.Close()
+
+	rows := make([]inventoryRow, 1000)
+	for ctx.Err() == nil {
+		count, readErr := reader.Read(rows)
+		for _, row := range rows[:count] {
+			if (row.IsLatest != nil && !*row.IsLatest) || (row.IsDeleteMarker != nil && *row.IsDeleteMarker) {
+				continue // Older version / delete marker
+			}
+
+			object := Object{Key: row.Key, Size: -1}
+			if row.Size != nil {
+				object.Size = *row.Size
+			}
+			if row.ETag != nil {
+				object.ETag = *row.ETag
+			}
+			if err = fn(object); err != nil {
+				return err
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return readErr
+		}
+	}
+
+	return ctx.Err()
+}
@@ -0,0 +1,123 @@
+// Namespace: reconcile/inventory_test.go
+
+package reconcile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go" // Parquet Writer (S3 Inventory)
+)
+
+// Parquet inventory row, as written by S3 Inventory
+type parquetInventoryRow struct {
+	Bucket         string  `parquet:"bucket"`
+	Key            string  `parquet:"key"`
+	VersionID      *string `parquet:"version_id,optional"`
+	IsLatest       *bool   `parquet:"is_latest,optional"`
+	IsDeleteMarker *bool   `parquet:"is_delete_marker,optional"`
+	Size           *int64  `parquet:"size,optional"`
+	ETag           *string `parquet:"e_tag,optional"`
+	StorageClass   *string `parquet:"storage_class,optional"`
+}
+
+// Write manifest.json listing data file(s) below folder (stored under "data/" in the destination bucket)
+func writeManifest(t *testing.T, folder string, format string, schema string, files ...string) string {
+	t.Helper()
+
+	manifest := map[string]interface{}{"sourceBucket": "bucket", "fileFormat": format, "fileSchema": schema}
+	var listed []map[string]string
+	for _, file := range files {
+		content, err := ioutil.ReadFile(filepath.Join(folder, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := md5.Sum(content)
+		listed = append(listed, map[string]string{"key": "inventory/bucket/daily/data/" + filepath.Base(file), "MD5checksum": hex.EncodeToString(sum[:])})
+	}
+	manifest["files"] = listed
+
+	content, _ := json.Marshal(manifest)
+	manifestPath := filepath.Join(folder, "manifest.json")
+	if err := ioutil.WriteFile(manifestPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return manifestPath
+}
+
+// Object(s) walked by a source
+func walk(t *testing.T, source Source) ([]Object, error) {
+	t.Helper()
+
+	var objects []Object
+	err := source(context.Background(), func(object Object) error {
+		objects = append(objects, object)
+		return nil
+	})
+
+	return objects, err
+}
+
+func TestCSVInventory(t *testing.T) {
+	folder := t.TempDir()
+
+	var data bytes.Buffer
+	gzipWriter := gzip.NewWriter(&data)
+	gzipWriter.Write([]byte(strings.Join([]string{
+		`"bucket","with+space%2B.mp4","","true","false","4","2020-05-10T12:00:00.000Z","abc","STANDARD"`,
+		`"bucket","old.mp4","v1","false","false","3","2020-05-10T12:00:00.000Z","def","STANDARD"`,
+		`"bucket","deleted.mp4","v2","true","true","","2020-05-10T12:00:00.000Z","","STANDARD"`,
+		`"bucket","blank.mp4","v3","true","false","","2020-05-10T12:00:00.000Z","","STANDARD"`,
+	}, "\n")))
+	gzipWriter.Close()
+	ioutil.WriteFile(filepath.Join(folder, "part-1.csv.gz"), data.Bytes(), 0644)
+
+	manifestPath := writeManifest(t, folder, "CSV", "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size, LastModifiedDate, ETag, StorageClass", "part-1.csv.gz")
+	objects, err := walk(t, Inventory([]string{manifestPath}, "bucket"))
+	if err != nil || len(objects) != 2 || objects[0] != (Object{Key: "with space+.mp4", Size: 4, ETag: "abc"}) ||
+		objects[1] != (Object{Key: "blank.mp4", Size: -1}) {
+		t.Fatalf("objects = %+v, %v", objects, err)
+	}
+
+	if _, err = walk(t, Inventory([]string{manifestPath}, "other")); err == nil {
+		t.Error("report of another bucket: no error")
+	}
+
+	ioutil.WriteFile(filepath.Join(folder, "part-1.csv.gz"), data.Bytes()[:data.Len()-1], 0644)
+	if _, err = walk(t, Inventory([]string{manifestPath}, "bucket")); err == nil {
+		t.Error("truncated data file: no error")
+	}
+}
+
+func TestParquetInventory(t *testing.T) {
+	folder := t.TempDir()
+	os.Mkdir(filepath.Join(folder, "data"), 0755)
+
+	size, etag, latest, older, marker := int64(7), "0123-2", true, false, false
+	rows := []parquetInventoryRow{
+		{Bucket: "bucket", Key: "a b.mp4", IsLatest: &latest, IsDeleteMarker: &marker, Size: &size, ETag: &etag},
+		{Bucket: "bucket", Key: "old.mp4", IsLatest: &older, IsDeleteMarker: &marker, Size: &size, ETag: &etag},
+		{Bucket: "bucket", Key: "null.mp4", IsLatest: &latest, IsDeleteMarker: &marker},
+	}
+	if err := parquet.WriteFile(filepath.Join(folder, "data", "part-1.parquet"), rows); err != nil {
+		t.Fatal(err)
+	}
+
+	manifestPath := writeManifest(t, folder, "Parquet", "message s3.inventory { required binary bucket (STRING); required binary key (STRING); }", "data/part-1.parquet")
+
+	objects, err := walk(t, Inventory([]string{manifestPath}, "bucket"))
+	if err != nil || len(objects) != 2 || objects[0] != (Object{Key: "a b.mp4", Size: 7, ETag: "0123-2"}) ||
+		objects[1] != (Object{Key: "null.mp4", Size: -1}) {
+		t.Fatalf("objects = %+v, %v", objects, err)
+	}
+}
@@ -0,0 +1,275 @@
+// Namespace: reconcile/main.go
+
+package reconcile
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"../database"  // DB Handler Package
+	"../failures"  // Run Error Summary
+	"../helpers"   // Helper Package
+	"../logger"    // Leveled Logger
+	"../metrics"   // Prometheus Metrics
+	"../upload/s3" // S3 Key Mapping
+
+	"code.cloudfoundry.org/bytefmt"                // Byte Format
+	"github.com/aws/aws-sdk-go/aws"                // AWS Core SDK
+	s3api "github.com/aws/aws-sdk-go/service/s3"   // AWS S3 API (List Objects)
+	"github.com/aws/aws-sdk-go/service/s3/s3iface" // AWS S3 API Interface
+)
+
+// Global Constant(s)
+const (
+	batchSize       = 1000             // Row(s) per query
+	uploadPartSize  = 10 * 1024 * 1024 // Part size of the upload stage
+	cliPartSize     = 8 * 1024 * 1024  // Default part size of the AWS CLI / SDK(s)
+	statusCompleted = 1
+	statusFailed    = 2
+)
+
+// Object - Object of the bucket listing or S3 Inventory report
+type Object struct {
+	Key  string
+	Size int64  // -1: not reported (e.g. blank in an inventory report)
+	ETag string // Without quotes, empty: not reported
+}
+
+// Source - Walk the object(s) of the bucket, calling fn for each one (an fn error stops the walk)
+type Source func(ctx context.Context, fn func(Object) error) error
+
+// Options - Reconcile setting(s)
+type Options struct {
+	Upload s3.EnvVars // Media folder and key mapping of the upload stage
+	Log    *logger.Logger
+}
+
+// Result - Object(s) walked and what became of the unconfirmed row(s)
+type Result struct {
+	Objects      int64
+	Files, Bytes int64 // Row(s) completed: size and ETag agree
+	Mismatched   int64 // Row(s) flagged failed: size or ETag differs
+	Unverified   int64 // Row(s) without local copy or object size / ETag: nothing to compare, left unchanged
+	Ambiguous    int64 // Row(s) sharing their key with another row (snapshot versions), left unchanged
+	NotFound     int64 // Row(s) without object, left to the upload
+}
+
+// BucketListing - Source listing the bucket (under prefix)
+func BucketListing(client s3iface.S3API, bucket string, prefix string) Source {
+	return func(ctx context.Context, fn func(Object) error) error {
+		var fnErr error
+		listErr := client.ListObjectsV2PagesWithContext(ctx, &s3api.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix)},
+			func(page *s3api.ListObjectsV2Output, lastPage bool) bool {
+				for _, item := range page.Contents {
+					object := Object{Key: aws.StringValue(item.Key), Size: aws.Int64Value(item.Size), ETag: strings.Trim(aws.StringValue(item.ETag), `"`)}
+					if fnErr = fn(object); fnErr != nil {
+						return false
+					}
+				}
+
+				return true
+			})
+		if fnErr != nil {
+			return fnErr
+		}
+
+		return listErr
+	}
+}
+
+// Run - Match the object(s) of source with the downloaded row(s) not confirmed in S3 through the key mapping of
+// the upload: a row is completed when size and ETag agree, and flagged failed when they don't
+func Run(ctx context.Context, source Source, opts Options) (Result, error) {
+	var result Result
+
+	// Unconfirmed Row(s) by Object Key (nil: key of several rows)
+	pending := map[string]*database.ShipRow{}
+	afterID := int64(0)
+	for {
+		rows, err := database.GetUnconfirmedContent(ctx, afterID, batchSize)
+		if err != nil {
+			return result, err
+		} else if len(rows) == 0 {
+			break
+		}
+
+		for idx := range rows {
+			afterID = rows[idx].ID
+			key := s3.ObjectKey(rows[idx].Container, rows[idx].Blob, rows[idx].Snapshot, opts.Upload)
+			if existing, ok := pending[key]; ok {
+				if existing != nil {
+					result.Ambiguous++
+				}
+				result.Ambiguous++
+				pending[key] = nil
+				continue
+			}
+			pending[key] = &rows[idx]
+		}
+	}
+	opts.Log.Info(fmt.Sprintf("Reconciling %s unconfirmed rows with S3", helpers.FormatCount(int64(len(pending)))))
+
+	walkErr := source(ctx, func(object Object) error {
+		result.Objects++
+		row, ok := pending[object.Key]
+		if !ok || row == nil {
+			return ctx.Err()
+		}
+		delete(pending, object.Key) // Listed once (inventory file(s) may overlap)
+
+		reconcileRow(*row, object, opts, &result)
+		return ctx.Err()
+	})
+
+	for _, row := range pending {
+		if row != nil {
+			result.NotFound++
+		}
+	}
+
+	opts.Log.Info(fmt.Sprintf("Reconciled %s files (%s) with S3", helpers.FormatCount(result.Files), bytefmt.ByteSize(uint64(result.Bytes))),
+		logger.Fields{"objects": result.Objects, "files": result.Files, "bytes": result.Bytes, "mismatched": result.Mismatched,
+			"unverified": result.Unverified, "ambiguous": result.Ambiguous, "not_found": result.NotFound})
+
+	return result, walkErr
+}
+
+// Compare object with the local copy of its row, and complete or flag the row
+//
+// @param row ShipRow, object Object, opts Options, result pointer
+// @return nil
+func reconcileRow(row database.ShipRow, object Object, opts Options, result *Result) {
+	log := opts.Log.With(logger.Fields{"container": row.Container, "blob": row.Blob, "snapshot": row.Snapshot, "key": object.Key})
+	filePath := opts.Upload.MediaFolder + helpers.LocalFileName(row.Container, row.Blob, row.Snapshot, opts.Upload.SnapshotSuffix)
+
+	if object.Size < 0 || len(object.ETag) == 0 {
+		log.Warn("Unverified: object size or ETag not reported", logger.Fields{"size": object.Size, "etag": object.ETag})
+		result.Unverified++
+		return
+	}
+
+	fileInfo, statErr := os.Stat(filePath)
+	if statErr != nil {
+		log.Warn("Unverified: no local copy to compute the ETag", logger.Fields{"error": statErr})
+		result.Unverified++
+		return
+	}
+
+	var mismatch string
+	if object.Size != fileInfo.Size() {
+		mismatch = fmt.Sprintf("reconcile: object size %d, expected %d", object.Size, fileInfo.Size())
+	} else if matches, err := etagMatches(filePath, fileInfo.Size(), object.ETag); err != nil {
+		log.Warn("Unverified: unable to compute the ETag", logger.Fields{"error": err})
+		result.Unverified++
+		return
+	} else if !matches {
+		mismatch = fmt.Sprintf("reconcile: object ETag %s differs from the local copy", object.ETag)
+	}
+
+	if len(mismatch) != 0 {
+		log.Error("[Mismatch]", logger.Fields{"error": mismatch})
+		result.Mismatched++
+		recordFlagError(log, row, database.SetS3Flag(row.Container, row.Blob, row.Snapshot, statusFailed, mismatch))
+		return
+	}
+
+	log.Debug("[Confirmed]", logger.Fields{"bytes": fileInfo.Size()})
+	result.Files++
+	result.Bytes += fileInfo.Size()
+	recordFlagError(log, row, database.SetS3Flag(row.Container, row.Blob, row.Snapshot, statusCompleted, ""))
+}
+
+// Check if the ETag of an object is the one of the local file: MD5 of a single part upload, or MD5 of the part
+// MD5(s) with "-<parts>" for a multipart upload (part size of the upload stage, the AWS CLI, or the smallest
+// whole MB giving that many parts)
+//
+// @param filePath string, size integer, etag string
+// @return boolean, error
+func etagMatches(filePath string, size int64, etag string) (bool, error) {
+	etag = strings.ToLower(etag)
+	dash := strings.LastIndex(etag, "-")
+	if dash == -1 {
+		sum, err := fileETag(filePath, 0)
+		return sum == etag, err
+	}
+
+	parts, err := strconv.ParseInt(etag[dash+1:], 10, 64)
+	if err != nil || parts <= 0 {
+		return false, nil // Not an MD5 based ETag (e.g. SSE-KMS)
+	}
+
+	candidates := []int64{uploadPartSize, cliPartSize}
+	if perPart := (size + parts - 1) / parts; perPart > 0 {
+		candidates = append(candidates, (perPart+1024*1024-1)/(1024*1024)*1024*1024)
+	}
+
+	tried := map[int64]bool{}
+	for _, partSize := range candidates {
+		if tried[partSize] || (size+partSize-1)/partSize != parts {
+			continue
+		}
+		tried[partSize] = true
+
+		sum, err := fileETag(filePath, partSize)
+		if err != nil || sum == etag {
+			return err == nil, err
+		}
+	}
+
+	return false, nil
+}
+
+// ETag of a file uploaded in one part (partSize 0) or in parts of partSize
+//
+// @param filePath string, partSize integer
+// @return string, error
+func fileETag(filePath string, partSize int64) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if partSize == 0 {
+		hash := md5.New()
+		if _, err = io.Copy(hash, file); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	sums, parts := md5.New(), 0
+	for {
+		hash := md5.New()
+		written, copyErr := io.CopyN(hash, file, partSize)
+		if copyErr != nil && copyErr != io.EOF {
+			return "", copyErr
+		}
+		if written != 0 {
+			sums.Write(hash.Sum(nil))
+			parts++
+		}
+		if copyErr == io.EOF {
+			break
+		}
+	}
+
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sums.Sum(nil)), parts), nil
+}
+
+// Record failure of a status update
+//
+// @param log Logger, row ShipRow, err error
+// @return nil
+func recordFlagError(log *logger.Logger, row database.ShipRow, err error) {
+	if err != nil {
+		log.Error("Unable to set s3_status", logger.Fields{"error": err})
+		failures.Record(metrics.StageUpload, row.Container, row.Blob, err)
+	}
+}
@@ -0,0 +1,168 @@
+// Namespace: reconcile/main_test.go
+
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"../database"    // DB Handler Package
+	"../endpoint"    // S3 Endpoint
+	"../fakestorage" // Fake Blob / S3 Service(s)
+	"../helpers"     // Helper Package
+	"../metrics"     // Prometheus Metrics
+	"../upload/s3"   // S3 Key Mapping
+
+	s3api "github.com/aws/aws-sdk-go/service/s3" // AWS S3 API
+)
+
+// Fresh DB with downloaded row(s) pending upload and their file(s) in the media folder (nil content: no local copy)
+func setupReconcile(t *testing.T, files map[string][]byte) Options {
+	t.Helper()
+	fakestorage.OpenDB(t)
+
+	opts := Options{Log: fakestorage.Logger(t), Upload: s3.EnvVars{S3: endpoint.S3{Bucket: "bucket", Region: "us-east-1"},
+		MediaFolder: t.TempDir() + "/", SnapshotMode: s3.SnapshotSuffix, SnapshotSuffix: helpers.DefaultSnapshotSuffix}}
+
+	for blob, content := range files {
+		fakestorage.AddDownloaded(t, opts.Upload.MediaFolder, opts.Upload.SnapshotSuffix, database.SyncRow{Container: "media", Blob: blob}, content)
+	}
+
+	return opts
+}
+
+// S3 ETag (without quotes) of content uploaded in parts of partSize
+func multipartETag(content []byte, partSize int) string {
+	var sums []byte
+	parts := 0
+	for offset := 0; offset < len(content); offset += partSize {
+		end := offset + partSize
+		if end > len(content) {
+			end = len(content)
+		}
+		sum := md5.Sum(content[offset:end])
+		sums = append(sums, sum[:]...)
+		parts++
+	}
+	sum := md5.Sum(sums)
+
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), parts)
+}
+
+// Blob(s) with their s3_status
+func blobStatus(t *testing.T) map[string]int {
+	t.Helper()
+
+	items, _, err := database.GetSyncItems("", "", 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := map[string]int{}
+	for _, item := range items {
+		status[item.Blob] = item.S3Status
+	}
+
+	return status
+}
+
+func TestRunBucketListing(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	fake := fakestorage.NewS3()
+	t.Cleanup(fake.Close)
+	fake.CreateBucket("bucket", false)
+	fake.PageSize = 2
+
+	large := bytes.Repeat([]byte("0123456789"), 1700*1024) // 17MB: three 8MB part(s) of a CLI upload
+	opts := setupReconcile(t, map[string][]byte{"same.mp4": []byte("same"), "large.mp4": large, "changed.mp4": []byte("local"),
+		"short.mp4": []byte("short"), "pending.mp4": []byte("pending"), "pruned.mp4": nil})
+
+	fake.PutObject("bucket", "same.mp4", fakestorage.Object{Content: []byte("same")})
+	fake.PutObject("bucket", "large.mp4", fakestorage.Object{Content: large, ETag: `"` + multipartETag(large, 8*1024*1024) + `"`})
+	fake.PutObject("bucket", "changed.mp4", fakestorage.Object{Content: []byte("other")})
+	fake.PutObject("bucket", "short.mp4", fakestorage.Object{Content: []byte("sh")})
+	fake.PutObject("bucket", "pruned.mp4", fakestorage.Object{Content: []byte("pruned")})
+	fake.PutObject("bucket", "unknown.mp4", fakestorage.Object{Content: []byte("unknown")})
+
+	opts.Upload.S3.Endpoint, opts.Upload.S3.PathStyle = fake.URL, true
+	sess, err := opts.Upload.S3.Session(metrics.Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Run(context.Background(), BucketListing(s3api.New(sess), "bucket", ""), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (Result{Objects: 6, Files: 2, Bytes: int64(len(large)) + 4, Mismatched: 2, Unverified: 1, NotFound: 1}) {
+		t.Errorf("result = %+v", result)
+	}
+
+	want := map[string]int{"same.mp4": 1, "large.mp4": 1, "changed.mp4": 2, "short.mp4": 2, "pending.mp4": 0, "pruned.mp4": 0}
+	for blob, status := range blobStatus(t) {
+		if status != want[blob] {
+			t.Errorf("%s: s3_status = %d, want %d", blob, status, want[blob])
+		}
+	}
+}
+
+func TestRunUnreportedSizeOrETag(t *testing.T) {
+	opts := setupReconcile(t, map[string][]byte{"no-etag.mp4": []byte("same"), "no-size.mp4": []byte("same"), "same.mp4": []byte("same")})
+
+	sum := md5.Sum([]byte("same"))
+	etag := hex.EncodeToString(sum[:])
+	inventory := func(ctx context.Context, fn func(Object) error) error {
+		for _, object := range []Object{{Key: "no-etag.mp4", Size: 4}, {Key: "no-size.mp4", Size: -1, ETag: etag}, {Key: "same.mp4", Size: 4, ETag: etag}} {
+			if err := fn(object); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	result, err := Run(context.Background(), inventory, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (Result{Objects: 3, Files: 1, Bytes: 4, Unverified: 2}) {
+		t.Errorf("result = %+v", result)
+	}
+
+	want := map[string]int{"no-etag.mp4": 0, "no-size.mp4": 0, "same.mp4": 1}
+	for blob, status := range blobStatus(t) {
+		if status != want[blob] {
+			t.Errorf("%s: s3_status = %d, want %d", blob, status, want[blob])
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 21*1024*1024)
+	path := filepath.Join(t.TempDir(), "file")
+	ioutil.WriteFile(path, content, 0644)
+
+	sum := md5.Sum(content)
+	for etag, want := range map[string]bool{
+		hex.EncodeToString(sum[:]):         true,
+		multipartETag(content, 10<<20):     true,  // Upload stage: 3 part(s)
+		multipartETag(content, 11<<20):     true,  // Smallest whole MB giving 2 part(s)
+		multipartETag(content, 4608<<10):   false, // 4.5MB: 5 part(s), guessed as 5MB
+		"0123456789abcdef0123456789abcdef": false,
+	} {
+		if matches, err := etagMatches(path, int64(len(content)), etag); err != nil || matches != want {
+			t.Errorf("etagMatches(%s) = %v, %v; want %v", etag, matches, err, want)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// Namespace: report/main.go
+
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"../database" // DB Handler Package
+	"../helpers"  // Helper Package
+
+	"code.cloudfoundry.org/bytefmt" // Byte Format
+)
+
+// Status Code Name(s) (see README)
+var containerStatusNames = map[int]string{0: "InActive", 100: "Live", 200: "Success", 404: "Container Not Found", 503: "Blob Not Found"}
+var syncStatusNames = map[int]string{0: "InActive", 1: "Success", 2: "Failure", 3: "Interrupted", 4: "In Progress"}
+
+// Error message part(s) which differ between otherwise identical errors
+var errorPatterns = []struct {
+	expression  *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`https?://\S+`), "<url>"},
+	{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "<uuid>"},
+	{regexp.MustCompile(`"[^"]*"`), `"<value>"`},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{16,}\b`), "<hex>"},
+	{regexp.MustCompile(`\d+`), "<n>"},
+}
+
+// Report - Migration progress aggregated from the containers and sync tables
+type Report struct {
+	GeneratedAt     time.Time                 `json:"generated_at"`
+	Summary         Summary                   `json:"summary"`
+	ContainerStatus []StatusCount             `json:"container_status"`
+	AzureStatus     []StatusCount             `json:"azure_status"`
+	S3Status        []StatusCount             `json:"s3_status"`
+	Containers      []database.ContainerTotal `json:"containers"`
+	TopErrors       []ErrorPattern            `json:"top_errors"`
+	Throughput      []database.Throughput     `json:"throughput"`
+}
+
+// Summary - Totals across every container
+type Summary struct {
+	Containers      int64 `json:"containers"`
+	Files           int64 `json:"files"`
+	Bytes           int64 `json:"bytes"`
+	Downloaded      int64 `json:"downloaded"`
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+	Uploaded        int64 `json:"uploaded"`
+	UploadedBytes   int64 `json:"uploaded_bytes"`
+	Failed          int64 `json:"failed"`
+}
+
+// StatusCount - Row count of a status code
+type StatusCount struct {
+	Code  int    `json:"code"`
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// ErrorPattern - Error messages which only differ by ids, numbers, urls, ...
+type ErrorPattern struct {
+	Stage   string `json:"stage"`
+	Pattern string `json:"pattern"`
+	Example string `json:"example"`
+	Count   int64  `json:"count"`
+}
+
+// Build - Aggregate report (period: "day" / "hour", topErrors: number of error patterns)
+func Build(period string, topErrors int) (*Report, error) {
+	if period != "day" && period != "hour" {
+		return nil, fmt.Errorf("invalid report period %q (day, hour)", period)
+	}
+
+	migrationReport := &Report{GeneratedAt: time.Now()}
+
+	// Per Container Totals
+	containers, err := database.GetContainerTotals()
+	if err != nil {
+		return nil, err
+	}
+	migrationReport.Containers = containers
+
+	for _, container := range containers {
+		migrationReport.Summary.Containers++
+		migrationReport.Summary.Files += container.Files
+		migrationReport.Summary.Bytes += container.Bytes
+		migrationReport.Summary.Downloaded += container.Downloaded
+		migrationReport.Summary.DownloadedBytes += container.DownloadedBytes
+		migrationReport.Summary.Uploaded += container.Uploaded
+		migrationReport.Summary.UploadedBytes += container.UploadedBytes
+		migrationReport.Summary.Failed += container.Failed
+	}
+
+	// Status Breakdown(s)
+	containerCounts, err := database.GetContainerStatusCounts()
+	if err != nil {
+		return nil, err
+	}
+	migrationReport.ContainerStatus = statusCounts(containerCounts, containerStatusNames)
+
+	syncCounts, err := database.GetStatusCounts()
+	if err != nil {
+		return nil, err
+	}
+	migrationReport.AzureStatus = statusCounts(syncCounts["azure"], syncStatusNames)
+	migrationReport.S3Status = statusCounts(syncCounts["s3"], syncStatusNames)
+
+	// Top Error Pattern(s)
+	errorCounts, err := database.GetErrorCounts()
+	if err != nil {
+		return nil, err
+	}
+	migrationReport.TopErrors = groupErrors(errorCounts, topErrors)
+
+	// Throughput over Time
+	throughput, err := database.GetThroughput(period)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(throughput, func(i, j int) bool {
+		if throughput[i].Period != throughput[j].Period {
+			return throughput[i].Period < throughput[j].Period
+		}
+		return throughput[i].Stage < throughput[j].Stage
+	})
+	migrationReport.Throughput = throughput
+
+	return migrationReport, nil
+}
+
+// Write - Render report as "table", "csv", "json" or "html"
+func Write(w io.Writer, migrationReport *Report, format string) error {
+	switch format {
+	case "", "table":
+		return writeTable(w, migrationReport)
+	case "csv":
+		return writeCSV(w, migrationReport)
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(migrationReport)
+	case "html":
+		return htmlTemplate.Execute(w, migrationReport)
+	}
+
+	return fmt.Errorf("invalid report format %q (table, csv, json, html)", format)
+}
+
+// Cut text to at most limit bytes on a rune boundary, marking the cut with "..."
+func truncate(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+
+	return text[:cut] + "..."
+}
+
+// Sorted status counts with names
+func statusCounts(counts map[int]int64, names map[int]string) []StatusCount {
+	statusList := []StatusCount{}
+	for code, count := range counts {
+		name, ok := names[code]
+		if !ok {
+			name = "Unknown"
+		}
+		statusList = append(statusList, StatusCount{Code: code, Name: name, Count: count})
+	}
+	sort.Slice(statusList, func(i, j int) bool { return statusList[i].Code < statusList[j].Code })
+
+	return statusList
+}
+
+// Group error messages by pattern and keep the most frequent ones
+func groupErrors(errorCounts []database.ErrorCount, limit int) []ErrorPattern {
+	patterns := map[string]*ErrorPattern{}
+	for _, errorCount := range errorCounts {
+		pattern := errorCount.Message
+		for _, errorPattern := range errorPatterns {
+			pattern = errorPattern.expression.ReplaceAllString(pattern, errorPattern.replacement)
+		}
+		pattern = truncate(pattern, 300)
+
+		key := errorCount.Stage + "|" + pattern
+		if _, ok := patterns[key]; !ok {
+			patterns[key] = &ErrorPattern{Stage: errorCount.Stage, Pattern: pattern, Example: errorCount.Message}
+		}
+		patterns[key].Count += errorCount.Count
+	}
+
+	grouped := []ErrorPattern{}
+	for _, errorPattern := range patterns {
+		grouped = append(grouped, *errorPattern)
+	}
+	sort.Slice(grouped, func(i, j int) bool {
+		if grouped[i].Count != grouped[j].Count {
+			return grouped[i].Count > grouped[j].Count
+		}
+		return grouped[i].Pattern < grouped[j].Pattern
+	})
+
+	if limit > 0 && len(grouped) > limit {
+		grouped = grouped[:limit]
+	}
+
+	return grouped
+}
+
+// Human readable byte size
+func byteSize(bytes int64) string {
+	if bytes <= 0 {
+		return "0B"
+	}
+
+	return bytefmt.ByteSize(uint64(bytes))
+}
+
+// Render report as aligned text table(s)
+func writeTable(w io.Writer, migrationReport *Report) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	summary := migrationReport.Summary
+
+	fmt.Fprintf(table, "Migration Report\t%s\n\n", migrationReport.GeneratedAt.Format(time.RFC1123))
+	fmt.Fprintf(table, "TOTAL\tCONTAINERS\tFILES\tBYTES\tDOWNLOADED\tUPLOADED\tFAILED\n")
+	fmt.Fprintf(table, "\t%s\t%s\t%s\t%s (%s)\t%s (%s)\t%s\n\n",
+		helpers.FormatCount(summary.Containers), helpers.FormatCount(summary.Files), byteSize(summary.Bytes),
+		helpers.FormatCount(summary.Downloaded), byteSize(summary.DownloadedBytes),
+		helpers.FormatCount(summary.Uploaded), byteSize(summary.UploadedBytes), helpers.FormatCount(summary.Failed))
+
+	for _, section := range []struct {
+		title  string
+		counts []StatusCount
+	}{
+		{"CONTAINER STATUS", migrationReport.ContainerStatus},
+		{"AZURE STATUS", migrationReport.AzureStatus},
+		{"S3 STATUS", migrationReport.S3Status},
+	} {
+		fmt.Fprintf(table, "%s\tCODE\tCOUNT\n", section.title)
+		for _, statusCount := range section.counts {
+			fmt.Fprintf(table, "%s\t%d\t%s\n", statusCount.Name, statusCount.Code, helpers.FormatCount(statusCount.Count))
+		}
+		fmt.Fprintln(table)
+	}
+
+	fmt.Fprintf(table, "CONTAINER\tSTATUS\tFILES\tBYTES\tDOWNLOADED\tUPLOADED\tFAILED\n")
+	for _, container := range migrationReport.Containers {
+		fmt.Fprintf(table, "%s\t%d\t%s\t%s\t%s (%s)\t%s (%s)\t%s\n", container.Container, container.Status,
+			helpers.FormatCount(container.Files), byteSize(container.Bytes),
+			helpers.FormatCount(container.Downloaded), byteSize(container.DownloadedBytes),
+			helpers.FormatCount(container.Uploaded), byteSize(container.UploadedBytes), helpers.FormatCount(container.Failed))
+	}
+	fmt.Fprintln(table)
+
+	fmt.Fprintf(table, "TOP ERRORS\tSTAGE\tCOUNT\n")
+	for _, errorPattern := range migrationReport.TopErrors {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", errorPattern.Pattern, errorPattern.Stage, helpers.FormatCount(errorPattern.Count))
+	}
+	fmt.Fprintln(table)
+
+	fmt.Fprintf(table, "PERIOD\tSTAGE\tFILES\tBYTES\n")
+	for _, row := range migrationReport.Throughput {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", row.Period, row.Stage, helpers.FormatCount(row.Files), byteSize(row.Bytes))
+	}
+
+	return table.Flush()
+}
+
+// Render report as CSV section(s), separated by an empty line
+func writeCSV(w io.Writer, migrationReport *Report) error {
+	writer := csv.NewWriter(w)
+	itoa := func(value int64) string { return strconv.FormatInt(value, 10) }
+	summary := migrationReport.Summary
+
+	writer.Write([]string{"section", "containers", "files", "bytes", "downloaded", "downloaded_bytes", "uploaded", "uploaded_bytes", "failed"})
+	writer.Write([]string{"summary", itoa(summary.Containers), itoa(summary.Files), itoa(summary.Bytes), itoa(summary.Downloaded),
+		itoa(summary.DownloadedBytes), itoa(summary.Uploaded), itoa(summary.UploadedBytes), itoa(summary.Failed)})
+	writer.Write(nil)
+
+	writer.Write([]string{"section", "code", "name", "count"})
+	for _, section := range []struct {
+		title  string
+		counts []StatusCount
+	}{
+		{"container_status", migrationReport.ContainerStatus},
+		{"azure_status", migrationReport.AzureStatus},
+		{"s3_status", migrationReport.S3Status},
+	} {
+		for _, statusCount := range section.counts {
+			writer.Write([]string{section.title, strconv.Itoa(statusCount.Code), statusCount.Name, itoa(statusCount.Count)})
+		}
+	}
+	writer.Write(nil)
+
+	writer.Write([]string{"container", "status", "files", "bytes", "downloaded", "downloaded_bytes", "uploaded", "uploaded_bytes", "failed"})
+	for _, container := range migrationReport.Containers {
+		writer.Write([]string{container.Container, strconv.Itoa(container.Status), itoa(container.Files), itoa(container.Bytes),
+			itoa(container.Downloaded), itoa(container.DownloadedBytes), itoa(container.Uploaded), itoa(container.UploadedBytes), itoa(container.Failed)})
+	}
+	writer.Write(nil)
+
+	writer.Write([]string{"stage", "error_pattern", "count", "example"})
+	for _, errorPattern := range migrationReport.TopErrors {
+		writer.Write([]string{errorPattern.Stage, errorPattern.Pattern, itoa(errorPattern.Count), errorPattern.Example})
+	}
+	writer.Write(nil)
+
+	writer.Write([]string{"period", "stage", "files", "bytes"})
+	for _, row := range migrationReport.Throughput {
+		writer.Write([]string{row.Period, row.Stage, itoa(row.Files), itoa(row.Bytes)})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// Standalone HTML page (inline CSS, no external asset)
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"count": helpers.FormatCount,
+	"bytes": byteSize,
+	"dict": func(pairs ...interface{}) map[string]interface{} {
+		dict := map[string]interface{}{}
+		for idx := 0; idx+1 < len(pairs); idx += 2 {
+			dict[pairs[idx].(string)] = pairs[idx+1]
+		}
+		return dict
+	},
+	"percent": func(part int64, total int64) string {
+		if total == 0 {
+			return "0%"
+		}
+		return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sync Cloud Storage: Migration Report</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ddd; padding: 4px 10px; text-align: right; }
+th { background: #f3f3f3; }
+.text { text-align: left; }
+.cards { display: flex; gap: 1em; margin-bottom: 2em; }
+.card { border: 1px solid #ddd; border-radius: 6px; padding: 1em 1.5em; }
+.card b { display: block; font-size: 1.6em; }
+</style>
+</head>
+<body>
+<h1>Migration Report</h1>
+<p>Generated {{.GeneratedAt.Format "Mon, 02 Jan 2006 15:04:05 MST"}}</p>
+{{with .Summary}}
+<div class="cards">
+<div class="card">Containers<b>{{count .Containers}}</b></div>
+<div class="card">Files<b>{{count .Files}}</b>{{bytes .Bytes}}</div>
+<div class="card">Downloaded<b>{{percent .Downloaded .Files}}</b>{{count .Downloaded}} files, {{bytes .DownloadedBytes}}</div>
+<div class="card">Uploaded<b>{{percent .Uploaded .Files}}</b>{{count .Uploaded}} files, {{bytes .UploadedBytes}}</div>
+<div class="card">Failed<b>{{count .Failed}}</b></div>
+</div>
+{{end}}
+<h2>Status</h2>
+{{range $title, $counts := dict "Containers" .ContainerStatus "Azure" .AzureStatus "S3" .S3Status}}
+<table>
+<tr><th class="text">{{$title}}</th><th>Code</th><th>Count</th></tr>
+{{range $counts}}<tr><td class="text">{{.Name}}</td><td>{{.Code}}</td><td>{{count .Count}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Containers</h2>
+<table>
+<tr><th class="text">Container</th><th>Status</th><th>Files</th><th>Bytes</th><th>Downloaded</th><th>Uploaded</th><th>Failed</th></tr>
+{{range .Containers}}<tr><td class="text">{{.Container}}</td><td>{{.Status}}</td><td>{{count .Files}}</td><td>{{bytes .Bytes}}</td><td>{{count .Downloaded}} ({{bytes .DownloadedBytes}})</td><td>{{count .Uploaded}} ({{bytes .UploadedBytes}})</td><td>{{count .Failed}}</td></tr>
+{{end}}</table>
+<h2>Top Errors</h2>
+<table>
+<tr><th class="text">Stage</th><th class="text">Pattern</th><th>Count</th></tr>
+{{range .TopErrors}}<tr><td class="text">{{.Stage}}</td><td class="text" title="{{.Example}}">{{.Pattern}}</td><td>{{count .Count}}</td></tr>
+{{end}}</table>
+<h2>Throughput</h2>
+<table>
+<tr><th class="text">Period</th><th class="text">Stage</th><th>Files</th><th>Bytes</th></tr>
+{{range .Throughput}}<tr><td class="text">{{.Period}}</td><td class="text">{{.Stage}}</td><td>{{count .Files}}</td><td>{{bytes .Bytes}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,29 @@
+// Namespace: report/main_test.go
+
+package report
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"../database" // DB Handler Package
+)
+
+func TestBuildRejectsPeriod(t *testing.T) {
+	for _, period := range []string{"", "days", "week"} {
+		if _, err := Build(period, 10); err == nil {
+			t.Errorf("Build(%q): no error", period)
+		}
+	}
+}
+
+func TestGroupErrorsTruncatesOnRune(t *testing.T) {
+	message := strings.Repeat("é", 200) // 400 byte(s): byte 300 is a rune start, 299 isn't
+	for _, prefix := range []string{"", "x"} {
+		grouped := groupErrors([]database.ErrorCount{{Stage: "azure", Message: prefix + message, Count: 1}}, 10)
+		if len(grouped) != 1 || !utf8.ValidString(grouped[0].Pattern) || len(grouped[0].Pattern) > 303 {
+			t.Errorf("pattern of %q...: %q", prefix, grouped[0].Pattern)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Namespace: retention/main.go
+
+package retention
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"../database" // DB Handler Package
+	"../helpers"  // Helper Package
+	"../logger"   // Leveled Logger
+
+	"code.cloudfoundry.org/bytefmt" // Byte Format
+)
+
+// Retention Mode(s): how long the local copy of an uploaded file is kept in the media folder
+const (
+	KeepAll   = "all"       // Never deleted (default)
+	Immediate = "immediate" // Deleted after a verified upload
+	LiveOnly  = "live"      // Kept for live container(s) only, other file(s) are deleted after a verified upload
+	KeepDays  = "days"      // Kept for Days after the upload, then deleted by prune
+)
+
+// Global Constant(s)
+const pruneBatchSize = 1000 // Uploaded row(s) per query
+
+// Policy - Retention policy of uploaded file(s)
+type Policy struct {
+	Mode string
+	Days int // KeepDays only
+}
+
+// Result - File(s) and bytes deleted (or which a dry run would delete) by Prune
+type Result struct {
+	Files, Bytes int64
+	Missing      int64 // Uploaded row(s) without local copy (already deleted)
+}
+
+// ParsePolicy - Parse retention policy: "all" (default), "immediate", "live" or a number of days such as "7d"
+func ParsePolicy(value string) (Policy, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "", KeepAll:
+		return Policy{Mode: KeepAll}, nil
+	case Immediate, LiveOnly:
+		return Policy{Mode: value}, nil
+	}
+
+	days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+	if !strings.HasSuffix(value, "d") || err != nil || days < 0 {
+		return Policy{}, fmt.Errorf("invalid retention policy %q (all, immediate, live or days such as 7d)", value)
+	} else if days == 0 {
+		return Policy{Mode: Immediate}, nil
+	}
+
+	return Policy{Mode: KeepDays, Days: days}, nil
+}
+
+// String - Policy as it is configured
+func (p Policy) String() string {
+	if p.Mode == KeepDays {
+		return strconv.Itoa(p.Days) + "d"
+	}
+
+	return p.Mode
+}
+
+// DeleteOnUpload - Check if the local copy is deleted as soon as its upload is verified
+func (p Policy) DeleteOnUpload(live bool) bool {
+	return p.Mode == Immediate || (p.Mode == LiveOnly && !live)
+}
+
+// Expired - Check if the local copy of a file uploaded at uploadedAt is past its retention
+// (zero uploadedAt: upload time unknown, only deleted when the policy doesn't depend on it)
+func (p Policy) Expired(live bool, uploadedAt time.Time, now time.Time) bool {
+	switch p.Mode {
+	case Immediate, LiveOnly:
+		return p.DeleteOnUpload(live)
+	case KeepDays:
+		return !uploadedAt.IsZero() && now.Sub(uploadedAt) >= time.Duration(p.Days)*24*time.Hour
+	}
+
+	return false
+}
+
+// Prune - Delete local copies of uploaded file(s) which are past the retention policy, the sync table
+// being the source of truth (file(s) without an uploaded row are never deleted; snapshotSuffix names snapshot files)
+func Prune(ctx context.Context, policy Policy, mediaFolder string, snapshotSuffix string, dryRun bool, log *logger.Logger) (Result, error) {
+	var result Result
+	if policy.Mode == KeepAll {
+		log.Info("Retention policy keeps every file, nothing to prune", logger.Fields{"policy": policy.String()})
+		return result, nil
+	}
+
+	now := time.Now()
+	afterID := int64(0)
+	for ctx.Err() == nil {
+		rows, err := database.GetUploadedContent(ctx, afterID, pruneBatchSize)
+		if err != nil {
+			return result, err
+		} else if len(rows) == 0 {
+			break
+		}
+
+		for _, row := range rows {
+			afterID = row.ID
+			if !policy.Expired(row.Live, parseTimestamp(row.UploadedAt), now) {
+				continue
+			}
+
+			pruneFile(row, mediaFolder, snapshotSuffix, dryRun, &result, log)
+		}
+	}
+
+	prefix := "Pruned "
+	if dryRun {
+		prefix = "[Dry Run] would prune "
+	}
+	log.Info(fmt.Sprintf("%s%s files (%s) from media folder", prefix, helpers.FormatCount(result.Files), bytefmt.ByteSize(uint64(result.Bytes))),
+		logger.Fields{"policy": policy.String(), "files": result.Files, "bytes": result.Bytes, "missing": result.Missing})
+
+	return result, ctx.Err()
+}
+
+// Delete local copy of an uploaded row
+//
+// @param row UploadedRow, mediaFolder string, snapshotSuffix string, dryRun boolean, result pointer, log Logger
+// @return nil
+func pruneFile(row database.UploadedRow, mediaFolder string, snapshotSuffix string, dryRun bool, result *Result, log *logger.Logger) {
+	fileName := helpers.LocalFileName(row.Container, row.Blob, row.Snapshot, snapshotSuffix)
+	fileLog := log.With(logger.Fields{"container": row.Container, "blob": row.Blob, "snapshot": row.Snapshot})
+
+	fileInfo, err := os.Stat(mediaFolder + fileName)
+	if os.IsNotExist(err) {
+		result.Missing++
+		return
+	} else if err != nil {
+		fileLog.Warn("Unable to check local copy", logger.Fields{"error": err})
+		return
+	}
+
+	if dryRun {
+		fileLog.Info("[Dry Run] would delete local copy", logger.Fields{"file": fileName, "bytes": fileInfo.Size()})
+	} else if err = os.Remove(mediaFolder + fileName); err != nil {
+		fileLog.Warn("Unable to delete local copy", logger.Fields{"file": fileName, "error": err})
+		return
+	} else {
+		fileLog.Debug("Local copy deleted", logger.Fields{"file": fileName, "bytes": fileInfo.Size()})
+	}
+
+	result.Files++
+	result.Bytes += fileInfo.Size()
+}
+
+// Parse timestamp of the sync table ("2006-01-02 15:04:05..." in local time, offset included by the DB driver)
+//
+// @param value string
+// @return time (zero: empty or unknown format)
+func parseTimestamp(value string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05.999999999Z07:00", time.RFC3339Nano} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed
+		}
+	}
+
+	if len(value) < 19 {
+		return time.Time{}
+	}
+
+	parsed, _ := time.ParseInLocation("2006-01-02 15:04:05", strings.Replace(value[:19], "T", " ", 1), time.Local)
+	return parsed
+}
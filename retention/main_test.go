@@ -0,0 +1,100 @@
+// Namespace: retention/main_test.go
+
+package retention
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"../database"    // DB Handler Package
+	"../fakestorage" // Fake Blob / S3 Service(s) (test DB)
+	"../helpers"     // Helper Package
+)
+
+func TestParsePolicy(t *testing.T) {
+	cases := map[string]Policy{"": {Mode: KeepAll}, "all": {Mode: KeepAll}, " Immediate ": {Mode: Immediate}, "live": {Mode: LiveOnly},
+		"7d": {Mode: KeepDays, Days: 7}, "0d": {Mode: Immediate}}
+	for value, want := range cases {
+		if policy, err := ParsePolicy(value); err != nil || policy != want {
+			t.Errorf("ParsePolicy(%q) = %+v, %v; want %+v", value, policy, err, want)
+		}
+	}
+
+	for _, value := range []string{"7", "-1d", "weekly", "d"} {
+		if _, err := ParsePolicy(value); err == nil {
+			t.Errorf("ParsePolicy(%q): no error", value)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+	week := Policy{Mode: KeepDays, Days: 7}
+	if week.Expired(false, now.Add(-6*24*time.Hour), now) || !week.Expired(true, now.Add(-7*24*time.Hour), now) || week.Expired(false, time.Time{}, now) {
+		t.Error("7d: expired after 7 days only, never with unknown upload time")
+	}
+
+	live := Policy{Mode: LiveOnly}
+	if live.Expired(true, now, now) || !live.Expired(false, time.Time{}, now) {
+		t.Error("live: only file(s) of live containers kept")
+	}
+	if (Policy{Mode: KeepAll}).Expired(false, now.AddDate(-1, 0, 0), now) {
+		t.Error("all: expired")
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2020, 5, 10, 12, 30, 0, 0, time.FixedZone("", 2*3600))
+	for _, value := range []string{"2020-05-10 12:30:00.123+02:00", "2020-05-10T12:30:00+02:00"} {
+		if parsed := parseTimestamp(value); !parsed.Truncate(time.Second).Equal(want) {
+			t.Errorf("parseTimestamp(%q) = %v", value, parsed)
+		}
+	}
+	if !parseTimestamp("").IsZero() {
+		t.Error("empty timestamp: not zero")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	fakestorage.OpenDB(t)
+	mediaFolder := t.TempDir() + "/"
+
+	// Uploaded a.mp4, reports/c.pdf (live), missing.mp4 (already deleted); b.mp4 only downloaded
+	for _, row := range []database.SyncRow{{Container: "media", Blob: "a.mp4"}, {Container: "media", Blob: "b.mp4"},
+		{Container: "reports", Blob: "c.pdf"}, {Container: "media", Blob: "missing.mp4"}} {
+		content := []byte("12345")
+		if row.Blob == "missing.mp4" {
+			content = nil
+		}
+		fakestorage.AddDownloaded(t, mediaFolder, helpers.DefaultSnapshotSuffix, row, content)
+		if row.Blob != "b.mp4" {
+			if err := database.SetS3Flag(row.Container, row.Blob, "", 1, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Uploaded just now: kept for 7 days
+	if result, err := Prune(context.Background(), Policy{Mode: KeepDays, Days: 7}, mediaFolder, helpers.DefaultSnapshotSuffix, false, fakestorage.Logger(t)); err != nil || result.Files != 0 {
+		t.Fatalf("7d prune = %+v, %v", result, err)
+	}
+
+	result, err := Prune(context.Background(), Policy{Mode: LiveOnly}, mediaFolder, helpers.DefaultSnapshotSuffix, true, fakestorage.Logger(t))
+	if err != nil || result != (Result{Files: 1, Bytes: 5, Missing: 1}) {
+		t.Fatalf("dry run prune = %+v, %v", result, err)
+	}
+	if _, err := os.Stat(mediaFolder + "a.mp4"); err != nil {
+		t.Fatal("dry run deleted a.mp4")
+	}
+
+	if result, err = Prune(context.Background(), Policy{Mode: LiveOnly}, mediaFolder, helpers.DefaultSnapshotSuffix, false, fakestorage.Logger(t)); err != nil || result.Files != 1 {
+		t.Fatalf("prune = %+v, %v", result, err)
+	}
+	for blob, kept := range map[string]bool{"a.mp4": false, "b.mp4": true, "c.pdf": true} {
+		if _, err := os.Stat(mediaFolder + blob); (err == nil) != kept {
+			t.Errorf("%s: kept = %v, want %v", blob, err == nil, kept)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Namespace: snowball/main.go
+
+package snowball
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+
+	"../database"  // DB Handler Package
+	"../failures"  // Run Error Summary
+	"../helpers"   // Helper Package
+	"../logger"    // Leveled Logger
+	"../metrics"   // Prometheus Metrics
+	"../upload/s3" // S3 Key Mapping
+
+	"code.cloudfoundry.org/bytefmt"              // Byte Format
+	"github.com/aws/aws-sdk-go/aws"              // AWS Core SDK
+	"github.com/aws/aws-sdk-go/aws/awserr"       // AWS Error(s)
+	s3api "github.com/aws/aws-sdk-go/service/s3" // AWS S3 API (Head Object)
+)
+
+// Global Constant(s)
+const batchSize = 100 // Row(s) per query (export: marked shipped once their manifest line(s) are written)
+
+// Options - Snowball job setting(s)
+type Options struct {
+	Job       string
+	Container string     // Export: only this container (empty: any)
+	Capacity  int64      // Export: max. bytes on the device (0: unlimited)
+	Format    string     // Manifest: "csv" or "jsonl"
+	Upload    s3.EnvVars // Media folder, key mapping and bucket of the upload stage
+	DryRun    bool
+	Log       *logger.Logger
+}
+
+// Entry - Manifest line: local file and the S3 key the Snowball import creates
+type Entry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Key    string `json:"key"`
+}
+
+// Result - Outcome of an export (Files / Bytes) or a reconcile (Files / Bytes confirmed in S3)
+type Result struct {
+	Files, Bytes int64
+	Missing      int64 // Reconcile: not in S3, returned to the upload queue
+	Mismatched   int64 // Reconcile: in S3 with another size, flagged failed
+}
+
+// Export - Write manifest of downloaded file(s) pending upload for a Snowball job, and mark their rows as
+// shipped, so that upload skips them (row(s) already on the job are listed again: a re-run rewrites the manifest)
+func Export(ctx context.Context, w io.Writer, opts Options) (Result, error) {
+	var result Result
+	if len(opts.Job) == 0 {
+		return result, errors.New("snowball job ID is missing")
+	}
+	if opts.Upload.SnapshotMode == s3.SnapshotVersions {
+		return result, errors.New("snowball import can't create object versions oldest first, use S3_SNAPSHOT_MODE=suffix")
+	}
+
+	if opts.DryRun {
+		w = ioutil.Discard // File(s) aren't hashed
+	}
+	manifest, err := newManifestWriter(w, opts.Format)
+	if err != nil {
+		return result, err
+	}
+
+	afterID, deviceFull := int64(0), false
+	for ctx.Err() == nil && !deviceFull {
+		rows, err := database.GetShippableContent(ctx, opts.Job, opts.Container, afterID, batchSize)
+		if err != nil {
+			return result, err
+		} else if len(rows) == 0 {
+			break
+		}
+
+		var entries []Entry
+		var ids []int64
+		for _, row := range rows {
+			afterID = row.ID
+			entry, err := fileEntry(ctx, row, opts)
+			if err != nil && ctx.Err() != nil {
+				break // Interrupted: batch isn't marked
+			} else if err != nil {
+				opts.Log.Warn("[Snowball] Skipping file", logger.Fields{"container": row.Container, "blob": row.Blob, "snapshot": row.Snapshot, "error": err})
+				failures.Record(metrics.StageUpload, row.Container, row.Blob, err)
+				continue
+			}
+
+			if opts.Capacity != 0 && result.Bytes+entry.Size > opts.Capacity {
+				opts.Log.Info("[Snowball] Device capacity reached", logger.Fields{"capacity": opts.Capacity})
+				deviceFull = true
+				break
+			}
+
+			entries = append(entries, entry)
+			ids = append(ids, row.ID)
+			result.Files++
+			result.Bytes += entry.Size
+		}
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		// Manifest Line(s) first: a marked row is always on the manifest
+		if err := manifest.write(entries); err != nil {
+			return result, fmt.Errorf("write manifest: %v", err)
+		}
+		if _, err := database.MarkShipped(opts.Job, ids); err != nil {
+			return result, err
+		}
+	}
+
+	prefix := "[Snowball] Exported "
+	if opts.DryRun {
+		prefix = "[Dry Run] would export "
+	}
+	opts.Log.Info(fmt.Sprintf("%s%s files (%s) for job %s", prefix, helpers.FormatCount(result.Files), bytefmt.ByteSize(uint64(result.Bytes)), opts.Job),
+		logger.Fields{"job": opts.Job, "files": result.Files, "bytes": result.Bytes})
+
+	return result, ctx.Err()
+}
+
+// Reconcile - Confirm that the shipped row(s) of a Snowball job landed in S3: an object of the local size
+// completes the upload, a missing object returns the row to the upload queue, another size flags it failed
+func Reconcile(ctx context.Context, opts Options) (Result, error) {
+	var result Result
+	if len(opts.Job) == 0 {
+		return result, errors.New("snowball job ID is missing")
+	}
+
+	sess, err := opts.Upload.S3.Session(metrics.Transport)
+	if err != nil {
+		return result, err
+	}
+	client := s3api.New(sess)
+
+	afterID := int64(0)
+	for ctx.Err() == nil {
+		rows, err := database.GetShippedContent(ctx, opts.Job, afterID, batchSize)
+		if err != nil {
+			return result, err
+		} else if len(rows) == 0 {
+			break
+		}
+
+		for _, row := range rows {
+			afterID = row.ID
+			if err := reconcileRow(ctx, client, row, opts, &result); err != nil && ctx.Err() == nil {
+				opts.Log.Error("[Snowball] Reconcile Failed", logger.Fields{"container": row.Container, "blob": row.Blob, "snapshot": row.Snapshot, "error": err})
+				failures.Record(metrics.StageUpload, row.Container, row.Blob, err)
+			}
+		}
+	}
+
+	opts.Log.Info(fmt.Sprintf("[Snowball] %s files (%s) of job %s confirmed in S3", helpers.FormatCount(result.Files), bytefmt.ByteSize(uint64(result.Bytes)), opts.Job),
+		logger.Fields{"job": opts.Job, "files": result.Files, "bytes": result.Bytes, "missing": result.Missing, "mismatched": result.Mismatched})
+
+	return result, ctx.Err()
+}
+
+// Compare the object of a shipped row with the local size and update the row
+//
+// @param ctx Context, client S3 API, row ShipRow, opts Options, result pointer
+// @return error (object couldn't be checked, or the row updated)
+func reconcileRow(ctx context.Context, client *s3api.S3, row database.ShipRow, opts Options, result *Result) error {
+	key := s3.ObjectKey(row.Container, row.Blob, row.Snapshot, opts.Upload)
+	log := opts.Log.With(logger.Fields{"container": row.Container, "blob": row.Blob, "snapshot": row.Snapshot, "key": key})
+
+	head, headErr := client.HeadObjectWithContext(ctx, &s3api.HeadObjectInput{Bucket: aws.String(opts.Upload.S3.Bucket), Key: aws.String(key)})
+	var requestErr awserr.RequestFailure
+	if errors.As(headErr, &requestErr) && requestErr.StatusCode() == http.StatusNotFound {
+		log.Warn("[Snowball] Object missing, returned to upload queue")
+		result.Missing++
+		return database.Unship(row.Container, row.Blob, row.Snapshot)
+	} else if headErr != nil {
+		return headErr
+	}
+
+	// Size captured by the sync (older row(s): size of the local copy)
+	if fileInfo, statErr := os.Stat(opts.Upload.MediaFolder + localFileName(row, opts)); row.Size == 0 && statErr == nil {
+		row.Size = fileInfo.Size()
+	}
+
+	if size := aws.Int64Value(head.ContentLength); size != row.Size {
+		log.Error("[Snowball] Object size differs", logger.Fields{"bytes": row.Size, "object_bytes": size})
+		result.Mismatched++
+		return database.SetS3Flag(row.Container, row.Blob, row.Snapshot, 2, fmt.Sprintf("snowball job %s: object size %d, expected %d", opts.Job, size, row.Size))
+	}
+
+	log.Debug("[Snowball] Object confirmed")
+	result.Files++
+	result.Bytes += row.Size
+	return database.SetS3Flag(row.Container, row.Blob, row.Snapshot, 1, "")
+}
+
+// Manifest line of a row: local file, its size and SHA-256 (not read for a dry run), and its S3 key
+//
+// @param ctx Context, row ShipRow, opts Options
+// @return Entry, error
+func fileEntry(ctx context.Context, row database.ShipRow, opts Options) (Entry, error) {
+	entry := Entry{
+		Path: opts.Upload.MediaFolder + localFileName(row, opts),
+		Key:  s3.ObjectKey(row.Container, row.Blob, row.Snapshot, opts.Upload),
+	}
+
+	file, err := os.Open(entry.Path)
+	if err != nil {
+		return entry, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return entry, err
+	}
+	entry.Size = fileInfo.Size()
+	if opts.DryRun {
+		return entry, nil
+	}
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, contextReader{ctx, file}); err != nil {
+		return entry, err
+	}
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	return entry, nil
+}
+
+// Downloaded file of a row (configured snapshot suffix)
+//
+// @param row ShipRow, opts Options
+// @return string
+func localFileName(row database.ShipRow, opts Options) string {
+	return helpers.LocalFileName(row.Container, row.Blob, row.Snapshot, opts.Upload.SnapshotSuffix)
+}
+
+// Reader which stops once the context is cancelled (hashing of a large file)
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read - Read from the underlying reader unless the context is cancelled
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return c.r.Read(p)
+}
+
+// Manifest in "csv" (with header) or "jsonl"
+type manifestWriter struct {
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+// New manifest writer, the CSV header being written at once
+//
+// @param w Writer, format string
+// @return manifestWriter pointer, error
+func newManifestWriter(w io.Writer, format string) (*manifestWriter, error) {
+	switch format {
+	case "csv":
+		csvWriter := csv.NewWriter(w)
+		csvWriter.Write([]string{"path", "size", "sha256", "key"})
+		return &manifestWriter{csv: csvWriter}, nil
+	case "jsonl":
+		return &manifestWriter{json: json.NewEncoder(w)}, nil
+	}
+
+	return nil, fmt.Errorf("invalid manifest format %q (csv, jsonl)", format)
+}
+
+// Write and flush manifest line(s)
+//
+// @param entries Entry slice
+// @return error
+func (m *manifestWriter) write(entries []Entry) error {
+	for _, entry := range entries {
+		if m.json != nil {
+			if err := m.json.Encode(entry); err != nil {
+				return err
+			}
+			continue
+		}
+
+		m.csv.Write([]string{entry.Path, strconv.FormatInt(entry.Size, 10), entry.SHA256, entry.Key})
+	}
+
+	if m.csv != nil {
+		m.csv.Flush()
+		return m.csv.Error()
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+// Namespace: snowball/main_test.go
+
+package snowball
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"../database"    // DB Handler Package
+	"../endpoint"    // S3 Endpoint
+	"../fakestorage" // Fake Blob / S3 Service(s)
+	"../helpers"     // Helper Package
+	"../upload/s3"   // S3 Key Mapping
+)
+
+// Fresh DB with downloaded row(s) and their file(s) in the media folder (size: file content)
+func setupSnowball(t *testing.T, files map[string]string) Options {
+	t.Helper()
+	fakestorage.OpenDB(t)
+
+	opts := Options{Job: "JID-1", Format: "csv", Log: fakestorage.Logger(t),
+		Upload: s3.EnvVars{S3: endpoint.S3{Bucket: "bucket", Region: "us-east-1"}, MediaFolder: t.TempDir() + "/",
+			SnapshotMode: s3.SnapshotSuffix, SnapshotSuffix: helpers.DefaultSnapshotSuffix}}
+
+	blobs := make([]string, 0, len(files)) // Row id(s) in name order: the export order
+	for blob := range files {
+		blobs = append(blobs, blob)
+	}
+	sort.Strings(blobs)
+
+	for _, blob := range blobs {
+		content := files[blob]
+		row := database.SyncRow{Container: "media", Blob: blob, Properties: database.BlobProperties{Size: int64(len(content))}}
+		fakestorage.AddDownloaded(t, opts.Upload.MediaFolder, opts.Upload.SnapshotSuffix, row, []byte(content))
+	}
+
+	return opts
+}
+
+func TestExport(t *testing.T) {
+	opts := setupSnowball(t, map[string]string{"a.mp4": "abc", "b.mp4": "defg"})
+	database.InsertBlobs([]database.SyncRow{{Container: "media", Blob: "pending.mp4"}}) // Not downloaded
+
+	var manifest bytes.Buffer
+	result, err := Export(context.Background(), &manifest, opts)
+	if err != nil || result.Files != 2 || result.Bytes != 7 {
+		t.Fatalf("export = %+v, %v", result, err)
+	}
+
+	records, err := csv.NewReader(&manifest).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("manifest = %v, %v", records, err)
+	}
+	want := []string{opts.Upload.MediaFolder + "a.mp4", "3", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "a.mp4"}
+	if records[1][0] != want[0] || records[1][1] != want[1] || records[1][2] != want[2] || records[1][3] != want[3] {
+		t.Errorf("manifest line = %v, want %v", records[1], want)
+	}
+
+	// Shipped row(s) are skipped by upload
+	if claimed, err := database.ClaimS3Content(context.Background(), 10, false); err != nil || len(claimed) != 0 {
+		t.Errorf("claimed after export = %v, %v", claimed, err)
+	}
+
+	// Full device: nothing more fits
+	opts.Job, opts.Capacity = "JID-2", 1
+	if result, err = Export(context.Background(), &manifest, opts); err != nil || result.Files != 0 {
+		t.Errorf("second job export = %+v, %v", result, err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	fake := fakestorage.NewS3()
+	t.Cleanup(fake.Close)
+	fake.CreateBucket("bucket", false)
+
+	opts := setupSnowball(t, map[string]string{"landed.mp4": "abc", "truncated.mp4": "defg", "lost.mp4": "h"})
+	opts.Upload.S3.Endpoint, opts.Upload.S3.PathStyle = fake.URL, true
+	if _, err := Export(context.Background(), ioutil.Discard, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Snowball import: lost.mp4 never made it
+	fake.PutObject("bucket", "landed.mp4", fakestorage.Object{Content: []byte("abc")})
+	fake.PutObject("bucket", "truncated.mp4", fakestorage.Object{Content: []byte("de")})
+
+	result, err := Reconcile(context.Background(), opts)
+	if err != nil || result.Files != 1 || result.Missing != 1 || result.Mismatched != 1 {
+		t.Fatalf("reconcile = %+v, %v", result, err)
+	}
+
+	for status, blob := range map[string]string{"completed": "landed.mp4", "failed": "truncated.mp4"} {
+		if items, _, _ := database.GetSyncItems(status, "s3", 0, 10); len(items) != 1 || items[0].Blob != blob {
+			t.Errorf("%s rows = %+v, want %s", status, items, blob)
+		}
+	}
+
+	// Missing object: uploaded over the network again
+	claimed, err := database.ClaimS3Content(context.Background(), 10, false)
+	if err != nil || len(claimed) != 1 || claimed[0]["blob"] != "lost.mp4" {
+		t.Errorf("claimed after reconcile = %v, %v", claimed, err)
+	}
+}
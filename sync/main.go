@@ -4,20 +4,21 @@ package sync
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
-	"log"
-	"net/url"
 	"path/filepath"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"../api"      // Status API
 	"../database" // DB Handler Package
+	"../endpoint" // Azure Endpoint
+	"../failures" // Run Error Summary
 	"../helpers"  // Helper Package
+	"../logger"   // Leveled Logger
+	"../metrics"  // Prometheus Metrics
 
 	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob" // Azure Blob Package
-	_ "github.com/mattn/go-sqlite3"                            // SQLite3 Connection
 )
 
 // Global Constant(s)
@@ -26,53 +27,83 @@ const (
 	blobNotFound      = 503
 	traverseCompleted = 200
 	liveContainer     = 100
+	listPageSize      = 5000 // Blob(s) per ListBlobs call (Azure maximum), inserted in one batch
 )
 
 // Global Variable(s)
 var fileExceptionList = [3]string{".xml", ".ism", ".ismc"} // File extension which needs to be skipped
-var containerExceptionList = [15]string{
-	"test", "dummy", "others"} // Containers which needs to be skipped
-var liveContainerList = [16]string{
-	"employees-data", "employees-indentity",
-	"videos", "images", "reports"} // Containers which are live and gets updated frequently
+var containerExceptionList map[string]bool                 // Containers which needs to be skipped (exclude list, loaded by Run)
+var liveContainerList map[string]bool                      // Containers which are live and gets updated frequently (live list, loaded by Run)
+
+var dryRunRows int64 // Sync rows which a dry run would insert
 
 // EnvVars Struct
 type EnvVars struct {
-	AccountName, AccountKey string
+	Azure                   endpoint.Azure // Storage Account Endpoint / Credential
 	ContainerFlag, BlobFlag bool
+	ContainerName           string           // Only traverse this container (-blob)
+	Prefix, Delimiter       string           // Command line scope, see containerScope
+	Scopes                  map[string]Scope // Per-container include / exclude prefix(es) (LoadScopes)
+	Snapshots               bool             // Record blob snapshot(s) as row(s) linked to their base blob
+	DryRun                  bool             // Report DB write(s) without executing them
+	Log                     *logger.Logger   // Leveled Logger
 }
 
-// Handling Error
-func handleErrors(err error, asset string) {
-	if err != nil {
-		log.Print("Container: ", asset)
-		log.Fatal(err)
-
-	}
+// Handling Error: logged and recorded for the run summary, the run goes on
+func handleErrors(env EnvVars, err error, container string, reason string) {
+	env.Log.Error(reason, logger.Fields{"container": container, "error": err})
+	failures.Record(metrics.StageSync, container, "", err)
 }
 
 // Rescue Operation
-func rescue() {
+func rescue(env EnvVars) {
 	r := recover() // We stopped here the failing process!!!
 	if r != nil {
-		fmt.Println("Panic has been recover")
+		env.Log.Error("Panic has been recover", logger.Fields{"panic": r})
 	}
 }
 
 // Run - Method to display the specified resource.
 //
-// @param env EnvVars struct
+// @param ctx Context (cancelled on interrupt), env EnvVars struct
 // @return boolean
-func Run(env EnvVars) bool {
-	fmt.Printf("Azure Container: Blob Sync Mechanism.\n")
-	// defer rescue()
+func Run(ctx context.Context, env EnvVars) bool {
+	env.Log.Info("Azure Container: Blob Sync Mechanism.")
+	// defer rescue(env)
+
+	// Live / Excluded Container(s) of the registry
+	var listErr error
+	if liveContainerList, listErr = database.RegistrySet(database.RegistryLive); listErr == nil {
+		containerExceptionList, listErr = database.RegistrySet(database.RegistryExclude)
+	}
+	if listErr != nil {
+		handleErrors(env, listErr, "", "Container Registry Load Failed")
+		return false
+	}
 
 	if env.ContainerFlag { // Sync Container(s)
-		syncContainer(env)
+		if err := syncContainer(ctx, env); err != nil {
+			return false
+		}
 	} else if env.BlobFlag { // Sync Container: Blob Mapping
-		syncBlob(env)
+		if err := database.ResetContainerErrors(); err != nil { // Retrying container(s) which failed last run
+			handleErrors(env, err, "", "Container Error Reset Failed")
+			return false
+		}
+
+		if len(env.ContainerName) != 0 { // Single Container, whatever its status
+			var wg sync.WaitGroup
+			wg.Add(1)
+			traverseContainerWorker(ctx, &wg, env.ContainerName, env)
+		} else if err := syncBlob(ctx, env); err != nil {
+			return false
+		}
+
+		if env.DryRun {
+			env.Log.Info(fmt.Sprintf("[Dry Run] would insert %s sync rows", helpers.FormatCount(atomic.LoadInt64(&dryRunRows))))
+		}
 	} else { // Flag was missing.
-		fmt.Println("Sync: Flag is Missing!")
+		env.Log.Error("Sync: Flag is Missing!")
 		return false
 	}
 
@@ -81,58 +112,107 @@ func Run(env EnvVars) bool {
 
 // Sync Azure Container(s) to SQLite "containers" table
 //
-// @param env EnvVars struct
-// @return boolean
-func syncContainer(env EnvVars) {
-	fmt.Println("Setting Up Container(s)")
-
-	// Create a default request pipeline using your storage account name and account key.
-	azurePipeline := azblob.NewPipeline(azblob.NewSharedKeyCredential(env.AccountName, env.AccountKey), azblob.PipelineOptions{})
-
-	// From the Azure portal, get your storage account blob service URL endpoint.
-	azureURL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net", env.AccountName))
+// @param ctx Context, env EnvVars struct
+// @return error (listing failed)
+func syncContainer(ctx context.Context, env EnvVars) error {
+	env.Log.Info("Setting Up Container(s)")
 
-	// Create a ContainerURL object that wraps the container URL and a request
+	// Create a ServiceURL object that wraps the storage account blob service URL and a request
 	// pipeline to make requests.
-	serviceURL := azblob.NewServiceURL(*azureURL, azurePipeline)
+	serviceURL := env.Azure.ServiceURL()
 
 	// List the container(s)
-	containerCounter := 1
+	containerCounter, newContainers := 1, 0
 	for containerMarker := (azblob.Marker{}); containerMarker.NotDone(); {
-		listContainer, err := serviceURL.ListContainers(context.Background(), containerMarker, azblob.ListContainersOptions{})
-		handleErrors(err, "Container Listing API Failed!")
+		listContainer, err := serviceURL.ListContainers(ctx, containerMarker, azblob.ListContainersOptions{})
+		if ctx.Err() != nil {
+			env.Log.Warn("Container Listing Interrupted")
+			return nil
+		} else if err != nil {
+			handleErrors(env, err, "", "Container Listing API Failed!")
+			return err
+		}
 
 		// Saving Container Details
 		for _, containerObject := range listContainer.Containers {
 			if isValidContainer(containerExceptionList, containerObject.Name) {
-				fmt.Printf("[%d]. Container: %s\n", containerCounter, containerObject.Name)
-				database.InsertInContainer(containerObject.Name)
+				env.Log.Info("Container Found", logger.Fields{"container": containerObject.Name, "counter": containerCounter})
+				if !env.DryRun {
+					if insertErr := database.InsertInContainer(containerObject.Name); insertErr != nil {
+						handleErrors(env, insertErr, containerObject.Name, "Container Insert Failed")
+					}
+				} else if !database.ContainerExists(containerObject.Name) {
+					database.InsertInContainer(containerObject.Name) // Reports only
+					newContainers++
+				}
 				containerCounter++ // Increment Counter
 			} else {
-				fmt.Println("Skipping: ", containerObject.Name)
+				env.Log.Info("Skipping Container", logger.Fields{"container": containerObject.Name})
 			}
 		}
 
 		/* Sleep after every API request */
-		fmt.Println("Sleep...")
-		sleepFor(5) // 5 Sec Halt b/w API Call(s)
-		fmt.Println("Woken...")
+		env.Log.Debug("Sleep...")
+		sleepFor(ctx, 5) // 5 Sec Halt b/w API Call(s)
+		env.Log.Debug("Woken...")
 		/* Sleep after every API request */
 
 		// ListContainers returns the start of the next segment; you MUST use this to get
 		// the next segment (after processing the current result segment).
 		containerMarker = listContainer.NextMarker
 	}
+
+	if env.DryRun {
+		env.Log.Info(fmt.Sprintf("[Dry Run] would insert %s container rows", helpers.FormatCount(int64(newContainers))))
+	}
+
+	return nil
 }
 
 // Sync Azure Container(s): Blob Mapping in SQLite "sync" table
+// (each pending container is traversed once per run: a dry run or a -prefix run leaves it pending, so the
+// listing pages past the container(s) already traversed)
 //
+// @param ctx Context
 // @param env EnvVars struct
-// @return boolean
-func syncBlob(env EnvVars) {
-	// Getting Container Listing
-	containers := database.GetPendingContainer()
+// @return error (container listing failed)
+func syncBlob(ctx context.Context, env EnvVars) error {
+	traversed := map[string]bool{} // Container(s) of this run
+
+	for offset := 0; ctx.Err() == nil; {
+		// Getting Container Listing
+		containers, err := database.GetPendingContainer(ctx, offset)
+		if err != nil {
+			handleErrors(env, err, "", "Container Listing Failed")
+			return err
+		} else if len(containers) == 0 {
+			return nil
+		}
+
+		var pending []string
+		for _, containerName := range containers {
+			if !traversed[containerName] {
+				traversed[containerName] = true
+				pending = append(pending, containerName)
+			}
+		}
+		if len(pending) == 0 { // Whole page traversed earlier in this run, still pending
+			offset += len(containers)
+			continue
+		}
+
+		traverseContainers(ctx, pending, env)
+		env.Log.Info("Fetching New Data...")
+	}
 
+	return nil
+}
+
+// Traverse container(s), 10x10 Matrix at a time
+//
+// @param ctx Context, containers slice, env EnvVars struct
+// @return nil
+func traverseContainers(ctx context.Context, containers []string, env EnvVars) {
 	// Converting Data Slice to 2D Matrix Slice
 	containerMatrix := helpers.CreateContainerMatrix(containers)
 
@@ -140,41 +220,32 @@ func syncBlob(env EnvVars) {
 	containerChannel := make(chan int)
 
 	// Processing 10x10 Matrix (row level)
-	for idx := 0; idx < len(containerMatrix); idx++ {
-		fmt.Println("Starting Container Set:")
-		fmt.Println("Channel Set [#", idx, "]: ", containerMatrix[idx])
+	for idx := 0; idx < len(containerMatrix) && ctx.Err() == nil; idx++ {
+		env.Log.Info("Starting Container Set", logger.Fields{"set": idx, "containers": len(containerMatrix[idx])})
 
-		go traverseContainerSet(containerChannel, containerMatrix[idx], env)
+		go traverseContainerSet(ctx, containerChannel, containerMatrix[idx], env)
 		<-containerChannel // Channel to mark Matrix row completed
 
-		fmt.Println("Completed Container Set.")
-	}
-
-	// Recursion Implementation:
-	if len(containers) != 0 {
-		fmt.Println("[Recursion] Fetching New Data...")
-		syncBlob(env)
+		env.Log.Info("Completed Container Set.", logger.Fields{"set": idx})
 	}
 }
 
 // Traverse Container Set of 10x10 Matrix
 //
+// @param ctx Context
 // @param containerChannel chan
 // @param containerSet slice
 // @param env EnvVars struct
 // @return channel finished
-func traverseContainerSet(containerChannel chan<- int, containerSet []string, env EnvVars) {
+func traverseContainerSet(ctx context.Context, containerChannel chan<- int, containerSet []string, env EnvVars) {
 	var wg sync.WaitGroup // Checks if traversing gets completed.
 
-	dbConnection := database.InitConnection()
-	defer dbConnection.Close()
-
 	// Processing Single Row of Channel Set
 	for idx := 0; idx < len(containerSet); idx++ {
-		fmt.Println("Starting Channel [#", idx, "]: ", containerSet[idx])
+		env.Log.Debug("Starting Channel", logger.Fields{"channel": idx, "container": containerSet[idx]})
 		wg.Add(1)
 
-		go traverseContainerWorker(&wg, containerSet[idx], dbConnection, env)
+		go traverseContainerWorker(ctx, &wg, containerSet[idx], env)
 	}
 
 	// Waiting for container to finish.
@@ -185,99 +256,149 @@ func traverseContainerSet(containerChannel chan<- int, containerSet []string, en
 }
 
 // Worker to traverse container and save blob details
+// (an interrupted container keeps status 0, so the next run traverses it again)
 //
+// @param ctx Context
 // @param wg WaitGroup
 // @param containerName string
-// @param dbConnection pointer
 // @param env EnvVars struct
 // @return channel finished
-func traverseContainerWorker(wg *sync.WaitGroup, containerName string, dbConnection *sql.DB, env EnvVars) {
+func traverseContainerWorker(ctx context.Context, wg *sync.WaitGroup, containerName string, env EnvVars) {
 	defer wg.Done() // Work Completed
 
+	metrics.InFlightWorkers.WithLabelValues(metrics.StageSync).Inc()
+	defer metrics.InFlightWorkers.WithLabelValues(metrics.StageSync).Dec()
+
 	// Default Variable(s)
 	var updateErr error
 	var fileCount = 0
+	var skippedCount = 0 // Listed, but skipped by extension / scope
+	var newRows int64
+	var mappingErr error // Last failed blob insert
 	var containerStatus = 0
+	var startTime = time.Now()
+	var log = env.Log.With(logger.Fields{"container": containerName})
+	var scope = containerScope(env, containerName)
 
-	// Create a default request pipeline using your storage account name and account key.
-	azurePipeline := azblob.NewPipeline(azblob.NewSharedKeyCredential(env.AccountName, env.AccountKey), azblob.PipelineOptions{})
+	transfer := api.StartTransfer(metrics.StageSync, containerName, "")
+	defer transfer.Done()
 
-	// Configuring Azure Container Details API
-	containerURL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", env.AccountName, containerName))
+	// Azure can't list snapshot(s) by virtual directory: listing flat, excluded directories are filtered by name
+	if env.Snapshots && len(scope.Delimiter) != 0 {
+		log.Warn("Snapshots are listed without delimiter", logger.Fields{"delimiter": scope.Delimiter})
+		scope.Delimiter = ""
+	}
 
 	// Initializing Azure Container Details API
-	fmt.Printf("Container: %s\n", containerName)
-	containerServiceURL := azblob.NewContainerURL(*containerURL, azurePipeline)
+	log.Info("Traversing Container", logger.Fields{"include": scope.Include, "exclude": scope.Exclude, "delimiter": scope.Delimiter, "snapshots": env.Snapshots})
+	containerServiceURL := env.Azure.ServiceURL().NewContainerURL(containerName)
+
+	// Container to Blob Listing, one prefix (virtual directory) at a time
+	prefixQueue := scope.prefixes()
+	for len(prefixQueue) != 0 {
+		prefix := prefixQueue[0]
+		prefixQueue = prefixQueue[1:]
+
+		for blobMarker := (azblob.Marker{}); blobMarker.NotDone(); {
+			// Get a result segment starting with the blob indicated by the current Marker.
+			// (snapshot(s) of a blob are listed right before it, oldest first)
+			listOptions := azblob.ListBlobsOptions{Prefix: prefix, Delimiter: scope.Delimiter, MaxResults: listPageSize,
+				Details: azblob.BlobListingDetails{Metadata: true, Snapshots: env.Snapshots}}
+			listBlob, err := containerServiceURL.ListBlobs(ctx, blobMarker, listOptions)
+
+			// Interrupted: Leaving Container Status Untouched
+			if ctx.Err() != nil {
+				log.Warn("Traversing Interrupted", logger.Fields{"blobs": fileCount, "duration": time.Since(startTime)})
+				return
+			}
 
-	// Container to Blob Listing
-	for blobMarker := (azblob.Marker{}); blobMarker.NotDone(); {
-		// Get a result segment starting with the blob indicated by the current Marker.
-		listBlob, err := containerServiceURL.ListBlobs(context.Background(), blobMarker, azblob.ListBlobsOptions{MaxResults: 20})
+			// Azure Specific Error Handling
+			if err != nil {
+				if serr, ok := err.(azblob.StorageError); ok { // This error is a Service-specific
+					if serr.ServiceCode() == azblob.ServiceCodeContainerNotFound { // Compare serviceCode to ServiceCodeXxx constants
+						log.Warn("Container not found!")
 
-		// Azure Specific Error Handling
-		if err != nil {
-			if serr, ok := err.(azblob.StorageError); ok { // This error is a Service-specific
-				if serr.ServiceCode() == azblob.ServiceCodeContainerNotFound { // Compare serviceCode to ServiceCodeXxx constants
-					fmt.Println("Container: ", containerName, " not found!")
-					// Updating status flag in containers table
-					updateContainerQuery, _ := dbConnection.Prepare("UPDATE containers SET status = ? WHERE name = ?")
-					containerStatus = containerNotFound
-					_, updateErr = updateContainerQuery.Exec(containerStatus, containerName)
-
-					if updateErr != nil {
-						fmt.Println("[Failed] Setting Completed Flag For: ", containerName)
-						fmt.Println("[Reason] Container Not Found")
+						// Updating status flag in containers table
+						if updateErr = database.SetContainerStatus(containerName, containerNotFound); updateErr != nil {
+							log.Error("[Failed] Setting Completed Flag", logger.Fields{"reason": "Container Not Found", "error": updateErr})
+							failures.Record(metrics.StageSync, containerName, "", updateErr)
+						}
+
+						return
 					}
+				}
 
-					break // Skips Loop
+				// Any other Error: Container is left pending (with error) for the next run
+				handleErrors(env, err, containerName, "Blob Listing Failed")
+				if updateErr = database.SetContainerError(containerName, err.Error()); updateErr != nil {
+					log.Error("[Failed] Setting Container Error", logger.Fields{"error": updateErr})
 				}
-			} else {
-				handleErrors(err, containerName)
+				return
 			}
-		}
-
-		// ListBlobs returns the start of the next segment (used for pagination purpose)
-		blobMarker = listBlob.NextMarker
-
-		// Process the blobs returned in this result segment (if the segment is empty, the loop body won't execute)
-		for _, blobInfo := range listBlob.Blobs.Blob {
-			blobFileExt := filepath.Ext(blobInfo.Name)
 
-			if isValidExtension(fileExceptionList, blobFileExt) {
-				fileCount++ // File Counter
+			// ListBlobs returns the start of the next segment (used for pagination purpose)
+			blobMarker = listBlob.NextMarker
 
-				var count int
-				stmt, _ := dbConnection.Prepare("select count(*) from sync where container=? and blob=?")
-				stmt.QueryRow(containerName, blobInfo.Name).Scan(&count)
+			// Hierarchical Listing: Descending into Virtual Directories, unless excluded
+			for _, blobPrefix := range listBlob.Blobs.BlobPrefix {
+				if scope.allows(blobPrefix.Name) {
+					prefixQueue = append(prefixQueue, blobPrefix.Name)
+				} else {
+					log.Debug("[Skipping] excluded directory.", logger.Fields{"prefix": blobPrefix.Name})
+					skippedCount++
+				}
+			}
 
-				if count == 0 {
-					// Preparing Statement
-					insertStatement, statementError := dbConnection.Prepare("INSERT INTO sync(container, blob, created_at) values(?,?,?)")
-					handleErrors(statementError, "Insert Container Prepare Failed")
+			// Process the blobs returned in this result segment (if the segment is empty, the loop body won't execute)
+			var pageRows []database.SyncRow
+			for _, blobInfo := range listBlob.Blobs.Blob {
+				blobFileExt := filepath.Ext(blobInfo.Name)
+
+				if isValidExtension(fileExceptionList, blobFileExt) && scope.allows(blobInfo.Name) {
+					fileCount++ // File Counter
+
+					pageRows = append(pageRows, database.SyncRow{Container: containerName, Blob: blobInfo.Name, Snapshot: endpoint.FormatSnapshot(blobInfo.Snapshot), Properties: database.BlobProperties{
+						ContentType:        stringValue(blobInfo.Properties.ContentType),
+						CacheControl:       stringValue(blobInfo.Properties.CacheControl),
+						ContentDisposition: stringValue(blobInfo.Properties.ContentDisposition),
+						ContentEncoding:    stringValue(blobInfo.Properties.ContentEncoding),
+						Metadata:           blobInfo.Metadata,
+						Size:               int64Value(blobInfo.Properties.ContentLength),
+					}})
+				} else {
+					skippedCount++
+				}
+			}
 
-					// Executing Statement
-					insertResponse, insertError := insertStatement.Exec(containerName, blobInfo.Name, time.Now().Local())
-					if insertError == nil {
-						// Fetch Last Insert ID
-						id, dbError := insertResponse.LastInsertId()
-						handleErrors(dbError, strconv.FormatInt(id, 10))
+			if env.DryRun { // Reported per container
+				existing, countErr := database.CountExistingBlobs(containerName, pageRows)
+				if countErr != nil {
+					log.Warn("[Dry Run] Unable to count existing blobs", logger.Fields{"error": countErr})
+				}
+				newRows += int64(len(pageRows) - existing)
+				continue
+			}
 
-						fmt.Println("Container: ", containerName, "| Blob: ", blobInfo.Name)
-						fmt.Println("Mapping Completed!")
-					}
-					// handleErrors(insertError, "Insert Container Execute Failed")
+			// Inserting Page in the Writer's Batched Transaction(s)
+			for idx, result := range database.InsertBlobs(pageRows) {
+				blobName, snapshot := pageRows[idx].Blob, pageRows[idx].Snapshot
+				if result.Err != nil {
+					log.Error("Mapping Failed!", logger.Fields{"blob": blobName, "snapshot": snapshot, "error": result.Err})
+					failures.Record(metrics.StageSync, containerName, blobName, result.Err)
+					metrics.FilesFailed.WithLabelValues(metrics.StageSync).Inc()
+					mappingErr = result.Err
+				} else if result.Affected != 0 {
+					log.Debug("Mapping Completed!", logger.Fields{"blob": blobName, "snapshot": snapshot})
+					metrics.FilesCompleted.WithLabelValues(metrics.StageSync).Inc()
 				} else {
-					fmt.Println("[Skipping] ", containerName, "-->", blobInfo.Name, " already exists.")
+					log.Debug("[Skipping] already exists.", logger.Fields{"blob": blobName, "snapshot": snapshot})
 				}
 			}
 		}
 	}
 
-	// Updating status flag in containers table
-	updateContainerQuery, _ := dbConnection.Prepare("UPDATE containers SET status = ? WHERE name = ?")
-
-	// Checking if container was empty
-	if fileCount == 0 {
+	// Checking if container was empty (blob(s) filtered out by scope don't make it empty)
+	if fileCount == 0 && skippedCount == 0 {
 		containerStatus = blobNotFound
 	} else {
 		if isLiveContainer(liveContainerList, containerName) {
@@ -287,43 +408,53 @@ func traverseContainerWorker(wg *sync.WaitGroup, containerName string, dbConnect
 		}
 	}
 
+	if env.DryRun {
+		atomic.AddInt64(&dryRunRows, newRows)
+		log.Info(fmt.Sprintf("[Dry Run] would insert %s sync rows", helpers.FormatCount(newRows)))
+		log.Info(fmt.Sprintf("[Dry Run] would set container status %d", containerStatus))
+		return
+	}
+
+	// Unmapped Blob(s): Container is left pending (with error) for the next run
+	if mappingErr != nil {
+		if updateErr = database.SetContainerError(containerName, mappingErr.Error()); updateErr != nil {
+			log.Error("[Failed] Setting Container Error", logger.Fields{"error": updateErr})
+		}
+		return
+	}
+
+	// Command Line Prefix: only part of the container's scope was listed
+	if len(env.Prefix) != 0 {
+		log.Info("[Success] Prefix Traversed, container status unchanged", logger.Fields{"prefix": env.Prefix, "blobs": fileCount, "skipped": skippedCount, "duration": time.Since(startTime)})
+		return
+	}
+
 	// Updating Container Status after Process Completion
-	_, updateErr = updateContainerQuery.Exec(containerStatus, containerName)
-	if updateErr != nil {
-		fmt.Println("[Failed] Setting Completed Flag For: ", containerName)
+	if updateErr = database.SetContainerStatus(containerName, containerStatus); updateErr != nil {
+		log.Error("[Failed] Setting Completed Flag", logger.Fields{"error": updateErr})
+		failures.Record(metrics.StageSync, containerName, "", updateErr)
+		return
 	}
 
-	fmt.Println("[Success] Setting Completed Flag For: ", containerName)
+	log.Info("[Success] Setting Completed Flag", logger.Fields{"status": containerStatus, "blobs": fileCount, "skipped": skippedCount, "duration": time.Since(startTime)})
 }
 
 // Method to check if container doesn't belongs to live list.
 //
-// @param liveContainerList slice
+// @param liveContainerList set
 // @param containerName string
 // @return boolean
-func isLiveContainer(liveContainerList [16]string, containerName string) bool {
-	for _, liveContainer := range liveContainerList {
-		if liveContainer == containerName {
-			return true // Live
-		}
-	}
-
-	return false // Still
+func isLiveContainer(liveContainerList map[string]bool, containerName string) bool {
+	return liveContainerList[containerName]
 }
 
 // Method to check if container doesn't belongs to exceptions list.
 //
-// @param containerExceptionList slice
+// @param containerExceptionList set
 // @param containerName string
 // @return boolean
-func isValidContainer(containerExceptionList [15]string, containerName string) bool {
-	for _, exceptContainer := range containerExceptionList {
-		if exceptContainer == containerName {
-			return false // Match
-		}
-	}
-
-	return true // Un-Match
+func isValidContainer(containerExceptionList map[string]bool, containerName string) bool {
+	return !containerExceptionList[containerName]
 }
 
 // Method to check if file extension doesn't belongs to exceptions list.
@@ -341,10 +472,37 @@ func isValidExtension(fileExceptionList [3]string, azureFileExt string) bool {
 	return true // Un-Match
 }
 
-// Sleep For X Seconds
+// Dereference optional blob property
 //
-// @param second integer
+// @param value pointer
+// @return string
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+
+	return *value
+}
+
+// Dereference optional blob size
+//
+// @param value pointer
+// @return int64
+func int64Value(value *int64) int64 {
+	if value == nil {
+		return 0
+	}
+
+	return *value
+}
+
+// Sleep For X Seconds (returns early on interrupt)
+//
+// @param ctx Context, second integer
 // @return process get delayed by X seconds
-func sleepFor(second int) {
-	time.Sleep(time.Duration(second) * time.Second) // X Sec Halt
+func sleepFor(ctx context.Context, second int) {
+	select {
+	case <-time.After(time.Duration(second) * time.Second): // X Sec Halt
+	case <-ctx.Done():
+	}
 }
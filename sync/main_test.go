@@ -0,0 +1,285 @@
+// Namespace: sync/main_test.go
+
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"../database"    // DB Handler Package
+	"../endpoint"    // Azure Endpoint
+	"../failures"    // Run Error Summary
+	"../fakestorage" // Fake Blob / S3 Service(s)
+)
+
+// Fake storage account, a fresh DB and the blob sync setting(s) of a test
+func setupSync(t *testing.T) (*fakestorage.Azure, EnvVars) {
+	t.Helper()
+
+	fake := fakestorage.NewAzure("account")
+	t.Cleanup(fake.Close)
+	fakestorage.OpenDB(t)
+
+	azure, err := endpoint.ParseAzureConnectionString(fake.ConnectionString())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fake, EnvVars{Azure: azure, BlobFlag: true, Log: fakestorage.Logger(t)}
+}
+
+// Container name(s) per status
+func containerStatuses(t *testing.T) map[int]int64 {
+	t.Helper()
+
+	counts, err := database.GetContainerStatusCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return counts
+}
+
+func TestSyncBlobs(t *testing.T) {
+	fake, env := setupSync(t)
+	fake.PageSize = 1 // One ListBlobs call per blob
+	env.Snapshots = true
+
+	fake.PutBlob("media", "a.mp4", fakestorage.Blob{Content: []byte("a"), ContentType: "video/mp4", Metadata: map[string]string{"owner": "ops"}})
+	snapshot := fake.SnapshotBlob("media", "a.mp4", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	fake.PutBlob("media", "a.mp4", fakestorage.Blob{Content: []byte("a2"), ContentType: "video/mp4"})
+	fake.PutBlob("media", "dir/b.mp4", fakestorage.Blob{Content: []byte("b")})
+	fake.PutBlob("media", "c.xml", fakestorage.Blob{Content: []byte("<c/>")}) // Skipped extension
+	fake.CreateContainer("empty")
+	for _, container := range []string{"media", "empty"} {
+		if err := database.InsertInContainer(container); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !Run(context.Background(), env) {
+		t.Fatal("sync failed")
+	}
+
+	items, total, err := database.GetSyncItems("", "", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ blob, snapshot string }{{"a.mp4", snapshot}, {"a.mp4", ""}, {"dir/b.mp4", ""}}
+	if total != int64(len(want)) {
+		t.Fatalf("rows = %+v, want %d", items, len(want))
+	}
+	for idx, row := range want {
+		if items[idx].Container != "media" || items[idx].Blob != row.blob || items[idx].Snapshot != row.snapshot {
+			t.Errorf("row %d = %+v, want %s@%s", idx, items[idx], row.blob, row.snapshot)
+		}
+	}
+
+	if statuses := containerStatuses(t); statuses[traverseCompleted] != 1 || statuses[blobNotFound] != 1 {
+		t.Errorf("container statuses = %v", statuses)
+	}
+
+	// Second run: nothing pending, nothing inserted twice
+	if !Run(context.Background(), env) {
+		t.Fatal("second sync failed")
+	}
+	if _, total, _ = database.GetSyncItems("", "", 0, 10); total != int64(len(want)) {
+		t.Errorf("rows after second run = %d", total)
+	}
+}
+
+func TestSyncContainerNotFound(t *testing.T) {
+	_, env := setupSync(t)
+	if err := database.InsertInContainer("gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !Run(context.Background(), env) {
+		t.Fatal("sync failed")
+	}
+	if statuses := containerStatuses(t); statuses[containerNotFound] != 1 {
+		t.Errorf("container statuses = %v, want one %d", statuses, containerNotFound)
+	}
+}
+
+func TestSyncListingFailure(t *testing.T) {
+	fake, env := setupSync(t)
+	fake.PutBlob("media", "a.mp4", fakestorage.Blob{Content: []byte("a")})
+	if err := database.InsertInContainer("media"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Connection reset on every listing: the container is left pending, with its error
+	fake.Inject(fakestorage.Fault{Path: "/account/media", Query: "comp", Kind: fakestorage.Reset})
+	before := failures.Count()
+	if !Run(context.Background(), env) {
+		t.Fatal("sync failed")
+	}
+	if statuses := containerStatuses(t); statuses[0] != 1 || failures.Count() == before {
+		t.Fatalf("container statuses = %v, failure(s) recorded = %d", statuses, failures.Count()-before)
+	}
+
+	// Next run retries the container
+	fake.ClearFaults()
+	if !Run(context.Background(), env) {
+		t.Fatal("retry sync failed")
+	}
+	if statuses := containerStatuses(t); statuses[traverseCompleted] != 1 {
+		t.Errorf("container statuses after retry = %v", statuses)
+	}
+}
+
+func TestSyncListingThrottled(t *testing.T) {
+	fake, env := setupSync(t)
+	fake.PutBlob("media", "a.mp4", fakestorage.Blob{Content: []byte("a")})
+	if err := database.InsertInContainer("media"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Throttled once: retried by the request pipeline within the same run
+	fake.Inject(fakestorage.Fault{Path: "/account/media", Query: "comp", Kind: fakestorage.Throttle, Times: 1})
+	if !Run(context.Background(), env) {
+		t.Fatal("sync failed")
+	}
+	if statuses := containerStatuses(t); statuses[traverseCompleted] != 1 {
+		t.Errorf("container statuses = %v", statuses)
+	}
+	if hits := fake.Hits("GET", "/account/media"); hits != 2 {
+		t.Errorf("listing request(s) = %d, want 2", hits)
+	}
+}
+
+func TestSyncContainerRegistry(t *testing.T) {
+	fake, env := setupSync(t)
+	fake.PutBlob("media", "a.mp4", fakestorage.Blob{Content: []byte("a")})
+	fake.PutBlob("clips", "b.mp4", fakestorage.Blob{Content: []byte("b")})
+	fake.PutBlob("test", "c.mp4", fakestorage.Blob{Content: []byte("c")}) // Excluded by default
+
+	if _, err := database.RegistryAdd(database.RegistryExclude, "clips"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.RegistryAdd(database.RegistryLive, "media"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Container listing skips the excluded container(s)
+	env.ContainerFlag, env.BlobFlag = true, false
+	if !Run(context.Background(), env) {
+		t.Fatal("container sync failed")
+	}
+	for container, want := range map[string]bool{"media": true, "clips": false, "test": false} {
+		if database.ContainerExists(container) != want {
+			t.Errorf("container %s listed = %v, want %v", container, !want, want)
+		}
+	}
+
+	// Blob sync marks the live container(s) live, which -reset-live resets
+	env.ContainerFlag, env.BlobFlag = false, true
+	if !Run(context.Background(), env) {
+		t.Fatal("blob sync failed")
+	}
+	if statuses := containerStatuses(t); statuses[liveContainer] != 1 {
+		t.Errorf("container statuses = %v, want one %d", statuses, liveContainer)
+	}
+	if live, err := database.IsLiveContainer("media"); err != nil || !live {
+		t.Errorf("IsLiveContainer(media) = %v, %v", live, err)
+	}
+	if err := database.ResetLiveContainer(); err != nil {
+		t.Fatal(err)
+	}
+	if statuses := containerStatuses(t); statuses[0] != 1 {
+		t.Errorf("container statuses after reset = %v, want one pending", statuses)
+	}
+
+	// Removed from the live list: traversed container(s) are no longer live
+	if !Run(context.Background(), env) {
+		t.Fatal("second blob sync failed")
+	}
+	if removed, err := database.RegistryRemove(database.RegistryLive, "media"); err != nil || !removed {
+		t.Fatalf("RegistryRemove = %v, %v", removed, err)
+	}
+	if statuses := containerStatuses(t); statuses[traverseCompleted] != 1 {
+		t.Errorf("container statuses after removal = %v, want one %d", statuses, traverseCompleted)
+	}
+	if live, _ := database.IsLiveContainer("media"); live {
+		t.Error("media still live after removal")
+	}
+}
+
+func TestSyncScopeFiltersEveryBlob(t *testing.T) {
+	fake, env := setupSync(t)
+	fake.PutBlob("media", "tmp/a.mp4", fakestorage.Blob{Content: []byte("a")})
+	fake.PutBlob("media", "b.xml", fakestorage.Blob{Content: []byte("<b/>")}) // Skipped extension
+	fake.CreateContainer("empty")
+	for _, container := range []string{"media", "empty"} {
+		if err := database.InsertInContainer(container); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.Scopes = map[string]Scope{"*": {Exclude: []string{"tmp/"}}}
+
+	// Nothing to sync in either container, but only the empty one has no blob
+	if !Run(context.Background(), env) {
+		t.Fatal("sync failed")
+	}
+	if statuses := containerStatuses(t); statuses[traverseCompleted] != 1 || statuses[blobNotFound] != 1 {
+		t.Errorf("container statuses = %v, want one %d and one %d", statuses, traverseCompleted, blobNotFound)
+	}
+}
+
+func TestSyncSnapshotsWithDelimiter(t *testing.T) {
+	fake, env := setupSync(t)
+	fake.PutBlob("media", "keep/a.mp4", fakestorage.Blob{Content: []byte("a")})
+	snapshot := fake.SnapshotBlob("media", "keep/a.mp4", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	fake.PutBlob("media", "tmp/b.mp4", fakestorage.Blob{Content: []byte("b")})
+	if err := database.InsertInContainer("media"); err != nil {
+		t.Fatal(err)
+	}
+	env.Snapshots = true
+	env.Scopes = map[string]Scope{"media": {Exclude: []string{"tmp/"}, Delimiter: "/"}}
+
+	// Listed flat (Azure rejects snapshot(s) in a hierarchical listing), the excluded directory filtered by name
+	if !Run(context.Background(), env) {
+		t.Fatal("sync failed")
+	}
+	items, total, err := database.GetSyncItems("", "", 0, 10)
+	if err != nil || total != 2 || items[0].Blob != "keep/a.mp4" || items[0].Snapshot != snapshot || items[1].Snapshot != "" {
+		t.Fatalf("rows = %+v, %v", items, err)
+	}
+	if statuses := containerStatuses(t); statuses[traverseCompleted] != 1 {
+		t.Errorf("container statuses = %v", statuses)
+	}
+}
+
+func TestSyncPrefixEveryContainer(t *testing.T) {
+	fake, env := setupSync(t)
+	env.Prefix = "2019/"
+
+	for _, container := range []string{"media", "videos"} {
+		fake.PutBlob(container, "2019/a.mp4", fakestorage.Blob{Content: []byte("a")})
+		fake.PutBlob(container, "2020/b.mp4", fakestorage.Blob{Content: []byte("b")})
+		if err := database.InsertInContainer(container); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Container(s) left pending by the prefix: traversed once, then the run returns
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !Run(ctx, env) || ctx.Err() != nil {
+		t.Fatalf("prefix sync did not return (%v)", ctx.Err())
+	}
+
+	for _, container := range []string{"media", "videos"} {
+		if listings := fake.Hits("GET", "/account/"+container); listings != 1 {
+			t.Errorf("%s listed %d time(s), want once", container, listings)
+		}
+	}
+	if statuses := containerStatuses(t); statuses[0] != 2 {
+		t.Errorf("container statuses = %v, want both pending", statuses)
+	}
+	if items, total, err := database.GetSyncItems("pending", "azure", 0, 10); err != nil || total != 2 || items[0].Blob != "2019/a.mp4" {
+		t.Errorf("sync rows = %+v (%d), %v; want 2019/a.mp4 of both container(s)", items, total, err)
+	}
+}
@@ -0,0 +1,87 @@
+// Namespace: sync/scope.go
+
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Scope - Virtual directories of a container which are synced
+type Scope struct {
+	Include   []string `json:"include"`   // Listed prefix(es), none: whole container
+	Exclude   []string `json:"exclude"`   // Skipped prefix(es), also when they are below an include
+	Delimiter string   `json:"delimiter"` // Hierarchical listing (e.g. "/"), so excluded directories are never listed
+}
+
+// LoadScopes - Per-container scope(s) of a JSON file (.env: SYNC_SCOPE_FILE), "*" applies to every other container:
+// {"videos": {"include": ["2019/"], "exclude": ["2019/raw/"], "delimiter": "/"}, "*": {"exclude": ["tmp/"]}}
+func LoadScopes(path string) (map[string]Scope, error) {
+	scopes := map[string]Scope{}
+	if len(path) == 0 {
+		return scopes, nil
+	}
+
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	if decodeErr := json.Unmarshal(content, &scopes); decodeErr != nil {
+		return nil, fmt.Errorf("invalid scope file %s: %v", path, decodeErr)
+	}
+
+	return scopes, nil
+}
+
+// Scope of a container: its own entry (or "*"), with -prefix / -delimiter of the command line on top
+//
+// @param env EnvVars struct, containerName string
+// @return Scope
+func containerScope(env EnvVars, containerName string) Scope {
+	scope, ok := env.Scopes[containerName]
+	if !ok {
+		scope = env.Scopes["*"]
+	}
+
+	if len(env.Prefix) != 0 {
+		scope.Include = []string{env.Prefix}
+	}
+	if len(env.Delimiter) != 0 {
+		scope.Delimiter = env.Delimiter
+	}
+
+	return scope
+}
+
+// Prefix(es) to list, the empty prefix lists the whole container
+func (s Scope) prefixes() []string {
+	if len(s.Include) == 0 {
+		return []string{""}
+	}
+
+	return s.Include
+}
+
+// Check if a blob (or virtual directory) is inside the scope
+func (s Scope) allows(name string) bool {
+	for _, exclude := range s.Exclude {
+		if strings.HasPrefix(name, exclude) {
+			return false // Excluded
+		}
+	}
+
+	if len(s.Include) == 0 {
+		return true // Whole Container
+	}
+
+	for _, include := range s.Include {
+		if strings.HasPrefix(name, include) {
+			return true // Included
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,247 @@
+// Namespace: throttle/main.go
+
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt" // Byte Format
+)
+
+// Window - Rate applied between two times of day (wraps past midnight if End < Start)
+type Window struct {
+	Start, End time.Duration // Offset from midnight
+	Rate       int64         // Bytes per second (0: unlimited)
+}
+
+// Limiter - Token bucket shared by every worker of a direction
+type Limiter struct {
+	mutex    sync.Mutex
+	rate     int64    // Default bytes per second (0: unlimited)
+	schedule []Window // Time-of-day overrides for rate
+	parent   *Limiter // Global limit, shared by both directions
+	tokens   float64
+	last     time.Time
+}
+
+// NewLimiter - Create token bucket (parent is optional)
+//
+// @param rate integer, schedule slice, parent pointer
+// @return pointer
+func NewLimiter(rate int64, schedule []Window, parent *Limiter) *Limiter {
+	return &Limiter{rate: rate, schedule: schedule, parent: parent, last: time.Now()}
+}
+
+// Rate - Bytes per second currently in effect (0: unlimited)
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+
+	now := time.Now()
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+	for _, window := range l.schedule {
+		if window.contains(clock) {
+			return window.Rate
+		}
+	}
+
+	return l.rate
+}
+
+// WaitN - Block until n bytes may be transferred (returns the context error once ctx is cancelled)
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	for remaining := int64(n); remaining > 0; {
+		rate := l.Rate()
+		if rate <= 0 {
+			break // Unlimited
+		}
+
+		// Bucket holds at most one second worth of tokens
+		chunk := remaining
+		if chunk > rate {
+			chunk = rate
+		}
+
+		l.mutex.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+		if l.tokens > float64(rate) {
+			l.tokens = float64(rate)
+		}
+		l.last = now
+		l.tokens -= float64(chunk) // Reserve (may go negative)
+
+		var delay time.Duration
+		if l.tokens < 0 {
+			delay = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+		}
+		l.mutex.Unlock()
+
+		if err := sleepFor(ctx, delay); err != nil {
+			return err
+		}
+		remaining -= chunk
+	}
+
+	return l.parent.WaitN(ctx, n)
+}
+
+// Reader - Wrap reader so that every read is charged to the limiter (reads fail once ctx is cancelled)
+func (l *Limiter) Reader(ctx context.Context, reader io.Reader) io.Reader {
+	if l == nil {
+		return reader
+	}
+
+	return &limitedReader{ctx: ctx, reader: reader, limiter: l}
+}
+
+// Transport - Wrap HTTP transport so that every request body is charged to the limiter
+func (l *Limiter) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if l == nil {
+		return base
+	}
+
+	return &limitedTransport{base: base, limiter: l}
+}
+
+// Rate limited io.Reader
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *Limiter
+}
+
+// Read - Read and wait for the bytes read
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+		return n, waitErr
+	}
+
+	return n, err
+}
+
+// Rate limited io.ReadCloser (request body)
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Rate limited http.RoundTripper
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter *Limiter
+}
+
+// RoundTrip - Send request with rate limited body
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		limitedReq := req.Clone(req.Context())
+		limitedReq.Body = limitedReadCloser{Reader: t.limiter.Reader(req.Context(), req.Body), Closer: req.Body}
+		req = limitedReq
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// Sleep for delay, or until ctx is cancelled
+//
+// @param ctx Context, delay Duration
+// @return error
+func sleepFor(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Check if time of day falls in window
+func (w Window) contains(clock time.Duration) bool {
+	if w.Start <= w.End {
+		return clock >= w.Start && clock < w.End
+	}
+
+	return clock >= w.Start || clock < w.End // Wraps past midnight
+}
+
+// ParseRate - Parse rate such as "50MB/s", "512K" or "unlimited" into bytes per second
+func ParseRate(value string) (int64, error) {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "/s"))
+	if len(value) == 0 || value == "0" || strings.EqualFold(value, "unlimited") {
+		return 0, nil
+	}
+
+	rate, err := bytefmt.ToBytes(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %v", value, err)
+	}
+
+	return int64(rate), nil
+}
+
+// ParseSchedule - Parse schedule such as "08:00-18:00=10MB/s,18:00-08:00=unlimited"
+func ParseSchedule(value string) ([]Window, error) {
+	var schedule []Window
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		times := strings.SplitN(parts[0], "-", 2)
+		if len(parts) != 2 || len(times) != 2 {
+			return nil, fmt.Errorf("invalid schedule entry %q, expected HH:MM-HH:MM=RATE", entry)
+		}
+
+		start, startErr := parseClock(times[0])
+		end, endErr := parseClock(times[1])
+		if startErr != nil || endErr != nil {
+			return nil, fmt.Errorf("invalid schedule time in %q", entry)
+		}
+
+		rate, rateErr := ParseRate(parts[1])
+		if rateErr != nil {
+			return nil, rateErr
+		}
+
+		schedule = append(schedule, Window{Start: start, End: end, Rate: rate})
+	}
+
+	return schedule, nil
+}
+
+// Parse HH:MM into offset from midnight
+func parseClock(value string) (time.Duration, error) {
+	clock, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
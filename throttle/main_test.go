@@ -0,0 +1,111 @@
+// Namespace: throttle/main_test.go
+
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Limiter whose bucket is full (idle for longer than a second)
+func fullLimiter(rate int64, parent *Limiter) *Limiter {
+	limiter := NewLimiter(rate, nil, parent)
+	limiter.last = time.Now().Add(-time.Hour)
+
+	return limiter
+}
+
+func TestWaitN(t *testing.T) {
+	cases := []struct {
+		name     string
+		limiter  func() *Limiter // Built when the case runs: an idle bucket fills up
+		n        int
+		min, max time.Duration
+	}{
+		{"unlimited", func() *Limiter { return NewLimiter(0, nil, nil) }, 1 << 20, 0, 50 * time.Millisecond},
+		{"nil limiter", func() *Limiter { return nil }, 1 << 20, 0, 50 * time.Millisecond},
+		{"rate", func() *Limiter { return NewLimiter(10000, nil, nil) }, 2000, 150 * time.Millisecond, 600 * time.Millisecond},    // Empty bucket: 0.2s
+		{"burst", func() *Limiter { return fullLimiter(10000, nil) }, 10000, 0, 100 * time.Millisecond},                           // One second worth of token(s)
+		{"past burst", func() *Limiter { return fullLimiter(10000, nil) }, 12000, 150 * time.Millisecond, 600 * time.Millisecond}, // Burst, then 0.2s
+		{"parent limits child", func() *Limiter { return NewLimiter(0, nil, NewLimiter(10000, nil, nil)) }, 2000, 150 * time.Millisecond, 600 * time.Millisecond},
+		{"child limits parent", func() *Limiter { return NewLimiter(10000, nil, fullLimiter(1<<20, nil)) }, 2000, 150 * time.Millisecond, 600 * time.Millisecond},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+			if err := tc.limiter().WaitN(context.Background(), tc.n); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed < tc.min || elapsed > tc.max {
+				t.Errorf("WaitN(%d) took %v, want %v..%v", tc.n, elapsed, tc.min, tc.max)
+			}
+		})
+	}
+}
+
+func TestWaitNCancelled(t *testing.T) {
+	cases := map[string]*Limiter{
+		"own rate":    NewLimiter(100, nil, nil),                         // 1s for 100 byte(s)
+		"parent rate": NewLimiter(0, nil, NewLimiter(100, nil, nil)),     // Waiting on the parent
+		"child rate":  NewLimiter(100, nil, NewLimiter(1<<20, nil, nil)), // Waiting before the parent
+	}
+
+	for name, limiter := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			if err := limiter.WaitN(ctx, 100); err != context.DeadlineExceeded {
+				t.Errorf("WaitN error = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("WaitN returned after %v, want about 20ms", elapsed)
+			}
+		})
+	}
+}
+
+func TestReaderCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	reader := NewLimiter(100, nil, nil).Reader(ctx, &zeroReader{})
+	if n, err := reader.Read(make([]byte, 100)); n != 100 || err != context.Canceled {
+		t.Errorf("Read = %d, %v; want 100, %v", n, err, context.Canceled)
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("08:00-18:00=10MB/s, 18:00-08:00=unlimited")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Window{{Start: 8 * time.Hour, End: 18 * time.Hour, Rate: 10 << 20}, {Start: 18 * time.Hour, End: 8 * time.Hour}}
+	if len(schedule) != len(want) || schedule[0] != want[0] || schedule[1] != want[1] {
+		t.Errorf("ParseSchedule = %+v, want %+v", schedule, want)
+	}
+	if !schedule[1].contains(23*time.Hour) || !schedule[1].contains(time.Hour) || schedule[1].contains(12*time.Hour) {
+		t.Error("window past midnight: wrong time(s) of day")
+	}
+
+	for _, value := range []string{"08:00=1MB", "8-18=1MB", "08:00-18:00=lots"} {
+		if _, err := ParseSchedule(value); err == nil {
+			t.Errorf("ParseSchedule(%q): no error", value)
+		}
+	}
+}
+
+// Endless reader of zero byte(s)
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for idx := range p {
+		p[idx] = 0
+	}
+
+	return len(p), nil
+}
@@ -3,136 +3,378 @@
 package s3
 
 import (
+	"context"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws/credentials"
+	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"../../database" // DB Handler Package
-	"../../helpers"  // Helper Package
+	"../../api"       // Status API
+	"../../database"  // DB Handler Package
+	"../../endpoint"  // S3 Endpoint
+	"../../failures"  // Run Error Summary
+	"../../helpers"   // Helper Package
+	"../../logger"    // Leveled Logger
+	"../../metrics"   // Prometheus Metrics
+	"../../retention" // Local Copy Retention Policy
+	"../../throttle"  // Bandwidth Throttling
 
+	"code.cloudfoundry.org/bytefmt"                  // Byte Format
 	"github.com/aws/aws-sdk-go/aws"                  // AWS Core SDK
 	"github.com/aws/aws-sdk-go/aws/session"          // Maintains AWS Session
+	"github.com/aws/aws-sdk-go/service/s3"           // AWS S3 API (Bucket Versioning)
 	"github.com/aws/aws-sdk-go/service/s3/s3manager" // AWS S3 Manager (Upload/Upload Data)
 )
 
 // Global Constant(s)
 const (
-	statusCompleted = 1
-	statusFailed    = 2
+	statusCompleted   = 1
+	statusFailed      = 2
+	statusInterrupted = 3
 )
 
+// Snapshot Mode(s): how a blob snapshot row is stored in the bucket
+const (
+	SnapshotSuffix   = "suffix"   // Own object: base key + SnapshotSuffix
+	SnapshotVersions = "versions" // Object version of the base key (bucket versioning), uploaded oldest first
+)
+
+// Global Variable(s)
+var dryRunFiles, dryRunBytes int64 // Upload(s) which a dry run would perform
+
 // EnvVars Struct
 type EnvVars struct {
-	AWSKey, AWSSecret, AWSBucket, AWSRegion string
-	MediaFolder                             string
+	S3                  endpoint.S3 // Bucket / Endpoint / Credential Chain
+	MediaFolder         string
+	ContentTypeFallback bool              // Infer Content-Type from file extension
+	SnapshotMode        string            // SnapshotSuffix (default) or SnapshotVersions
+	SnapshotSuffix      string            // Key suffix of a snapshot, "{snapshot}" is the snapshot time (SnapshotSuffix mode)
+	DryRun              bool              // Report upload(s) without any S3 put
+	Limiter             *throttle.Limiter // Shared by every upload worker (nil: unlimited)
+	Retention           retention.Policy  // Deletion of the local copy after a verified upload (zero: keep)
+	Log                 *logger.Logger    // Leveled Logger
 }
 
 // Run - Entry Point for Azure Content Upload to S3
-func Run(env EnvVars) bool {
-	fmt.Println("Upload Azure Content to S3...")
+func Run(ctx context.Context, env EnvVars) bool {
+	env.Log.Info("Upload Azure Content to S3...", logger.Fields{"bucket": env.S3.String()})
+
+	// One Session (credential(s), connection pool) shared by every upload worker
+	sess, err := env.S3.Session(func(transport http.RoundTripper) http.RoundTripper {
+		return env.Limiter.Transport(api.Transport(metrics.Transport(transport)))
+	})
+	if err != nil {
+		env.Log.Error("Session Error", logger.Fields{"error": err})
+		failures.Record(metrics.StageUpload, "", "", err)
+		return false
+	}
+	uploader := s3manager.NewUploader(sess)
+
+	if env.SnapshotMode == SnapshotVersions {
+		checkVersioning(ctx, env, sess)
+	}
 
-	initiateUpload(env)
+	if err := initiateUpload(ctx, env, uploader, 0); err != nil {
+		env.Log.Error("Upload Queue Failed", logger.Fields{"error": err})
+		failures.Record(metrics.StageUpload, "", "", err)
+		return false
+	}
+
+	if env.DryRun {
+		env.Log.Info(fmt.Sprintf("[Dry Run] would upload %s files (%s) to bucket %s", helpers.FormatCount(dryRunFiles), bytefmt.ByteSize(uint64(dryRunBytes)), env.S3.Bucket),
+			logger.Fields{"files": dryRunFiles, "bytes": dryRunBytes})
+	}
 
 	return true
 }
 
 // Initiate Upload of Azure Data
 //
+// @param ctx Context (cancelled on interrupt)
 // @param env EnvVars struct
-// @return nil
-func initiateUpload(env EnvVars) {
+// @param uploader S3 Uploader (shared session)
+// @param offset integer (dry run only, as statuses are not updated)
+// @return error (pending rows could not be fetched)
+func initiateUpload(ctx context.Context, env EnvVars, uploader *s3manager.Uploader, offset int) error {
 	var wg sync.WaitGroup // Checks if traversing gets completed.
 
-	// Getting Pending Upload from Sync Table
-	syncList := database.GetPendingS3Content()
+	// Getting Pending Upload from Sync Table (claimed by this worker, so other process(es) skip them)
+	var syncList map[int]map[string]string
+	var err error
+	if env.DryRun {
+		syncList, err = database.GetPendingS3Content(ctx, offset)
+	} else {
+		syncList, err = database.ClaimS3Content(ctx, 10, env.SnapshotMode == SnapshotVersions)
+	}
+	if err != nil {
+		return err
+	}
 
-	// Initializing Empty Upload Queue
-	uploadQueue := make(map[string]string)
+	// Initializing Empty Upload Queue (object key per row: snapshot(s) of a blob share its name)
+	uploadQueue := make(map[int]string)
 
 	// Creating Queue
 	for idx := 0; idx < len(syncList); idx++ {
-		downlodedBlob := helpers.ProcessBlobName(syncList[idx]["container"], syncList[idx]["blob"])
-		uploadQueue[syncList[idx]["blob"]] = downlodedBlob
+		uploadQueue[idx] = ObjectKey(syncList[idx]["container"], syncList[idx]["blob"], syncList[idx]["snapshot"], env)
 	}
 
-	// Processing 10 Media Files Concurrently
-	for idx := 0; idx < len(syncList); idx++ {
-		go startWorker(&wg, syncList[idx], uploadQueue, env)
-		wg.Add(1)
-	}
+	if env.DryRun {
+		reportUpload(syncList, uploadQueue, env)
+		offset += len(syncList)
+	} else {
+		// Processing 10 Media Files Concurrently (worker(s) only read the queue)
+		pending := int64(len(syncList))
+		for idx := 0; idx < len(syncList); idx++ {
+			wg.Add(1)
+			go startWorker(ctx, &wg, uploader, syncList[idx], uploadQueue[idx], &pending, env)
+		}
 
-	// Waiting for worker to finish upload.
-	wg.Wait()
+		// Waiting for worker to finish upload.
+		wg.Wait()
+	}
 
 	// Recursion Implementation:
-	if len(syncList) != 0 {
-		fmt.Println("-------------------------------------------------------")
-		fmt.Println("[Recursion] Fetching New Data...")
-		fmt.Println("-------------------------------------------------------")
-		initiateUpload(env)
+	if len(syncList) != 0 && ctx.Err() == nil {
+		env.Log.Info("[Recursion] Fetching New Data...")
+		return initiateUpload(ctx, env, uploader, offset)
+	}
+
+	return nil
+}
+
+// Report Upload(s) without any S3 Put (Dry Run)
+//
+// @param syncList Maps, uploadQueue Maps, env EnvVars struct
+// @return nil
+func reportUpload(syncList map[int]map[string]string, uploadQueue map[int]string, env EnvVars) {
+	for idx := 0; idx < len(syncList); idx++ {
+		blobName, snapshot := syncList[idx]["blob"], syncList[idx]["snapshot"]
+		objectKey := uploadQueue[idx]
+		fileName := localFileName(syncList[idx], env)
+
+		fileInfo, err := os.Stat(env.MediaFolder + fileName)
+		if err != nil {
+			env.Log.Warn("[Dry Run] would fail to upload", logger.Fields{"container": syncList[idx]["container"], "blob": blobName, "snapshot": snapshot, "error": err})
+			continue
+		}
+
+		uploadInput := &s3manager.UploadInput{Key: aws.String(objectKey)}
+		setObjectHeaders(uploadInput, syncList[idx], env)
+
+		dryRunFiles++
+		dryRunBytes += fileInfo.Size()
+		env.Log.Info(fmt.Sprintf("[Dry Run] would upload %s to key %s", bytefmt.ByteSize(uint64(fileInfo.Size())), objectKey),
+			logger.Fields{"container": syncList[idx]["container"], "blob": blobName, "snapshot": snapshot, "bytes": fileInfo.Size(), "content_type": aws.StringValue(uploadInput.ContentType)})
 	}
 }
 
 // Start Worker to Upload Blob
 //
-// @param wg WaitGroup, syncContent Maps, uploadQueue Maps, env EnvVars struct
+// @param ctx Context, wg WaitGroup, uploader S3 Uploader, syncContent Maps, objectKey string, pending counter, env EnvVars struct
 // @return nil
-func startWorker(wg *sync.WaitGroup, syncContent map[string]string, uploadQueue map[string]string, env EnvVars) {
+func startWorker(ctx context.Context, wg *sync.WaitGroup, uploader *s3manager.Uploader, syncContent map[string]string, objectKey string, pending *int64, env EnvVars) {
 	defer wg.Done() // Work Completed
 
-	fmt.Println("Uploading: ", syncContent["blob"])
+	metrics.InFlightWorkers.WithLabelValues(metrics.StageUpload).Inc()
+	defer metrics.InFlightWorkers.WithLabelValues(metrics.StageUpload).Dec()
 
-	// From the Azure portal, get your Storage account blob service URL endpoint.
-	awsKey, awsSecret, awsBucket, awsRegion := env.AWSKey, env.AWSSecret, env.AWSBucket, env.AWSRegion
 	mediaFolder := env.MediaFolder
-	containerName, blobName := syncContent["container"], syncContent["blob"]
+	containerName, blobName, snapshot := syncContent["container"], syncContent["blob"], syncContent["snapshot"]
 
-	file, err := os.Open(mediaFolder + uploadQueue[blobName])
-	exitErrorf(err, "Unable to open file %q, %v", err)
+	log := env.Log.With(logger.Fields{"container": containerName, "blob": blobName, "snapshot": snapshot})
+	log.Info("Uploading", logger.Fields{"key": objectKey})
+	startTime := time.Now()
 
-	defer file.Close()
+	// Version Order: an older snapshot which failed / was shipped won't be a version before this one
+	if env.SnapshotMode == SnapshotVersions {
+		if skipped, skipErr := database.SkippedOlderSnapshot(containerName, blobName, snapshot); skipErr != nil {
+			log.Warn("Unable to check older snapshots", logger.Fields{"error": skipErr})
+		} else if len(skipped) != 0 {
+			orderErr := fmt.Errorf("older snapshot %s failed or was shipped, uploaded without it (object versions out of order)", skipped)
+			log.Error("[Version Order]", logger.Fields{"error": orderErr})
+			failures.Record(metrics.StageUpload, containerName, blobName, orderErr)
+		}
+	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(awsRegion),
-		Credentials: credentials.NewStaticCredentials(awsKey, awsSecret, ""),
-	})
-	exitErrorf(err, "Session Error", err)
+	transfer := api.StartTransfer(metrics.StageUpload, containerName, blobName)
+	defer transfer.Done()
 
-	uploader := s3manager.NewUploader(sess)
+	defer func() {
+		log.Debug("Pending File(s)", logger.Fields{"pending": atomic.AddInt64(pending, -1)})
+	}()
+
+	// Renewing Lease while transferring (a lost lease cancels the transfer, like an interrupt)
+	ctx, stopLease := database.KeepLease(ctx, containerName, blobName, snapshot)
+	defer stopLease()
 
-	resp, uploadErr := uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(awsBucket),
-		Key:    aws.String(uploadQueue[blobName]),
+	file, err := os.Open(mediaFolder + localFileName(syncContent, env))
+	if err != nil {
+		log.Error("Unable to open file", logger.Fields{"error": err})
+		failUpload(log, containerName, blobName, snapshot, err)
+		return
+	}
+	defer file.Close()
+
+	// Size of the local copy: progress total, and checked against the object before the copy is deleted
+	fileInfo, statErr := file.Stat()
+	if statErr == nil {
+		transfer.SetTotal(fileInfo.Size())
+	}
+
+	uploadInput := &s3manager.UploadInput{
+		Bucket: aws.String(env.S3.Bucket),
+		Key:    aws.String(objectKey),
 		Body:   file,
 		ACL:    aws.String("public-read"),
-	}, func(u *s3manager.Uploader) {
+	}
+	setObjectHeaders(uploadInput, syncContent, env)
+
+	resp, uploadErr := uploader.UploadWithContext(api.WithTransfer(ctx, transfer), uploadInput, func(u *s3manager.Uploader) {
 		u.PartSize = 10 * 1024 * 1024 // 10MB part size
 		u.LeavePartsOnError = true    // Don't delete the parts if the upload fails.
 		u.Concurrency = 20
 	})
 
-	if uploadErr != nil {
-		fmt.Println("[Upload Error]", blobName)
-		delete(uploadQueue, blobName)
-		database.SetS3Flag(containerName, blobName, statusFailed, uploadErr.Error())
+	if uploadErr != nil && ctx.Err() != nil { // Interrupted: Picked up by the next run
+		log.Warn("Upload Interrupted", logger.Fields{"duration": time.Since(startTime)})
+		recordFlagError(log, containerName, blobName, database.SetS3Flag(containerName, blobName, snapshot, statusInterrupted, "interrupted"))
+	} else if uploadErr != nil {
+		log.Error("[Upload Error]", logger.Fields{"duration": time.Since(startTime), "error": uploadErr})
+		failUpload(log, containerName, blobName, snapshot, uploadErr)
 	} else { // Upload Completed
-		fmt.Println("\n[Completed]: ", blobName)
 
-		database.SetS3Flag(containerName, blobName, statusCompleted, "")
-		// os.Remove(mediaFolder + uploadQueue[blobName]) // Delete Locale File it gets uploaded.
-		delete(uploadQueue, blobName)
+		flagErr := database.SetS3Flag(containerName, blobName, snapshot, statusCompleted, "")
+		recordFlagError(log, containerName, blobName, flagErr)
+		metrics.FilesCompleted.WithLabelValues(metrics.StageUpload).Inc()
 
-		fmt.Println("[S3] File Path", resp.Location) // File Path
+		completedFields := logger.Fields{"location": resp.Location, "version": aws.StringValue(resp.VersionID), "duration": time.Since(startTime)}
+		if statErr == nil {
+			completedFields["bytes"] = fileInfo.Size()
+		}
+		log.Info("[Completed]", completedFields)
+
+		// Deleting Local File once the object is verified (kept when the row isn't flagged, so prune never misses it)
+		if flagErr == nil && statErr != nil {
+			log.Warn("Local copy kept: unable to check its size", logger.Fields{"error": statErr})
+		} else if flagErr == nil {
+			file.Close()
+			removeLocalCopy(ctx, uploader, syncContent, objectKey, resp.VersionID, fileInfo.Size(), log, env)
+		}
+	}
+}
+
+// Delete local copy of an uploaded file when the retention policy says so, after checking the object in S3
+//
+// @param ctx Context, uploader S3 Uploader, syncContent Maps, objectKey string, versionID string pointer (nil: unversioned),
+// size integer (local file), log Logger, env EnvVars struct
+// @return nil
+func removeLocalCopy(ctx context.Context, uploader *s3manager.Uploader, syncContent map[string]string, objectKey string, versionID *string, size int64, log *logger.Logger, env EnvVars) {
+	live := false
+	if env.Retention.Mode == retention.LiveOnly {
+		var liveErr error
+		if live, liveErr = database.IsLiveContainer(syncContent["container"]); liveErr != nil {
+			log.Warn("Local copy kept: unable to check live container", logger.Fields{"error": liveErr})
+			return
+		}
+	}
+	if !env.Retention.DeleteOnUpload(live) {
+		return
+	}
+
+	head, headErr := uploader.S3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(env.S3.Bucket), Key: aws.String(objectKey), VersionId: versionID})
+	if headErr != nil {
+		log.Warn("Local copy kept: unable to verify uploaded object", logger.Fields{"key": objectKey, "error": headErr})
+		return
+	} else if aws.Int64Value(head.ContentLength) != size {
+		log.Warn("Local copy kept: uploaded object size differs", logger.Fields{"key": objectKey, "bytes": size, "object_bytes": aws.Int64Value(head.ContentLength)})
+		return
+	}
+
+	if err := os.Remove(env.MediaFolder + localFileName(syncContent, env)); err != nil {
+		log.Warn("Unable to delete local copy", logger.Fields{"error": err})
+		return
 	}
 
-	fmt.Println("Pending File(s): ", uploadQueue)
+	log.Debug("Local copy deleted", logger.Fields{"policy": env.Retention.String(), "bytes": size})
 }
 
-// Error Handling
-func exitErrorf(err error, msg string, args ...interface{}) {
+// ObjectKey - Object key of a row: the snapshot suffix is appended to a snapshot's key, unless it's stored as an object version
+func ObjectKey(containerName string, blobName string, snapshot string, env EnvVars) string {
+	processedBlob := helpers.ProcessBlobName(containerName, blobName)
+	if env.SnapshotMode == SnapshotVersions {
+		return processedBlob // Version of the base object
+	}
+
+	return helpers.SnapshotName(processedBlob, snapshot, env.SnapshotSuffix)
+}
+
+// Downloaded file of a row (named by the download with the configured suffix, also in SnapshotVersions mode)
+//
+// @param syncContent Maps, env EnvVars
+// @return string
+func localFileName(syncContent map[string]string, env EnvVars) string {
+	return helpers.LocalFileName(syncContent["container"], syncContent["blob"], syncContent["snapshot"], env.SnapshotSuffix)
+}
+
+// Warn when snapshot(s) would overwrite the base object: versioning of the bucket is not enabled
+//
+// @param ctx Context, env EnvVars struct, sess AWS Session
+// @return nil
+func checkVersioning(ctx context.Context, env EnvVars, sess *session.Session) {
+	versioning, err := s3.New(sess).GetBucketVersioningWithContext(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(env.S3.Bucket)})
+	if err != nil {
+		env.Log.Warn("Unable to check bucket versioning", logger.Fields{"bucket": env.S3.Bucket, "error": err})
+	} else if aws.StringValue(versioning.Status) != s3.BucketVersioningStatusEnabled {
+		env.Log.Warn("Bucket versioning is not enabled, snapshot(s) overwrite their base object", logger.Fields{"bucket": env.S3.Bucket, "status": aws.StringValue(versioning.Status)})
+	}
+}
+
+// Map Azure blob HTTP headers and metadata onto the S3 object
+//
+// @param uploadInput pointer, syncContent Maps, env EnvVars struct
+// @return nil
+func setObjectHeaders(uploadInput *s3manager.UploadInput, syncContent map[string]string, env EnvVars) {
+	contentType := helpers.ResolveContentType(syncContent["content_type"], syncContent["blob"], env.ContentTypeFallback) // Extension of the blob, not of a snapshot key
+	if len(contentType) != 0 {
+		uploadInput.ContentType = aws.String(contentType)
+	}
+
+	if len(syncContent["cache_control"]) != 0 {
+		uploadInput.CacheControl = aws.String(syncContent["cache_control"])
+	}
+
+	if len(syncContent["content_disposition"]) != 0 {
+		uploadInput.ContentDisposition = aws.String(syncContent["content_disposition"])
+	}
+
+	if len(syncContent["content_encoding"]) != 0 {
+		uploadInput.ContentEncoding = aws.String(syncContent["content_encoding"])
+	}
+
+	if metadata := helpers.DecodeMetadata(syncContent["metadata"]); len(metadata) != 0 {
+		uploadInput.Metadata = aws.StringMap(metadata) // Sent as x-amz-meta-*
+	}
+}
+
+// Mark blob as failed (s3_status 2 + s3_error) and record it for the run summary
+//
+// @param log Logger, containerName string, blobName string, snapshot string, err error
+// @return nil
+func failUpload(log *logger.Logger, containerName string, blobName string, snapshot string, err error) {
+	failures.Record(metrics.StageUpload, containerName, blobName, err)
+	metrics.FilesFailed.WithLabelValues(metrics.StageUpload).Inc()
+	recordFlagError(log, containerName, blobName, database.SetS3Flag(containerName, blobName, snapshot, statusFailed, err.Error()))
+}
+
+// Record a failed sync table write of a blob for the run summary
+//
+// @param log Logger, containerName string, blobName string, err error
+// @return nil
+func recordFlagError(log *logger.Logger, containerName string, blobName string, err error) {
 	if err != nil {
-		fmt.Fprintf(os.Stderr, msg+"\n", args...)
-		os.Exit(1)
+		log.Error("[Failed] Updating Sync Table", logger.Fields{"error": err})
+		failures.Record(metrics.StageUpload, containerName, blobName, err)
 	}
 }
@@ -0,0 +1,254 @@
+// Namespace: upload/s3/main_test.go
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"../../database"    // DB Handler Package
+	"../../endpoint"    // S3 Endpoint
+	"../../failures"    // Run Error Summary
+	"../../fakestorage" // Fake Blob / S3 Service(s)
+	"../../helpers"     // Helper Package
+	"../../retention"   // Local Copy Retention Policy
+)
+
+// Fake S3 service with the bucket, a fresh DB, an empty media folder and the upload setting(s) of a test
+func setupUpload(t *testing.T, versioning bool) (*fakestorage.S3, EnvVars) {
+	t.Helper()
+
+	// Static credential(s) of the environment, nothing from the host's AWS config
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CA_BUNDLE", "")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	fake := fakestorage.NewS3()
+	t.Cleanup(fake.Close)
+	fake.CreateBucket("bucket", versioning)
+	fakestorage.OpenDB(t)
+
+	return fake, EnvVars{
+		S3:             endpoint.S3{Bucket: "bucket", Region: "us-east-1", Endpoint: fake.URL, PathStyle: true},
+		MediaFolder:    t.TempDir() + "/",
+		SnapshotMode:   SnapshotSuffix,
+		SnapshotSuffix: helpers.DefaultSnapshotSuffix,
+		Log:            fakestorage.Logger(t),
+	}
+}
+
+func TestUpload(t *testing.T) {
+	fake, env := setupUpload(t, false)
+
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "a.mp4", Properties: database.BlobProperties{
+		ContentType: "video/mp4", CacheControl: "max-age=60", Metadata: map[string]string{"owner": "ops"}}}, []byte("small"))
+	large := bytes.Repeat([]byte("0123456789"), 1100*1024) // 11MB: two 10MB part(s)
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "large.mp4"}, large)
+
+	if !Run(context.Background(), env) {
+		t.Fatal("upload failed")
+	}
+	if completed := fakestorage.RowsWithStatus(t, "completed", "s3"); len(completed) != 2 {
+		t.Fatalf("completed rows = %+v", completed)
+	}
+
+	small, ok := fake.GetObject("bucket", "a.mp4")
+	if !ok || string(small.Content) != "small" {
+		t.Fatalf("a.mp4 missing or corrupt")
+	}
+	if small.ContentType != "video/mp4" || small.CacheControl != "max-age=60" || small.ACL != "public-read" || small.Metadata["owner"] != "ops" {
+		t.Errorf("a.mp4 header(s) = %+v", small)
+	}
+
+	multipart, ok := fake.GetObject("bucket", "large.mp4")
+	if !ok || !bytes.Equal(multipart.Content, large) || multipart.ETag[len(multipart.ETag)-3:] != `-2"` {
+		t.Errorf("large.mp4: %d byte(s), etag %s", len(multipart.Content), multipart.ETag)
+	}
+	if fake.PendingUploads() != 0 {
+		t.Errorf("pending multipart upload(s) = %d", fake.PendingUploads())
+	}
+}
+
+func TestUploadRetried(t *testing.T) {
+	fake, env := setupUpload(t, false)
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "throttled.mp4"}, []byte("throttled"))
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "reset.mp4"}, []byte("reset"))
+
+	// Failing once: retried by the SDK
+	fake.Inject(fakestorage.Fault{Method: "PUT", Path: "/bucket/throttled.mp4", Kind: fakestorage.Throttle, Times: 1})
+	fake.Inject(fakestorage.Fault{Method: "PUT", Path: "/bucket/reset.mp4", Kind: fakestorage.Reset, Times: 1})
+
+	if !Run(context.Background(), env) {
+		t.Fatal("upload failed")
+	}
+	if completed := fakestorage.RowsWithStatus(t, "completed", "s3"); len(completed) != 2 {
+		t.Fatalf("completed rows = %+v", completed)
+	}
+	for _, key := range []string{"throttled.mp4", "reset.mp4"} {
+		if hits := fake.Hits("PUT", "/bucket/"+key); hits != 2 {
+			t.Errorf("%s: %d PUT request(s), want 2", key, hits)
+		}
+	}
+}
+
+func TestUploadFailed(t *testing.T) {
+	fake, env := setupUpload(t, false)
+	env.S3.Bucket = "missing"
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "a.mp4"}, []byte("a"))
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "b.mp4"}, []byte("b"))
+
+	if !Run(context.Background(), env) {
+		t.Fatal("upload failed")
+	}
+
+	failed := fakestorage.RowsWithStatus(t, "failed", "s3")
+	if len(failed) != 2 {
+		t.Fatalf("failed rows = %+v", failed)
+	}
+	for _, row := range failed {
+		if len(row.S3Error) == 0 {
+			t.Errorf("%s: no error recorded", row.Blob)
+		}
+	}
+	if keys := fake.Keys("bucket"); len(keys) != 0 {
+		t.Errorf("uploaded key(s) = %v", keys)
+	}
+}
+
+func TestUploadSnapshots(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Format("2006-01-02T15:04:05.0000000Z")
+	newer := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC).Format("2006-01-02T15:04:05.0000000Z")
+
+	// Object version(s) of the base key, oldest first
+	fake, env := setupUpload(t, true)
+	env.SnapshotMode = SnapshotVersions
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "a.mp4"}, []byte("current"))
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "a.mp4", Snapshot: newer}, []byte("newer"))
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "a.mp4", Snapshot: older}, []byte("older"))
+
+	if !Run(context.Background(), env) {
+		t.Fatal("upload failed")
+	}
+	versions := fake.Versions("bucket", "a.mp4")
+	if len(versions) != 3 || string(versions[0].Content) != "older" || string(versions[1].Content) != "newer" || string(versions[2].Content) != "current" {
+		t.Fatalf("%d version(s) of a.mp4", len(versions))
+	}
+	if keys := fake.Keys("bucket"); len(keys) != 1 {
+		t.Errorf("key(s) = %v", keys)
+	}
+}
+
+func TestUploadSnapshotsAfterFailedSnapshot(t *testing.T) {
+	oldest := time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC).Format("2006-01-02T15:04:05.0000000Z")
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Format("2006-01-02T15:04:05.0000000Z")
+	newer := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC).Format("2006-01-02T15:04:05.0000000Z")
+
+	fake, env := setupUpload(t, true)
+	env.SnapshotMode = SnapshotVersions
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "a.mp4"}, []byte("current"))
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "a.mp4", Snapshot: newer}, []byte("newer"))
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "a.mp4", Snapshot: older}, []byte("older"))
+	if err := database.SetS3Flag("media", "a.mp4", older, 2, "upload failed for good"); err != nil {
+		t.Fatal(err)
+	}
+	database.InsertBlobs([]database.SyncRow{{Container: "media", Blob: "a.mp4", Snapshot: oldest}})
+	if err := database.SetAzureFlag("media", "a.mp4", oldest, 2, "download failed"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failed older snapshot(s) don't hold the newer one and the base blob back, the broken order is reported
+	before := failures.Count()
+	if !Run(context.Background(), env) {
+		t.Fatal("upload failed")
+	}
+	versions := fake.Versions("bucket", "a.mp4")
+	if len(versions) != 2 || string(versions[0].Content) != "newer" || string(versions[1].Content) != "current" {
+		t.Fatalf("%d version(s) of a.mp4", len(versions))
+	}
+	if reported := failures.Count() - before; reported != 2 {
+		t.Errorf("%d failure(s) reported, want 2 (newer snapshot, base blob)", reported)
+	}
+}
+
+func TestUploadSnapshotSuffix(t *testing.T) {
+	snapshot := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Format("2006-01-02T15:04:05.0000000Z")
+
+	fake, env := setupUpload(t, false)
+	env.SnapshotSuffix = ".v{snapshot}"
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "a.mp4"}, []byte("current"))
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "a.mp4", Snapshot: snapshot}, []byte("older"))
+
+	if !Run(context.Background(), env) {
+		t.Fatal("upload failed")
+	}
+	if object, ok := fake.GetObject("bucket", "a.mp4.v"+snapshot); !ok || string(object.Content) != "older" {
+		t.Errorf("snapshot object missing, key(s) = %v", fake.Keys("bucket"))
+	}
+	if object, ok := fake.GetObject("bucket", "a.mp4"); !ok || string(object.Content) != "current" {
+		t.Errorf("base object missing, key(s) = %v", fake.Keys("bucket"))
+	}
+}
+
+func TestUploadRetention(t *testing.T) {
+	fake, env := setupUpload(t, false)
+	env.Retention = retention.Policy{Mode: retention.LiveOnly}
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "a.mp4"}, []byte("a"))
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "unverified.mp4"}, []byte("b"))
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "reports", Blob: "c.pdf"}, []byte("c"))
+
+	// Object can't be verified: local copy kept for prune
+	fake.Inject(fakestorage.Fault{Method: "HEAD", Path: "/bucket/unverified.mp4", Kind: fakestorage.NotFound})
+
+	if !Run(context.Background(), env) {
+		t.Fatal("upload failed")
+	}
+	if completed := fakestorage.RowsWithStatus(t, "completed", "s3"); len(completed) != 3 {
+		t.Fatalf("completed rows = %+v", completed)
+	}
+
+	for blob, kept := range map[string]bool{"a.mp4": false, "unverified.mp4": true, "c.pdf": true} { // c.pdf: live container
+		if _, err := os.Stat(env.MediaFolder + blob); (err == nil) != kept {
+			t.Errorf("%s: local copy kept = %v, want %v", blob, err == nil, kept)
+		}
+	}
+}
+
+func TestUploadDryRun(t *testing.T) {
+	fake, env := setupUpload(t, false)
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "a.mp4"}, []byte("small"))
+	fakestorage.AddDownloaded(t, env.MediaFolder, env.SnapshotSuffix, database.SyncRow{Container: "media", Blob: "b.mp4"}, []byte("media"))
+
+	database.SetDryRun(true)
+	defer database.SetDryRun(false)
+	env.DryRun = true
+	files := dryRunFiles
+
+	if !Run(context.Background(), env) {
+		t.Fatal("dry run failed")
+	}
+	if reported := dryRunFiles - files; reported != 2 {
+		t.Errorf("dry run reported %d file(s), want 2", reported)
+	}
+
+	// Nothing put, every row still pending, local copies kept
+	if keys := fake.Keys("bucket"); len(keys) != 0 || fake.PendingUploads() != 0 {
+		t.Errorf("object(s) put by a dry run: %v", keys)
+	}
+	if pending := fakestorage.RowsWithStatus(t, "pending", "s3"); len(pending) != 2 {
+		t.Errorf("pending rows = %+v", pending)
+	}
+	for _, name := range []string{"a.mp4", "b.mp4"} {
+		if _, err := os.Stat(env.MediaFolder + name); err != nil {
+			t.Errorf("local copy %s: %v", name, err)
+		}
+	}
+}